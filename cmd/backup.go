@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/backup"
+	"github.com/portdeveloper/alex/internal/leakcheck"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+const archiveLabel = "backup archive"
+
+var (
+	backupOutputFile    string
+	backupRecipient     string
+	backupForce         bool
+	archivePassphraseFD int
+
+	restoreIdentity  string
+	restoreDryRun    bool
+	restoreConflicts []string
+	restoreRelinks   []string
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write every store and setting to one encrypted archive",
+	Long: `Unlock the global store and every group and project store and write
+them, with ~/.alex/config.json, to a single archive.
+
+The archive is encrypted to a passphrase, or to an age or SSH public key
+with --recipient. It does not depend on this machine's ID, so it can be
+restored anywhere with 'alex restore'. Project stores are named by a hash
+of the project; the archive also records each project's git remote or
+path, so restored stores can be linked to moved checkouts.
+
+Examples:
+  alex backup -o alex-backup.age
+  alex backup -o alex-backup.age --recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+  alex backup -o alex-backup.age --recipient "$(cat ~/.ssh/id_ed25519.pub)"`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !backupForce {
+			if _, err := os.Stat(backupOutputFile); err == nil {
+				exitWithError(fmt.Sprintf("%s already exists (use --force to overwrite)", backupOutputFile), nil)
+			}
+		}
+
+		archive, failures, err := backup.Collect(newUnlocker(false))
+		if err != nil {
+			exitWithError("reading stores", err)
+		}
+		if len(failures) > 0 {
+			for _, f := range failures {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", f.Name, f.Err)
+			}
+			exitWithError(fmt.Sprintf("%d store(s) could not be unlocked, nothing was written", len(failures)), errors.New("see messages above"))
+		}
+		if len(archive.Stores) == 0 {
+			exitWithError("no stores to back up", nil)
+		}
+
+		var key *secrets.Key
+		if backupRecipient != "" {
+			key, err = secrets.RecipientKey(backupRecipient)
+		} else {
+			key, err = archivePassphrase(readConfirmedPassphrase).Key(archiveLabel)
+		}
+		if err != nil {
+			exitWithError("getting the backup key", err)
+		}
+
+		sealed, err := backup.Seal(archive, key)
+		if err != nil {
+			exitWithError("encrypting backup", err)
+		}
+		if err := os.WriteFile(backupOutputFile, sealed, 0600); err != nil {
+			exitWithError("writing backup", err)
+		}
+
+		out := backupOutput{File: backupOutputFile, EncryptedTo: "passphrase", Stores: []backupStore{}, Files: []string{}}
+		if backupRecipient != "" {
+			out.EncryptedTo = key.Recipient
+		}
+		for _, s := range archive.Stores {
+			out.Stores = append(out.Stores, backupStore{Store: s.Label(), Project: s.Project, Secrets: len(s.Secrets)})
+		}
+		for name := range archive.Files {
+			out.Files = append(out.Files, name)
+		}
+		sort.Strings(out.Files)
+
+		if machineOutput() {
+			printResult("backup", out)
+			return
+		}
+		for _, s := range out.Stores {
+			fmt.Printf("✓ %s: %d secret(s)\n", s.Store, s.Secrets)
+		}
+		for _, name := range out.Files {
+			fmt.Printf("✓ %s\n", name)
+		}
+		fmt.Printf("\nBackup written to %s, encrypted to %s\n", out.File, out.EncryptedTo)
+		fmt.Println("It holds your secrets in full: keep it as safe as the stores themselves.")
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore FILE",
+	Short: "Merge an archive written by alex backup into your stores",
+	Long: `Decrypt and validate an archive written by 'alex backup' and merge it into
+the stores on this machine. New stores are keyed like any store alex
+creates, normally with this machine's ID.
+
+Secrets missing locally are always added. When a store exists on both
+sides, --on-conflict decides:
+
+  keep       secrets in both keep their local value (default)
+  overwrite  secrets in both get the archive's value
+  skip       the local store is left alone
+
+Give a policy for single stores with ID=POLICY, where ID is "global",
+"group:NAME" or a project ID. --relink ID=PATH restores a project store
+to the project checked out at PATH, e.g. a project without a git remote
+that moved.
+--dry-run shows what would change without writing anything.
+
+Examples:
+  alex restore alex-backup.age --dry-run
+  alex restore alex-backup.age --on-conflict overwrite --on-conflict global=keep
+  alex restore alex-backup.age --identity ~/.config/age/keys.txt
+  alex restore alex-backup.age --relink 3f2a9c81d0e4=$HOME/src/api`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := restoreOptions()
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			exitWithError("reading backup", err)
+		}
+		key, err := restoreKey()
+		if err != nil {
+			exitWithError("getting the backup key", err)
+		}
+		archive, err := backup.Open(data, key)
+		if err != nil {
+			exitWithError("opening backup", err)
+		}
+		checkRestoreOptions(archive, opts)
+
+		unlocker := newUnlocker(false)
+		results, files, err := backup.Restore(archive, unlocker, opts)
+		if err != nil {
+			exitWithError("restoring backup", err)
+		}
+
+		reportRestore("restore", archive, results, files, unlocker, restoreDryRun)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd, restoreCmd)
+	backupCmd.Flags().StringVarP(&backupOutputFile, "out", "o", "alex-backup.age", "File to write the archive to")
+	backupCmd.Flags().StringVarP(&backupRecipient, "recipient", "r", "", "Encrypt to an age or SSH public key instead of a passphrase")
+	backupCmd.Flags().BoolVar(&backupForce, "force", false, "Overwrite an existing file")
+	restoreCmd.Flags().StringVarP(&restoreIdentity, "identity", "i", "", "Age identity file, SSH key or plugin identity the archive was encrypted to")
+	addRestoreFlags(restoreCmd)
+	for _, c := range []*cobra.Command{backupCmd, restoreCmd} {
+		c.Flags().IntVar(&archivePassphraseFD, "archive-passphrase-fd", -1, "Read the archive passphrase from this file descriptor")
+	}
+}
+
+// addRestoreFlags adds the flags read by restoreOptions to cmd
+func addRestoreFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Show what would change without writing anything")
+	cmd.Flags().StringArrayVar(&restoreConflicts, "on-conflict", nil, "keep, overwrite or skip, for every store or as ID=POLICY for one")
+	cmd.Flags().StringArrayVar(&restoreRelinks, "relink", nil, "Restore project store ID to the project at PATH (ID=PATH)")
+}
+
+// backupOutput is the machine-readable result of backup
+type backupOutput struct {
+	File        string        `json:"file"`
+	EncryptedTo string        `json:"encrypted_to"`
+	Stores      []backupStore `json:"stores"`
+	Files       []string      `json:"files"`
+}
+
+type backupStore struct {
+	Store   string               `json:"store"`
+	Project *secrets.ProjectInfo `json:"project,omitempty"`
+	Secrets int                  `json:"secrets"`
+}
+
+// restoreOutput is the machine-readable result of restore
+type restoreOutput struct {
+	DryRun bool            `json:"dry_run"`
+	Stores []restoreStatus `json:"stores"`
+	Files  []restoreFile   `json:"files"`
+}
+
+type restoreStatus struct {
+	Store        string               `json:"store"`
+	Name         string               `json:"name"`
+	Dir          string               `json:"dir"`
+	Project      *secrets.ProjectInfo `json:"project,omitempty"`
+	RelinkedFrom string               `json:"relinked_from,omitempty"`
+	Status       string               `json:"status"`
+	Added        []string             `json:"added"`
+	Replaced     []string             `json:"replaced"`
+	Kept         []string             `json:"kept"`
+	Error        string               `json:"error,omitempty"`
+}
+
+type restoreFile struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// restoreOptions reads --on-conflict, --relink and --dry-run
+func restoreOptions() backup.Options {
+	opts := backup.Options{DryRun: restoreDryRun, Stores: make(map[string]backup.Conflict), Relink: make(map[string]secrets.ProjectInfo)}
+	for _, c := range restoreConflicts {
+		name, policy, perStore := strings.Cut(c, "=")
+		if !perStore {
+			policy = name
+		}
+		conflict, err := backup.ParseConflict(policy)
+		if err != nil {
+			exitWithError("invalid --on-conflict", err)
+		}
+		if perStore {
+			opts.Stores[name] = conflict
+		} else {
+			opts.Conflict = conflict
+		}
+	}
+	for _, r := range restoreRelinks {
+		id, path, ok := strings.Cut(r, "=")
+		if !ok {
+			exitWithError(fmt.Sprintf("invalid --relink %q (want ID=PATH)", r), nil)
+		}
+		project, err := secrets.ProjectAt(path)
+		if err != nil {
+			exitWithError(fmt.Sprintf("reading project at %s", path), err)
+		}
+		opts.Relink[id] = project
+	}
+	return opts
+}
+
+// reportRestore prints what backup.Restore did, refreshes the pre-commit
+// hash index if stores changed, and exits with an error if any store failed
+func reportRestore(kind string, archive *backup.Archive, results []backup.Result, files []backup.FileResult, unlocker *secrets.Unlocker, dryRun bool) {
+	out := restoreOutput{DryRun: dryRun, Stores: []restoreStatus{}, Files: []restoreFile{}}
+	failed := 0
+	for _, r := range results {
+		status := restoreStatus{
+			Store:    r.Store.Label(),
+			Name:     r.Name,
+			Dir:      r.Dir,
+			Project:  r.Store.Project,
+			Status:   r.Status,
+			Added:    nonNil(r.Added),
+			Replaced: nonNil(r.Replaced),
+			Kept:     nonNil(r.Kept),
+		}
+		if r.Name != r.Store.Name {
+			status.RelinkedFrom = r.Store.Name
+		}
+		if r.Err != nil {
+			status.Error = r.Err.Error()
+			failed++
+		}
+		out.Stores = append(out.Stores, status)
+	}
+	for _, f := range files {
+		out.Files = append(out.Files, restoreFile{Name: f.Name, Status: f.Status})
+	}
+
+	changed := false
+	for _, s := range out.Stores {
+		changed = changed || s.Status == backup.StatusCreated || s.Status == backup.StatusMerged
+	}
+	if changed && !dryRun {
+		if alexDir, err := secrets.GetGlobalDir(); err == nil && leakcheck.Exists(alexDir) {
+			if _, err := rebuildLeakIndex(unlocker); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not update pre-commit hash index: %v\n", err)
+			}
+		}
+	}
+
+	if machineOutput() {
+		printResult(kind, out)
+	} else {
+		printRestore(out, archive)
+	}
+	if failed > 0 {
+		exitWithError(fmt.Sprintf("%d store(s) could not be restored", failed), errors.New("see messages above"))
+	}
+}
+
+func printRestore(out restoreOutput, archive *backup.Archive) {
+	fmt.Printf("Archive from %s, %s\n\n", archive.Host, archive.CreatedAt.Local().Format("2006-01-02 15:04"))
+	for _, f := range out.Files {
+		fmt.Printf("  %s: %s\n", f.Name, f.Status)
+	}
+	for _, s := range out.Stores {
+		label := s.Store
+		if s.RelinkedFrom != "" {
+			label += " → project " + s.Name
+		}
+		switch s.Status {
+		case backup.StatusFailed:
+			fmt.Fprintf(os.Stderr, "✗ %s: %s\n", label, s.Error)
+			continue
+		case backup.StatusSkipped:
+			fmt.Printf("- %s: skipped, exists locally\n", label)
+			continue
+		}
+		fmt.Printf("✓ %s: %s (%d added, %d replaced, %d kept)\n", label, s.Status, len(s.Added), len(s.Replaced), len(s.Kept))
+		if len(s.Kept) > 0 {
+			fmt.Printf("    kept local value of %s\n", strings.Join(s.Kept, ", "))
+		}
+	}
+	if out.DryRun {
+		fmt.Println("\nDry run: nothing was changed.")
+	}
+}
+
+// checkRestoreOptions exits if --on-conflict or --relink name stores the
+// archive doesn't have
+func checkRestoreOptions(archive *backup.Archive, opts backup.Options) {
+	for name := range opts.Stores {
+		if !archiveHasStore(archive, name) {
+			exitWithError(fmt.Sprintf("--on-conflict: no store %s in archive", name), nil)
+		}
+	}
+	for id := range opts.Relink {
+		if !archiveHasStore(archive, id) || id == backup.GlobalStore || strings.HasPrefix(id, "group:") {
+			exitWithError(fmt.Sprintf("--relink: no project store %s in archive", id), nil)
+		}
+	}
+}
+
+func archiveHasStore(archive *backup.Archive, name string) bool {
+	for _, s := range archive.Stores {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// archivePassphrase supplies the archive passphrase from
+// --archive-passphrase-fd or the terminal
+func archivePassphrase(prompt func(string) (string, error)) secrets.KeyProvider {
+	if archivePassphraseFD >= 0 {
+		return secrets.PassphraseFD(archivePassphraseFD)
+	}
+	return secrets.PromptPassphrase(prompt)
+}
+
+// restoreKey returns the key to decrypt the archive with: the identity
+// from --identity, or a passphrase
+func restoreKey() (*secrets.Key, error) {
+	if restoreIdentity == "" {
+		return archivePassphrase(readHiddenInput).Key(archiveLabel)
+	}
+	data, err := os.ReadFile(restoreIdentity)
+	if err != nil {
+		return nil, err
+	}
+	var provider secrets.KeyProvider
+	switch {
+	case strings.Contains(string(data), "AGE-SECRET-KEY-"):
+		provider = secrets.IdentityFile(restoreIdentity)
+	case strings.Contains(string(data), "AGE-PLUGIN-"):
+		provider = secrets.AgePlugin(restoreIdentity, pluginUI())
+	default:
+		provider = secrets.SSHKey(restoreIdentity, readHiddenInput)
+	}
+	return provider.Key(archiveLabel)
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate shell completion scripts",
+	Long: `Generate a completion script for your shell.
+
+Completions cover commands and flags, secret names for 'alex set',
+'alex unset' and 'alex fingerprint' (from the scope selected by --global or
+--group), the sides of 'alex diff', and the wrapped
+command after 'alex run', which is completed with that command's own
+completions (e.g. 'alex run -- git che<TAB>').
+
+Secret names are only completed when the store can be unlocked with the
+machine key; completion never prompts for a passphrase.
+
+Setup:
+  bash  echo 'source <(alex completion bash)' >> ~/.bashrc
+        (needs the bash-completion package for 'alex run' passthrough)
+  zsh   echo 'source <(alex completion zsh)' >> ~/.zshrc
+        (after compinit)
+  fish  alex completion fish > ~/.config/fish/completions/alex.fish`,
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := writeCompletion(os.Stdout, args[0]); err != nil {
+			exitWithError("generating completion script", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+	setCmd.ValidArgsFunction = completeSecretNames
+	unsetCmd.ValidArgsFunction = completeSecretNames
+	fingerprintCmd.ValidArgsFunction = completeSecretNames
+	diffCmd.ValidArgsFunction = completeDiffSide
+	runCmd.ValidArgsFunction = completeRunCommand
+}
+
+// writeCompletion writes cobra's completion script for shell followed by
+// the alex run passthrough addendum
+func writeCompletion(w io.Writer, shell string) error {
+	var err error
+	switch shell {
+	case "bash":
+		err = rootCmd.GenBashCompletionV2(w, true)
+	case "zsh":
+		err = rootCmd.GenZshCompletion(w)
+	case "fish":
+		err = rootCmd.GenFishCompletion(w, true)
+	default:
+		return fmt.Errorf("unsupported shell %q", shell)
+	}
+	if err != nil {
+		return err
+	}
+	addendum := strings.ReplaceAll(runPassthrough[shell], "{{valueFlags}}", strings.Join(runValueFlags(), " "))
+	_, err = io.WriteString(w, addendum)
+	return err
+}
+
+// runValueFlags returns the flags of alex and 'alex run' that take their
+// value as the next word, e.g. "--project api". The passthrough addenda
+// skip that word, which is not the wrapped command.
+func runValueFlags() []string {
+	var names []string
+	add := func(f *pflag.Flag) {
+		// Boolean flags have a value when given without one
+		if f.NoOptDefVal != "" {
+			return
+		}
+		names = append(names, "--"+f.Name)
+		if f.Shorthand != "" {
+			names = append(names, "-"+f.Shorthand)
+		}
+	}
+	rootCmd.PersistentFlags().VisitAll(add)
+	runCmd.Flags().VisitAll(add)
+	sort.Strings(names)
+	return names
+}
+
+// completeSecretNames completes the first argument with secret names from
+// the scope selected by --global or --group
+func completeSecretNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	global, _ := cmd.Flags().GetBool("global")
+	group, _ := cmd.Flags().GetString("group")
+
+	var names []string
+	for _, name := range storedSecretNames(global, group) {
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// storedSecretNames returns the names in a store, or nothing if the store
+// needs a passphrase or a key that may prompt. Completion must never prompt.
+func storedSecretNames(global bool, group string) []string {
+	unlocker := &secrets.Unlocker{NoCommands: true}
+
+	var store *secrets.Store
+	var err error
+	if global {
+		store, err = unlocker.OpenGlobal()
+	} else if group != "" {
+		store, err = unlocker.OpenGroup(group)
+	} else {
+		store, err = unlocker.OpenProject()
+	}
+	if err != nil {
+		return nil
+	}
+	return sortedSecretNames(store.List())
+}
+
+// completeRunCommand completes the command 'alex run' wraps. Its arguments
+// are handed to the command's own completion by the shell addenda; this
+// only runs for them when that isn't available, so fall back to files.
+func completeRunCommand(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || strings.Contains(toComplete, "/") {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return executablesOnPath(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// executablesOnPath returns the executables on $PATH starting with prefix
+func executablesOnPath(prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if seen[name] || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runPassthrough holds per-shell addenda that hand completion of the
+// arguments after 'alex run [flags] [--] COMMAND' to COMMAND's own
+// completion, and fall back to the cobra generated completion otherwise.
+// {{valueFlags}} is replaced with the flags that take a value.
+var runPassthrough = map[string]string{
+	"bash": `
+# alex run passthrough: complete the wrapped command's arguments with its
+# own completion (needs _command_offset from bash-completion)
+__alex_value_flags=" {{valueFlags}} "
+
+__alex_run_command_start()
+{
+    local i w seen_run=0
+    for (( i=1; i < COMP_CWORD; i++ )); do
+        w=${COMP_WORDS[i]}
+        if [[ $__alex_value_flags == *" $w "* ]]; then
+            # Skip the value, which bash splits off at '=' in --flag=value
+            [[ ${COMP_WORDS[i+1]} == = ]] && (( i++ ))
+            (( i++ ))
+            continue
+        fi
+        if (( seen_run == 0 )); then
+            if [[ $w == run ]]; then
+                seen_run=1
+                continue
+            fi
+            [[ $w == -* ]] && continue
+            return 1
+        fi
+        if [[ $w == -- ]]; then
+            echo $((i + 1))
+            return 0
+        fi
+        [[ $w == -* ]] && continue
+        echo $i
+        return 0
+    done
+    return 1
+}
+
+__alex_complete()
+{
+    local start
+    if start=$(__alex_run_command_start) && (( start < COMP_CWORD )) &&
+        declare -F _command_offset >/dev/null; then
+        _command_offset "$start"
+        return
+    fi
+    __start_alex
+}
+
+if [[ $(type -t compopt) = "builtin" ]]; then
+    complete -o default -F __alex_complete alex
+else
+    complete -o default -o nospace -F __alex_complete alex
+fi
+`,
+	"zsh": `
+# alex run passthrough: complete the wrapped command's arguments with its
+# own completion
+_alex_run_passthrough() {
+    local i w start=0 seen_run=0
+    local value_flags=" {{valueFlags}} "
+    for (( i=2; i < CURRENT; i++ )); do
+        w=${words[i]}
+        if [[ $value_flags == *" $w "* ]]; then
+            (( i++ ))
+            continue
+        fi
+        if (( seen_run == 0 )); then
+            if [[ $w == run ]]; then
+                seen_run=1
+                continue
+            fi
+            [[ $w == -* ]] && continue
+            break
+        fi
+        if [[ $w == -- ]]; then
+            start=$((i + 1))
+            break
+        fi
+        [[ $w == -* ]] && continue
+        start=$i
+        break
+    done
+
+    if (( start > 0 && start < CURRENT )); then
+        shift $((start - 1)) words
+        (( CURRENT -= start - 1 ))
+        _normal
+        return
+    fi
+    _alex "$@"
+}
+
+compdef _alex_run_passthrough alex
+`,
+	"fish": `
+# alex run passthrough: complete the wrapped command's arguments with its
+# own completion
+function __alex_run_command_start
+    set -l tokens (commandline -opc)
+    set -l seen_run 0
+    set -l skip 0
+    for i in (seq 2 (count $tokens))
+        if test $skip -eq 1
+            set skip 0
+            continue
+        end
+        set -l w $tokens[$i]
+        if contains -- $w {{valueFlags}}
+            set skip 1
+            continue
+        end
+        if test $seen_run -eq 0
+            if test "$w" = run
+                set seen_run 1
+                continue
+            end
+            string match -q -- '-*' $w; and continue
+            return 1
+        end
+        if test "$w" = --
+            test $i -lt (count $tokens); or return 1
+            echo (math $i + 1)
+            return 0
+        end
+        string match -q -- '-*' $w; and continue
+        echo $i
+        return 0
+    end
+    return 1
+end
+
+function __alex_run_passthrough
+    set -l start (__alex_run_command_start); or return
+    set -l tokens (commandline -opc)
+    set -l line (string join ' ' -- (string escape -- $tokens[$start..-1]))
+    set -l current (commandline -ct)
+    complete -C "$line $current"
+end
+
+complete -c alex -n '__alex_run_command_start >/dev/null' -f -a '(__alex_run_passthrough)'
+`,
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBashRunPassthroughStart(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	var script bytes.Buffer
+	if err := writeCompletion(&script, "bash"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "alex.bash")
+	if err := os.WriteFile(path, script.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// words are what bash puts in COMP_WORDS, ending with the word being
+	// completed; start is the index of the wrapped command, or -1
+	for _, tc := range []struct {
+		words []string
+		start int
+	}{
+		{[]string{"alex", "run", "npm", ""}, 2},
+		{[]string{"alex", "run", "--project", "api", "--", "npm", ""}, 5},
+		{[]string{"alex", "run", "--project", "api", "npm", ""}, 4},
+		{[]string{"alex", "run", "--project", "=", "api", "npm", ""}, 5},
+		{[]string{"alex", "--output", "json", "run", "make", ""}, 4},
+		{[]string{"alex", "run", "-f", "make", ""}, 3},
+		{[]string{"alex", "list", ""}, -1},
+	} {
+		quoted := make([]string, len(tc.words))
+		for i, w := range tc.words {
+			quoted[i] = strconv.Quote(w)
+		}
+		check := "source " + path + "\n" +
+			"COMP_WORDS=(" + strings.Join(quoted, " ") + ")\n" +
+			"COMP_CWORD=" + strconv.Itoa(len(tc.words)-1) + "\n" +
+			"__alex_run_command_start || echo -1\n"
+		out, err := exec.Command(bash, "-c", check).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%q: bash: %v\n%s", tc.words, err, out)
+		}
+		if got := strings.TrimSpace(string(out)); got != strconv.Itoa(tc.start) {
+			t.Errorf("%q: command starts at %s, want %d", tc.words, got, tc.start)
+		}
+	}
+}
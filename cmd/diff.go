@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/backup"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// ageHeader starts every age-encrypted file, which is how backups are
+// told apart from env files
+const ageHeader = "age-encryption.org/"
+
+var (
+	diffPassphrase bool
+	diffExitCode   bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff A B",
+	Short: "Compare two sets of secrets without showing their values",
+	Long: `Compare the secrets of two scopes, projects, env files or backups: which
+names only one side has, and which values are the same or differ.
+
+Values are compared by fingerprint, a keyed hash that only compares with
+other fingerprints from this machine, so none are shown. To compare with
+a teammate's machine, diff against their backup or compare the output of
+'alex fingerprint'.
+
+Each side is one of:
+  global, group:NAME     the global store or a group's
+  project, project:DIR   the current project's store or a subdirectory's
+  effective              what 'alex run' injects in the current directory
+  ID, NAME or PATH       a project store (see 'alex projects list')
+  FILE                   an env file such as .env.production
+  FILE#STORE             a store in a backup archive: global, group:NAME
+                         or a project ID (default: the current project's)
+
+Examples:
+  alex diff global project                 # Same names in both scopes?
+  alex diff project .env.production        # Does the store match an environment?
+  alex diff effective ../other-checkout    # Against another project
+  alex diff project laptop.age             # Against a backup of another machine
+  alex diff --exit-code group:staging group:prod`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		unlocker := newUnlocker(diffPassphrase)
+		a := openDiffSide(unlocker, args[0])
+		b := openDiffSide(unlocker, args[1])
+
+		out := buildDiff(a, b, fingerprintKey())
+		if machineOutput() {
+			printResult("secret_diff", out)
+		} else {
+			printDiff(os.Stdout, out)
+		}
+		if diffExitCode && !out.Identical() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with status 1 if the sides differ")
+	diffCmd.Flags().StringVarP(&restoreIdentity, "identity", "i", "", "Age identity file, SSH key or plugin identity a backup was encrypted to")
+	diffCmd.Flags().IntVar(&archivePassphraseFD, "archive-passphrase-fd", -1, "Read the backup passphrase from this file descriptor")
+}
+
+// completeDiffSide completes the sides of diff with the scopes and the
+// groups on this machine, and files for env files and backups
+func completeDiffSide(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 1 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	sides := []string{"global", "project", "effective"}
+	groups, _ := secrets.ListGroups()
+	for _, name := range groups {
+		sides = append(sides, scopeLabel("group", name))
+	}
+	return filterPrefix(sides, toComplete), cobra.ShellCompDirectiveDefault
+}
+
+// diffSide is one side of a diff: a set of secrets and what it is
+type diffSide struct {
+	ref    string
+	label  string
+	values map[string]string
+}
+
+// openDiffSide reads the secrets ref names, exiting if it names nothing
+func openDiffSide(unlocker *secrets.Unlocker, ref string) diffSide {
+	side := diffSide{ref: ref, label: ref}
+	var store *secrets.Store
+	var err error
+	switch {
+	case ref == "global":
+		store, err = unlocker.OpenGlobal()
+	case strings.HasPrefix(ref, "group:"):
+		store, err = unlocker.OpenGroup(strings.TrimPrefix(ref, "group:"))
+	case ref == "project" || strings.HasPrefix(ref, "project:"):
+		dir, cleanErr := secrets.CleanScopeDir(strings.TrimPrefix(strings.TrimPrefix(ref, "project"), ":"))
+		if cleanErr != nil {
+			exitWithCode(codeInvalidArgument, fmt.Sprintf("invalid side %q", ref), cleanErr)
+		}
+		project := secrets.ResolveProject()
+		side.label = scopeLabel("project", dir) + " " + project.ID
+		store, err = unlocker.OpenProjectScope(project.Scope(dir))
+	case ref == "effective":
+		side.label = "effective (what 'alex run' injects here)"
+		side.values = make(map[string]string)
+		for _, layer := range layerValuesOf(openLayers(unlocker)) {
+			for name, value := range layer.values {
+				side.values[name] = value
+			}
+		}
+		return side
+	default:
+		path, storeName, _ := strings.Cut(ref, "#")
+		if info, statErr := os.Stat(path); statErr == nil && !info.IsDir() {
+			return openDiffFile(path, storeName)
+		}
+		projectStore := findProjectStore(ref)
+		side.label = "project " + projectStore.ID
+		if name := projectStore.Name(); name != "" {
+			side.label += " (" + name + ")"
+		}
+		store, err = unlocker.Open(projectStore.Dir)
+	}
+	if err == nil {
+		side.values, err = store.GetAll()
+	}
+	if err != nil {
+		exitWithError(fmt.Sprintf("opening %s", ref), err)
+	}
+	return side
+}
+
+// openDiffFile reads the secrets of an env file, or of the store
+// storeName in a backup archive
+func openDiffFile(path, storeName string) diffSide {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError("reading "+path, err)
+	}
+	if !bytes.HasPrefix(data, []byte(ageHeader)) {
+		if storeName != "" {
+			exitWithCode(codeInvalidArgument, fmt.Sprintf("%s is not a backup: only backups hold stores to choose with #", path), nil)
+		}
+		parsed, err := parseEnvFile(path)
+		if err != nil {
+			exitWithError("parsing env file", err)
+		}
+		if len(parsed.skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s: skipped %d line(s): %s\n", path, len(parsed.skipped), strings.Join(parsed.skipped, "; "))
+		}
+		return diffSide{ref: path, label: "env file " + path, values: parsed.vars}
+	}
+
+	key, err := restoreKey()
+	if err != nil {
+		exitWithError("getting the backup key", err)
+	}
+	archive, err := backup.Open(data, key)
+	if err != nil {
+		exitWithError("opening backup", err)
+	}
+	if storeName == "" {
+		storeName = secrets.GetProjectID()
+	}
+	names := make([]string, 0, len(archive.Stores))
+	for _, s := range archive.Stores {
+		if s.Name != storeName {
+			names = append(names, s.Name)
+			continue
+		}
+		side := diffSide{ref: path + "#" + storeName, label: fmt.Sprintf("backup %s, %s", path, s.Label()), values: make(map[string]string)}
+		if archive.Host != "" {
+			side.label = fmt.Sprintf("backup %s from %s, %s", path, archive.Host, s.Label())
+		}
+		for name, secret := range s.Secrets {
+			side.values[name] = secret.Value
+		}
+		return side
+	}
+	exitWithCode(codeNotFound, fmt.Sprintf("no store %s in backup %s (it has: %s)", storeName, path, strings.Join(names, ", ")), nil)
+	return diffSide{}
+}
+
+// diffOutput is the machine-readable result of diff. It holds names
+// only, never values or fingerprints.
+type diffOutput struct {
+	A diffSideOutput `json:"a"`
+	B diffSideOutput `json:"b"`
+	// OnlyA and OnlyB are the names only one side has
+	OnlyA  []string `json:"only_a"`
+	OnlyB  []string `json:"only_b"`
+	Same   []string `json:"same"`
+	Differ []string `json:"differ"`
+}
+
+type diffSideOutput struct {
+	Ref     string `json:"ref"`
+	Label   string `json:"label"`
+	Secrets int    `json:"secrets"`
+}
+
+// Identical reports whether both sides hold the same names and values
+func (d diffOutput) Identical() bool {
+	return len(d.OnlyA) == 0 && len(d.OnlyB) == 0 && len(d.Differ) == 0
+}
+
+// buildDiff compares two sides by the fingerprints of their values under
+// key
+func buildDiff(a, b diffSide, key []byte) diffOutput {
+	out := diffOutput{
+		A:      diffSideOutput{Ref: a.ref, Label: a.label, Secrets: len(a.values)},
+		B:      diffSideOutput{Ref: b.ref, Label: b.label, Secrets: len(b.values)},
+		OnlyA:  []string{},
+		OnlyB:  []string{},
+		Same:   []string{},
+		Differ: []string{},
+	}
+	for name, value := range a.values {
+		other, ok := b.values[name]
+		switch {
+		case !ok:
+			out.OnlyA = append(out.OnlyA, name)
+		case secrets.Fingerprint(key, value) == secrets.Fingerprint(key, other):
+			out.Same = append(out.Same, name)
+		default:
+			out.Differ = append(out.Differ, name)
+		}
+	}
+	for name := range b.values {
+		if _, ok := a.values[name]; !ok {
+			out.OnlyB = append(out.OnlyB, name)
+		}
+	}
+	for _, names := range [][]string{out.OnlyA, out.OnlyB, out.Same, out.Differ} {
+		sort.Strings(names)
+	}
+	return out
+}
+
+// printDiff writes the human-readable form of a diff
+func printDiff(w io.Writer, out diffOutput) {
+	fmt.Fprintf(w, "A: %s (%d secret(s))\n", out.A.Label, out.A.Secrets)
+	fmt.Fprintf(w, "B: %s (%d secret(s))\n\n", out.B.Label, out.B.Secrets)
+	if out.A.Secrets == 0 && out.B.Secrets == 0 {
+		fmt.Fprintln(w, "Neither side has secrets.")
+		return
+	}
+
+	type row struct{ name, state string }
+	var rows []row
+	for _, group := range []struct {
+		names []string
+		state string
+	}{
+		{out.Differ, "differs"},
+		{out.OnlyA, "only in A"},
+		{out.OnlyB, "only in B"},
+		{out.Same, "same value"},
+	} {
+		for _, name := range group.names {
+			rows = append(rows, row{name, group.state})
+		}
+	}
+	fmt.Fprintf(w, "  %-28s %s\n", "NAME", "STATE")
+	fmt.Fprintf(w, "  %-28s %s\n", "----", "-----")
+	for _, r := range rows {
+		fmt.Fprintf(w, "  %-28s %s\n", r.name, r.state)
+	}
+
+	fmt.Fprintf(w, "\n%d same, %d differ, %d only in A, %d only in B\n", len(out.Same), len(out.Differ), len(out.OnlyA), len(out.OnlyB))
+}
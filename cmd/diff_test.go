@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildDiff(t *testing.T) {
+	a := diffSide{ref: "project", label: "project", values: map[string]string{
+		"DB": "postgres://a", "TOKEN": "t", "STRIPE_KEY": "sk", "DEBUG": "1",
+	}}
+	b := diffSide{ref: ".env.production", label: "env file .env.production", values: map[string]string{
+		"DB": "postgres://b", "TOKEN": "t", "SENTRY_DSN": "https://sentry",
+	}}
+
+	got := buildDiff(a, b, []byte("test key"))
+	want := diffOutput{
+		A:      diffSideOutput{Ref: "project", Label: "project", Secrets: 4},
+		B:      diffSideOutput{Ref: ".env.production", Label: "env file .env.production", Secrets: 3},
+		OnlyA:  []string{"DEBUG", "STRIPE_KEY"},
+		OnlyB:  []string{"SENTRY_DSN"},
+		Same:   []string{"TOKEN"},
+		Differ: []string{"DB"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDiff() =\n%+v\nwant\n%+v", got, want)
+	}
+	if got.Identical() {
+		t.Error("Identical() = true for sides that differ")
+	}
+	if same := buildDiff(b, b, []byte("test key")); !same.Identical() || len(same.Same) != 3 {
+		t.Errorf("buildDiff() of a side with itself = %+v, want identical", same)
+	}
+}
@@ -1,180 +1,528 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 
+	"github.com/portdeveloper/alex/internal/discover"
 	"github.com/portdeveloper/alex/internal/secrets"
 	"github.com/spf13/cobra"
 )
 
+var (
+	doctorPassphrase bool
+	doctorJSON       bool
+	doctorFix        bool
+)
+
 var doctorCmd = &cobra.Command{
 	Use:   "doctor",
 	Short: "Check alex setup and diagnose issues",
 	Long: `Run diagnostics to verify alex is set up correctly.
 
 Checks:
-  - Machine ID source (hardware UUID vs fallback)
+  - Machine ID source (hardware UUID vs fallback, weak IDs)
   - Project detection (git remote vs path)
-  - Storage locations and secret counts
-  - Encryption functionality`,
+  - Storage: every store is decrypted, and which key slot unlocked it
+  - Encryption: a real encrypt/decrypt round-trip
+  - Permissions of ~/.alex and its files (0700/0600)
+  - Hygiene: lingering .env files, plaintext credential files, backups
+    keyed with the machine ID alone and orphaned project directories
+
+Use --fix to repair permissions, remove weakly keyed backups and remove
+orphaned project directories (after confirmation). Only directories
+holding nothing but an empty store are removed; those with backups, extra
+key slots or other files are listed for you to check.
+
+Examples:
+  alex doctor
+  alex doctor --output json
+  alex doctor --fix`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("alex doctor")
-		fmt.Println("===========")
-		fmt.Println()
+		if doctorJSON {
+			outputFormat = formatJSON
+		}
+		if machineOutput() && doctorFix {
+			exitWithError("--fix cannot be combined with --output "+outputFormat, nil)
+		}
 
-		allGood := true
+		unlocker := newUnlocker(doctorPassphrase)
 
-		// Check machine ID
-		allGood = checkMachineID() && allGood
+		counts := make(storeCounts)
+		checks := []*checkResult{
+			checkMachineID(),
+			checkProjectDetection(),
+			checkStorage(unlocker, counts),
+			checkEncryption(),
+			checkPermissions(),
+			checkHygiene(counts),
+		}
 
-		// Check project detection
-		checkProjectDetection()
+		if machineOutput() {
+			printDoctorResult(checks)
+			return
+		}
 
-		// Check storage
-		allGood = checkStorage() && allGood
+		fmt.Println("alex doctor")
+		fmt.Println("===========")
+		fmt.Println()
 
-		// Check encryption
-		allGood = checkEncryption() && allGood
+		allGood := true
+		for _, c := range checks {
+			c.print()
+			allGood = allGood && c.Status == statusOK
+		}
 
-		fmt.Println()
 		if allGood {
 			fmt.Println("All checks passed!")
-		} else {
-			fmt.Println("Some issues found. See warnings above.")
+			return
 		}
+		fmt.Println("Some issues found. See warnings above.")
+
+		fixes := collectFixes(checks)
+		if len(fixes) == 0 {
+			return
+		}
+		if !doctorFix {
+			fmt.Printf("Run 'alex doctor --fix' to repair %d issue(s).\n", len(fixes))
+			return
+		}
+		applyFixes(fixes)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&doctorPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "Print results as JSON (same as --output json)")
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "Repair permissions and remove weak backups and orphaned project directories")
 }
 
-func checkMachineID() bool {
-	fmt.Println("Machine ID")
-	fmt.Println("----------")
+type checkStatus string
 
-	result, err := secrets.GetMachineID()
-	if err != nil {
-		fmt.Printf("  Status:  ERROR - %v\n", err)
-		return false
+const (
+	statusOK      checkStatus = "ok"
+	statusWarning checkStatus = "warning"
+	statusError   checkStatus = "error"
+)
+
+// checkResult is the outcome of one doctor check
+type checkResult struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Status  checkStatus   `json:"status"`
+	Message string        `json:"message,omitempty"`
+	Details []checkDetail `json:"details,omitempty"`
+	Issues  []checkIssue  `json:"issues,omitempty"`
+}
+
+// checkDetail is an informational label/value pair
+type checkDetail struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// checkIssue is a single problem found by a check, optionally repairable
+type checkIssue struct {
+	Status  checkStatus `json:"status"`
+	Message string      `json:"message"`
+	Path    string      `json:"path,omitempty"`
+	Fixable bool        `json:"fixable"`
+
+	fixDescription string
+	fix            func() error
+}
+
+func newCheck(id, name string) *checkResult {
+	return &checkResult{ID: id, Name: name, Status: statusOK}
+}
+
+func (c *checkResult) detail(label, format string, args ...any) {
+	c.Details = append(c.Details, checkDetail{Label: label, Value: fmt.Sprintf(format, args...)})
+}
+
+// fail records the overall status of the check, keeping the most severe one
+func (c *checkResult) fail(status checkStatus, format string, args ...any) {
+	if status == statusError || c.Status == statusOK {
+		c.Status = status
+		c.Message = fmt.Sprintf(format, args...)
+	}
+}
+
+func (c *checkResult) issue(status checkStatus, path, message string) *checkIssue {
+	if status == statusError || c.Status == statusOK {
+		c.Status = status
 	}
+	c.Issues = append(c.Issues, checkIssue{Status: status, Path: path, Message: message})
+	return &c.Issues[len(c.Issues)-1]
+}
 
-	if result.UsedFallback {
-		fmt.Printf("  Source:  hostname + username (fallback)\n")
-		fmt.Printf("  Status:  WARNING - less secure, consider using --passphrase\n")
-		fmt.Println()
-		return false
+func (i *checkIssue) withFix(description string, fix func() error) {
+	i.Fixable = true
+	i.fixDescription = description
+	i.fix = fix
+}
+
+func (c *checkResult) print() {
+	fmt.Println(c.Name)
+	fmt.Println(strings.Repeat("-", len(c.Name)))
+	for _, d := range c.Details {
+		label := d.Label
+		if label != "" {
+			label += ":"
+		}
+		fmt.Printf("  %-8s %s\n", label, d.Value)
+	}
+	for _, i := range c.Issues {
+		fmt.Printf("  - %s\n", i.Message)
 	}
 
-	switch runtime.GOOS {
-	case "darwin":
-		fmt.Printf("  Source:  macOS hardware UUID\n")
-	case "linux":
-		fmt.Printf("  Source:  /etc/machine-id\n")
+	switch {
+	case c.Status == statusOK:
+		fmt.Printf("  Status:  OK\n")
+	case c.Message != "":
+		fmt.Printf("  Status:  %s - %s\n", strings.ToUpper(string(c.Status)), c.Message)
 	default:
-		fmt.Printf("  Source:  system identifier\n")
+		fmt.Printf("  Status:  %s - %d issue(s)\n", strings.ToUpper(string(c.Status)), len(c.Issues))
 	}
-	fmt.Printf("  Status:  OK\n")
 	fmt.Println()
-	return true
 }
 
-func checkProjectDetection() {
-	fmt.Println("Project Detection")
-	fmt.Println("-----------------")
-
-	// Check git remote
-	remoteCmd := exec.Command("git", "remote", "get-url", "origin")
-	remoteOutput, remoteErr := remoteCmd.Output()
+// doctorOutput is the machine-readable form of 'alex doctor'
+type doctorOutput struct {
+	OK     bool           `json:"ok"`
+	Checks []*checkResult `json:"checks"`
+}
 
-	// Check git root
-	rootCmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	rootOutput, rootErr := rootCmd.Output()
+func printDoctorResult(checks []*checkResult) {
+	report := doctorOutput{OK: true, Checks: checks}
+	for _, c := range checks {
+		if c.Status != statusOK {
+			report.OK = false
+		}
+	}
+	printResult("doctor_report", report)
+}
 
-	if remoteErr == nil {
-		remote := strings.TrimSpace(string(remoteOutput))
-		fmt.Printf("  Method:  git remote URL (stable across moves)\n")
-		fmt.Printf("  Remote:  %s\n", remote)
-	} else if rootErr == nil {
-		root := strings.TrimSpace(string(rootOutput))
-		fmt.Printf("  Method:  git root path (breaks if moved)\n")
-		fmt.Printf("  Path:    %s\n", root)
-	} else {
-		cwd, _ := os.Getwd()
-		fmt.Printf("  Method:  current directory (not in git repo)\n")
-		fmt.Printf("  Path:    %s\n", cwd)
+func collectFixes(checks []*checkResult) []checkIssue {
+	var fixes []checkIssue
+	for _, c := range checks {
+		for _, i := range c.Issues {
+			if i.Fixable {
+				fixes = append(fixes, i)
+			}
+		}
 	}
+	return fixes
+}
 
-	fmt.Printf("  ID:      %s\n", secrets.GetProjectID())
+func applyFixes(fixes []checkIssue) {
 	fmt.Println()
+	fmt.Println("The following repairs will be made:")
+	for _, f := range fixes {
+		fmt.Printf("  - %s\n", f.fixDescription)
+	}
+	if !confirmAction("Apply these repairs?") {
+		fmt.Println("Cancelled.")
+		return
+	}
+
+	failed := 0
+	for _, f := range fixes {
+		if err := f.fix(); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", f.fixDescription, err)
+			failed++
+		}
+	}
+	fmt.Printf("✓ Applied %d repair(s)\n", len(fixes)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
 
-func checkStorage() bool {
-	fmt.Println("Storage")
-	fmt.Println("-------")
+func checkMachineID() *checkResult {
+	c := newCheck("machine_id", "Machine ID")
 
-	homeDir, err := os.UserHomeDir()
+	result, err := secrets.GetMachineID()
 	if err != nil {
-		fmt.Printf("  Status:  ERROR - cannot get home directory: %v\n", err)
-		return false
+		c.fail(statusError, "%v", err)
+		return c
 	}
 
-	globalDir := filepath.Join(homeDir, ".alex")
-	fmt.Printf("  Global:  %s\n", globalDir)
+	c.detail("Source", "%s", result.Source)
 
-	if secrets.GlobalStoreExists() {
-		// Try to count secrets (needs passphrase, so just show exists)
-		fmt.Printf("           secrets.enc exists\n")
+	installPath, _ := secrets.InstallSecretPath()
+	installExists := secrets.InstallSecretExists()
+	if installExists {
+		c.detail("Install", "secret at %s", installPath)
 	} else {
-		fmt.Printf("           (no secrets stored)\n")
+		c.detail("Install", "secret at %s (created on first use)", installPath)
+	}
+
+	container := secrets.DetectContainer()
+	if container != "" {
+		c.detail("Runtime", "%s container", container)
+	}
+
+	switch {
+	case result.UsedFallback:
+		c.Details[0].Value += " (fallback)"
+		c.fail(statusWarning, "less secure, consider using --passphrase")
+	case result.LowEntropy:
+		c.fail(statusWarning, "machine ID looks weak (too short or repetitive), consider using --passphrase")
+	case container != "" && !result.UsedFallback:
+		c.fail(statusWarning, "running in a %s container: %s is usually the same in every container from one image", container, result.Source)
+		c.detail("", "stores are only distinct per container if the install secret is not baked into the image;")
+		c.detail("", "mount it per container (ALEX_INSTALL_SECRET_FILE) or use --passphrase")
+	}
+	return c
+}
+
+func checkProjectDetection() *checkResult {
+	c := newCheck("project_detection", "Project Detection")
+
+	project := secrets.ResolveProject()
+	switch project.Method {
+	case secrets.ProjectByPin:
+		c.detail("Method", "%s file (stable across moves)", project.PinFile)
+		c.detail("Name", "%s", project.Pin)
+	case secrets.ProjectByRemote:
+		c.detail("Method", "git remote URL (stable across moves)")
+		c.detail("Remote", "%s", project.Remote)
+		c.detail("Canonical", "%s", secrets.CanonicalRemote(project.Remote))
+	case secrets.ProjectByGitRoot:
+		c.detail("Method", "git root path (breaks if moved)")
+		c.detail("Path", "%s", project.Root)
+	case secrets.ProjectByWorkspaceRoot:
+		c.detail("Method", "root marked by %s (breaks if moved)", project.Marker)
+		c.detail("Path", "%s", project.Root)
+	default:
+		c.detail("Method", "current directory (no git repo or marked root)")
+		c.detail("Path", "%s", project.Root)
 	}
 
-	projectDir := filepath.Join(homeDir, ".alex", "projects", secrets.GetProjectID())
-	fmt.Printf("  Project: %s\n", projectDir)
+	c.detail("ID", "%s", project.ID)
+	return c
+}
+
+// storeCounts maps store directories to their number of secrets,
+// for stores that were successfully decrypted
+type storeCounts map[string]int
+
+// checkStorage opens every store with the current key
+func checkStorage(unlocker *secrets.Unlocker, counts storeCounts) *checkResult {
+	c := newCheck("storage", "Storage")
 
-	exists, _ := secrets.ProjectStoreExists()
-	if exists {
-		fmt.Printf("           secrets.enc exists\n")
+	globalDir, err := secrets.GetGlobalDir()
+	if err != nil {
+		c.fail(statusError, "cannot get home directory: %v", err)
+		return c
+	}
+
+	c.detail("Global", "%s", globalDir)
+	if secrets.GlobalStoreExists() {
+		checkStoreDecrypts(c, "global store", globalDir, unlocker, counts)
 	} else {
-		fmt.Printf("           (no secrets stored)\n")
+		c.detail("", "(no secrets stored)")
 	}
 
-	fmt.Println()
-	return true
+	projectIDs, err := secrets.ListProjectIDs()
+	if err != nil {
+		c.fail(statusError, "cannot list project stores: %v", err)
+		return c
+	}
+
+	currentID := secrets.GetProjectID()
+	projectsDir, _ := secrets.GetProjectsDir()
+	c.detail("Projects", "%s (%d store(s))", projectsDir, len(projectIDs))
+	for _, id := range projectIDs {
+		label := "project " + id
+		if id == currentID {
+			label += " (current)"
+		}
+		checkStoreDecrypts(c, label, filepath.Join(projectsDir, id), unlocker, counts)
+	}
+
+	groups, err := secrets.ListGroups()
+	if err != nil {
+		c.fail(statusError, "cannot list groups: %v", err)
+		return c
+	}
+	if len(groups) > 0 {
+		groupsDir, _ := secrets.GetGroupsDir()
+		c.detail("Groups", "%s (%d group(s))", groupsDir, len(groups))
+	}
+	for _, name := range groups {
+		if dir, _ := secrets.GroupDir(name); secrets.StoreExists(dir) {
+			checkStoreDecrypts(c, "group "+name, dir, unlocker, counts)
+		}
+	}
+	return c
 }
 
-func checkEncryption() bool {
-	fmt.Println("Encryption")
-	fmt.Println("----------")
+func checkStoreDecrypts(c *checkResult, label, dir string, unlocker *secrets.Unlocker, counts storeCounts) {
+	store, err := unlocker.Open(dir)
+	if err == nil {
+		err = store.Verify()
+	}
+	if err != nil {
+		c.issue(statusError, dir, fmt.Sprintf("%s: cannot decrypt (%v)", label, err))
+		return
+	}
+	counts[dir] = store.Count()
+	if slot := store.Slot(); slot != secrets.PrimarySlot {
+		c.detail("", "%s: %d secret(s), decrypts OK with key slot %q", label, store.Count(), slot)
+		c.issue(statusWarning, dir, fmt.Sprintf("%s: primary %s key no longer unlocks it; run 'alex rekey' to replace it", label, store.KeyMode()))
+		return
+	}
+	c.detail("", "%s: %d secret(s), decrypts OK (%s key, primary slot)", label, store.Count(), store.KeyMode())
+}
 
-	// Test encrypt/decrypt round-trip
-	testData := []byte("alex-doctor-test")
-	testPass := "test-passphrase"
+// checkEncryption runs a real encrypt/decrypt round-trip
+func checkEncryption() *checkResult {
+	c := newCheck("encryption", "Encryption")
+	c.detail("Library", "filippo.io/age (scrypt)")
 
-	// We can't directly call encrypt/decrypt as they're unexported
-	// So we'll just verify the age library is working via the machine ID
-	result, err := secrets.GetMachineID()
+	if err := secrets.SelfTest(); err != nil {
+		c.fail(statusError, "round-trip failed: %v", err)
+		return c
+	}
+	c.detail("Test", "encrypt/decrypt round-trip passed")
+	return c
+}
+
+// checkPermissions verifies ~/.alex is 0700 and its files are 0600
+func checkPermissions() *checkResult {
+	c := newCheck("permissions", "Permissions")
+
+	globalDir, err := secrets.GetGlobalDir()
 	if err != nil {
-		fmt.Printf("  Status:  ERROR - %v\n", err)
-		return false
+		c.fail(statusError, "cannot get home directory: %v", err)
+		return c
+	}
+	if _, err := os.Stat(globalDir); errors.Is(err, os.ErrNotExist) {
+		c.detail("Path", "%s (not created yet)", globalDir)
+		return c
 	}
+	c.detail("Path", "%s", globalDir)
+
+	filepath.WalkDir(globalDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			c.issue(statusWarning, path, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
 
-	if result.ID == "" {
-		fmt.Printf("  Status:  ERROR - empty machine ID\n")
-		return false
+		want := os.FileMode(0600)
+		if d.IsDir() {
+			want = 0700
+		}
+		if mode := info.Mode().Perm(); mode&0077 != 0 {
+			i := c.issue(statusWarning, path, fmt.Sprintf("%s is %04o, should be %04o", path, mode, want))
+			i.withFix(fmt.Sprintf("chmod %04o %s", want, path), func() error {
+				return os.Chmod(path, want)
+			})
+		}
+		return nil
+	})
+	return c
+}
+
+// checkHygiene flags lingering .env files, plaintext credential files,
+// backups keyed with the machine ID alone and orphaned project directories
+func checkHygiene(counts storeCounts) *checkResult {
+	c := newCheck("hygiene", "Hygiene")
+
+	root := secrets.GetProjectRoot()
+	if root != "" {
+		found := discover.Project(root)
+		for _, f := range found.EnvFiles {
+			c.issue(statusWarning, filepath.Join(root, f.Path),
+				fmt.Sprintf("lingering env file %s - import it with 'alex import' and delete it", f.Path))
+		}
+		for _, f := range discover.CredentialFiles(root) {
+			c.issue(statusWarning, filepath.Join(root, f),
+				fmt.Sprintf("plaintext credential file %s - store its contents in alex or move it out of the project", f))
+		}
 	}
 
-	_ = testData
-	_ = testPass
+	if stores, err := allStores(); err == nil {
+		for _, store := range stores {
+			weak, _ := secrets.WeakBackups(store.Dir)
+			for _, backup := range weak {
+				i := c.issue(statusWarning, backup, fmt.Sprintf("backup %s of the %s store is keyed with the machine ID alone - anyone who can read the machine ID can decrypt it", filepath.Base(backup), store.Label))
+				i.withFix(fmt.Sprintf("remove %s", backup), func() error {
+					return os.Remove(backup)
+				})
+			}
+		}
+	}
 
-	fmt.Printf("  Library: filippo.io/age\n")
-	fmt.Printf("  Status:  OK\n")
-	fmt.Println()
-	return true
+	projectsDir, err := secrets.GetProjectsDir()
+	if err != nil {
+		return c
+	}
+	entries, err := os.ReadDir(projectsDir)
+	if err != nil {
+		return c
+	}
+	withSecrets, err := secrets.ListProjectIDs()
+	if err != nil {
+		return c
+	}
+	hasFile := make(map[string]bool)
+	for _, id := range withSecrets {
+		hasFile[id] = true
+	}
+
+	orphans := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// Stores that could not be decrypted are never considered orphaned
+		dir := filepath.Join(projectsDir, entry.Name())
+		if count, opened := counts[dir]; hasFile[entry.Name()] && (!opened || count > 0) {
+			continue
+		}
+		orphans++
+		files, other, err := secrets.StoreFiles(dir)
+		if err != nil {
+			c.issue(statusWarning, dir, fmt.Sprintf("orphaned project directory %s (no secrets): %v", entry.Name(), err))
+			continue
+		}
+		if len(other) > 0 {
+			// Backups and extra key slots may be all that is left of
+			// secrets: never delete them unseen
+			c.issue(statusWarning, dir, fmt.Sprintf("project directory %s has no secrets but holds %s - check them and remove it by hand",
+				entry.Name(), strings.Join(other, ", ")))
+			continue
+		}
+		i := c.issue(statusWarning, dir, fmt.Sprintf("orphaned project directory %s (no secrets)", entry.Name()))
+		fix := "remove " + dir
+		if len(files) > 0 {
+			fix += fmt.Sprintf(" (%s)", strings.Join(files, ", "))
+		}
+		i.withFix(fix, func() error {
+			for _, name := range files {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			}
+			return os.Remove(dir)
+		})
+	}
+	c.detail("Orphans", "%d empty project store(s)", orphans)
+	return c
 }
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// effectiveOutput is what 'alex run' injects and where each value comes
+// from, as shown by 'alex list --effective' and 'alex run --explain-env'.
+// Values are never included, only fingerprints of them.
+type effectiveOutput struct {
+	ProjectID string            `json:"project_id,omitempty"`
+	Secrets   []effectiveSecret `json:"secrets"`
+	// Overrides counts the secrets that shadow a value in a lower layer,
+	// and Redundant those whose value equals every value they shadow
+	Overrides int `json:"overrides"`
+	Redundant int `json:"redundant"`
+}
+
+// effectiveSecret is an injected secret and the layer that supplies it
+type effectiveSecret struct {
+	Name        string `json:"name"`
+	Scope       string `json:"scope"`
+	Fingerprint string `json:"fingerprint"`
+	// Shadows are the values of lower layers it overrides, most specific
+	// first
+	Shadows []shadowedValue `json:"shadows,omitempty"`
+}
+
+// shadowedValue is a value of a lower layer that an injected secret
+// overrides
+type shadowedValue struct {
+	Scope       string `json:"scope"`
+	Fingerprint string `json:"fingerprint"`
+	// Differs reports whether it differs from the injected value
+	Differs bool `json:"differs"`
+}
+
+// layerValuesOf decrypts the values of every layer, exiting if any cannot
+// be decrypted rather than leaving its secrets out
+func layerValuesOf(layers []storeLayer) []layerValues {
+	values := make([]layerValues, 0, len(layers))
+	for _, layer := range layers {
+		all, err := layer.store.GetAll()
+		if err != nil {
+			exitWithError(fmt.Sprintf("reading %s secrets", layer.scope), err)
+		}
+		values = append(values, layerValues{scope: layer.scope, values: all})
+	}
+	return values
+}
+
+// fingerprintKey returns the key of this machine's fingerprints, exiting
+// if it cannot be read or created
+func fingerprintKey() []byte {
+	key, err := secrets.FingerprintKey()
+	if err != nil {
+		exitWithError("reading fingerprint key", err)
+	}
+	return key
+}
+
+// buildEffective merges layers the way 'alex run' does and reports what
+// each secret shadows. layers go from the least to the most specific.
+// Values are compared by their fingerprint under key.
+func buildEffective(layers []layerValues, key []byte) effectiveOutput {
+	out := effectiveOutput{Secrets: []effectiveSecret{}}
+
+	names := make(map[string]bool)
+	for _, layer := range layers {
+		for name := range layer.values {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		var secret effectiveSecret
+		for i := len(layers) - 1; i >= 0; i-- {
+			value, ok := layers[i].values[name]
+			if !ok {
+				continue
+			}
+			fingerprint := secrets.Fingerprint(key, value)
+			if secret.Scope == "" {
+				secret = effectiveSecret{Name: name, Scope: layers[i].scope, Fingerprint: fingerprint}
+				continue
+			}
+			secret.Shadows = append(secret.Shadows, shadowedValue{
+				Scope:       layers[i].scope,
+				Fingerprint: fingerprint,
+				Differs:     fingerprint != secret.Fingerprint,
+			})
+		}
+		if len(secret.Shadows) > 0 {
+			out.Overrides++
+			redundant := true
+			for _, shadowed := range secret.Shadows {
+				redundant = redundant && !shadowed.Differs
+			}
+			if redundant {
+				out.Redundant++
+			}
+		}
+		out.Secrets = append(out.Secrets, secret)
+	}
+	return out
+}
+
+// printEffective writes the human-readable form of an effective report
+func printEffective(w io.Writer, out effectiveOutput) {
+	if len(out.Secrets) == 0 {
+		fmt.Fprintln(w, "No secrets are injected here.")
+		return
+	}
+
+	fmt.Fprintf(w, "  %-28s %-24s %-17s %s\n", "NAME", "SCOPE", "FINGERPRINT", "SHADOWS")
+	fmt.Fprintf(w, "  %-28s %-24s %-17s %s\n", "----", "-----", "-----------", "-------")
+	for _, s := range out.Secrets {
+		shadows := make([]string, 0, len(s.Shadows))
+		for _, shadowed := range s.Shadows {
+			state := "same value"
+			if shadowed.Differs {
+				state = "differs " + shadowed.Fingerprint
+			}
+			shadows = append(shadows, fmt.Sprintf("%s (%s)", shadowed.Scope, state))
+		}
+		fmt.Fprintf(w, "  %-28s %-24s %-17s %s\n", s.Name, s.Scope, s.Fingerprint, strings.Join(shadows, ", "))
+	}
+
+	fmt.Fprintf(w, "\n%d secret(s) injected", len(out.Secrets))
+	if out.Overrides > 0 {
+		fmt.Fprintf(w, ", %d override(s)", out.Overrides)
+		if out.Redundant > 0 {
+			fmt.Fprintf(w, " (%d with the same value as what they shadow)", out.Redundant)
+		}
+	}
+	fmt.Fprintln(w)
+	if out.Redundant > 0 {
+		fmt.Fprintln(w, "Overrides with the same value can be removed with 'alex unset' in their scope.")
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+func TestBuildEffective(t *testing.T) {
+	key := []byte("test key")
+	fp := func(value string) string { return secrets.Fingerprint(key, value) }
+	layers := []layerValues{
+		{scope: "global", values: map[string]string{"DB": "global-db", "TOKEN": "t", "EDITOR_KEY": "e"}},
+		{scope: "group:acme", values: map[string]string{"TOKEN": "t"}},
+		{scope: "project", values: map[string]string{"DB": "project-db"}},
+		{scope: "project:services/billing", values: map[string]string{"DB": "billing-db"}},
+	}
+
+	got := buildEffective(layers, key)
+	want := effectiveOutput{
+		Secrets: []effectiveSecret{
+			{Name: "DB", Scope: "project:services/billing", Fingerprint: fp("billing-db"), Shadows: []shadowedValue{
+				{Scope: "project", Fingerprint: fp("project-db"), Differs: true},
+				{Scope: "global", Fingerprint: fp("global-db"), Differs: true},
+			}},
+			{Name: "EDITOR_KEY", Scope: "global", Fingerprint: fp("e")},
+			{Name: "TOKEN", Scope: "group:acme", Fingerprint: fp("t"), Shadows: []shadowedValue{
+				{Scope: "global", Fingerprint: fp("t"), Differs: false},
+			}},
+		},
+		Overrides: 2,
+		Redundant: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildEffective() =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fingerprintPassphrase bool
+	fingerprintGlobal     bool
+	fingerprintContext    string
+)
+
+var fingerprintCmd = &cobra.Command{
+	Use:   "fingerprint KEY...",
+	Short: "Print a short hash of a secret to compare with teammates",
+	Long: `Print a short fingerprint of a secret's value that a teammate can
+compare with theirs, over chat or a call, to confirm you hold the same
+credential without either of you revealing it.
+
+The fingerprint is an HMAC of the value keyed by the secret's name and
+--context, a phrase only your team knows, so the same value under the
+same name and phrase gives the same fingerprint on every machine.
+--context is required: keyed by the name alone, anyone who sees a
+fingerprint could test guesses of the value against it offline.
+
+A fingerprint reveals that two values are equal, and whether a value
+changed since an earlier fingerprint. Whoever knows the name and the
+phrase can still test guesses against it, so short or guessable values
+such as passwords are only as safe as the phrase.
+
+By default the value 'alex run' injects here is used. Use --global,
+--group, --dir or --here for the value of one scope.
+
+Examples:
+  alex fingerprint --context "acme q3" STRIPE_KEY
+  alex fingerprint --context "acme q3" DATABASE_URL REDIS_URL
+  alex fingerprint --context "acme q3" --group acme-backend REGISTRY_TOKEN`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if fingerprintContext == "" {
+			exitWithError("--context is required: agree on a phrase with your team so fingerprints cannot be checked against guesses by anyone who sees them", nil)
+		}
+		unlocker := newUnlocker(fingerprintPassphrase)
+
+		var layers []storeLayer
+		if fingerprintGlobal || scopeGroup != "" || scopeFlagsSet() {
+			store, scope := openScopedStore(unlocker, fingerprintGlobal)
+			layers = []storeLayer{{scope: scope, store: store}}
+		} else {
+			layers = openLayers(unlocker)
+		}
+		values := layerValuesOf(layers)
+
+		out := fingerprintOutput{Context: true, Fingerprints: []secretFingerprint{}}
+		for _, name := range args {
+			fp, ok := sharedFingerprint(values, name, fingerprintContext)
+			if !ok {
+				exitWithCode(codeNotFound, fmt.Sprintf("secret '%s' not found in %s", name, layersLabel(layers)), nil)
+			}
+			out.Fingerprints = append(out.Fingerprints, fp)
+		}
+
+		if machineOutput() {
+			printResult("secret_fingerprint", out)
+			return
+		}
+		for _, fp := range out.Fingerprints {
+			fmt.Printf("%-28s %s  (%s)\n", fp.Name, fp.Fingerprint, fp.Scope)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fingerprintCmd)
+	fingerprintCmd.Flags().BoolVar(&fingerprintPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	fingerprintCmd.Flags().BoolVarP(&fingerprintGlobal, "global", "g", false, "Use the value in global scope (~/.alex/)")
+	addScopeFlags(fingerprintCmd)
+	fingerprintCmd.Flags().StringVar(&fingerprintContext, "context", "", "Phrase shared with your team that keys the fingerprint along with the name (required)")
+}
+
+// fingerprintOutput is the machine-readable result of fingerprint
+type fingerprintOutput struct {
+	// Context is always true: --context keys every fingerprint. The
+	// phrase itself is not included.
+	Context      bool                `json:"context"`
+	Fingerprints []secretFingerprint `json:"fingerprints"`
+}
+
+type secretFingerprint struct {
+	Name        string `json:"name"`
+	Scope       string `json:"scope"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// sharedFingerprint fingerprints the value of name in the most specific
+// layer that has it. The fingerprint is keyed by name and context so it
+// compares across machines.
+func sharedFingerprint(layers []layerValues, name, context string) (secretFingerprint, bool) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		value, ok := layers[i].values[name]
+		if !ok {
+			continue
+		}
+		key := name + "\x00" + context
+		return secretFingerprint{Name: name, Scope: layers[i].scope, Fingerprint: secrets.SharedFingerprint(key, value)}, true
+	}
+	return secretFingerprint{}, false
+}
+
+// layersLabel names the layers a secret was looked up in for messages
+func layersLabel(layers []storeLayer) string {
+	if len(layers) == 1 {
+		return layers[0].scope + " scope"
+	}
+	return "any scope here"
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+func TestSharedFingerprint(t *testing.T) {
+	layers := []layerValues{
+		{scope: "global", values: map[string]string{"TOKEN": "global-token"}},
+		{scope: "project", values: map[string]string{"TOKEN": "project-token"}},
+	}
+
+	got, ok := sharedFingerprint(layers, "TOKEN", "acme")
+	want := secretFingerprint{Name: "TOKEN", Scope: "project", Fingerprint: secrets.SharedFingerprint("TOKEN\x00acme", "project-token")}
+	if !ok || got != want {
+		t.Errorf("sharedFingerprint() = %+v, %v, want %+v", got, ok, want)
+	}
+	if byName := secrets.SharedFingerprint("TOKEN", "project-token"); got.Fingerprint == byName {
+		t.Error("fingerprint is keyed by the name alone")
+	}
+	other, _ := sharedFingerprint(layers, "TOKEN", "other")
+	if other.Fingerprint == got.Fingerprint {
+		t.Error("--context does not change the fingerprint")
+	}
+	if _, ok := sharedFingerprint(layers, "MISSING", "acme"); ok {
+		t.Error("sharedFingerprint() found a secret no layer has")
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/manifest"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var groupDeleteYes bool
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage groups of secrets shared by several projects",
+	Long: `Manage groups: named stores of secrets that several projects share,
+such as a registry token or a staging database, without putting them in
+the global store where every project would get them.
+
+A project uses the groups its manifest (.alex.json) lists. 'alex run'
+layers global secrets, then each group in the order listed, then the
+project's own secrets; later layers override earlier ones.
+
+Groups live in ~/.alex/groups. Like the manifest, the list of groups can
+be committed: teammates create the same groups on their machines.
+
+Examples:
+  alex group create acme-backend
+  alex set --group acme-backend REGISTRY_TOKEN
+  alex group attach acme-backend     # in each project that uses it
+  alex group list`,
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create a group",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := secrets.ValidateGroupName(name); err != nil {
+			exitWithCode(codeInvalidArgument, err.Error(), nil)
+		}
+		if err := secrets.CreateGroup(name); err != nil {
+			exitWithError("creating group", err)
+		}
+		if machineOutput() {
+			printResult("group_create", groupOutput{Name: name, Exists: true})
+			return
+		}
+		fmt.Printf("✓ Created group %s\n", name)
+		fmt.Printf("  Add secrets with 'alex set --group %s KEY' and use it in a project\n", name)
+		fmt.Printf("  with 'alex group attach %s'\n", name)
+	},
+}
+
+var groupListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List groups and the ones the current project uses",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, err := secrets.ListGroups()
+		if err != nil {
+			exitWithError("listing groups", err)
+		}
+		attached := projectGroups(secrets.ResolveProject().Root)
+
+		out := groupsOutput{Groups: []groupOutput{}, Attached: attached}
+		if out.Attached == nil {
+			out.Attached = []string{}
+		}
+		for _, name := range names {
+			out.Groups = append(out.Groups, newGroupOutput(name, attached))
+		}
+		for _, name := range attached {
+			if !secrets.GroupExists(name) {
+				out.Groups = append(out.Groups, newGroupOutput(name, attached))
+			}
+		}
+		if machineOutput() {
+			printResult("groups", out)
+			return
+		}
+		if len(out.Groups) == 0 {
+			fmt.Println("No groups. Create one with 'alex group create NAME'.")
+			return
+		}
+		for _, g := range out.Groups {
+			label := g.Name
+			switch {
+			case !g.Exists:
+				label += fmt.Sprintf(" ← used by this project (#%d), not on this machine", g.Position)
+			case g.Position > 0:
+				label += fmt.Sprintf(" ← used by this project (#%d)", g.Position)
+			case !g.HasSecrets:
+				label += " (no secrets)"
+			}
+			fmt.Println(label)
+		}
+	},
+}
+
+var groupAttachCmd = &cobra.Command{
+	Use:   "attach NAME",
+	Short: "Use a group in the current project",
+	Long: `List a group in the project's manifest (.alex.json), after the groups
+it already uses, so 'alex run' injects its secrets. Commit the manifest
+to use the group in every checkout.
+
+Examples:
+  alex group attach acme-backend`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := secrets.ValidateGroupName(name); err != nil {
+			exitWithCode(codeInvalidArgument, err.Error(), nil)
+		}
+		root := secrets.ResolveProject().Root
+		m, err := manifest.LoadOrEmpty(root)
+		if err != nil {
+			exitWithError("reading manifest", err)
+		}
+		added := m.AddGroup(name)
+		if added {
+			if err := m.Save(root); err != nil {
+				exitWithError("writing manifest", err)
+			}
+		}
+		if !secrets.GroupExists(name) {
+			fmt.Fprintf(os.Stderr, "Warning: group %s is not on this machine (create it with 'alex group create %s')\n", name, name)
+		}
+
+		if machineOutput() {
+			printResult("group_attach", groupAttachOutput{Name: name, Manifest: manifest.Path(root), Groups: m.Groups, Changed: added})
+			return
+		}
+		if !added {
+			fmt.Printf("✓ Project already uses group %s\n", name)
+			return
+		}
+		fmt.Printf("✓ Project uses group %s (%s)\n", name, manifest.FileName)
+		fmt.Printf("  Layers: global, %s, project\n", strings.Join(m.Groups, ", "))
+	},
+}
+
+var groupDetachCmd = &cobra.Command{
+	Use:   "detach NAME",
+	Short: "Stop using a group in the current project",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		root := secrets.ResolveProject().Root
+		m, err := manifest.Load(root)
+		if errors.Is(err, os.ErrNotExist) {
+			exitWithCode(codeNotFound, fmt.Sprintf("project does not use group %s", name), nil)
+		}
+		if err != nil {
+			exitWithError("reading manifest", err)
+		}
+		if !m.RemoveGroup(name) {
+			exitWithCode(codeNotFound, fmt.Sprintf("project does not use group %s", name), nil)
+		}
+		if err := m.Save(root); err != nil {
+			exitWithError("writing manifest", err)
+		}
+
+		if machineOutput() {
+			printResult("group_detach", groupAttachOutput{Name: name, Manifest: manifest.Path(root), Groups: m.Groups, Changed: true})
+			return
+		}
+		fmt.Printf("✓ Project no longer uses group %s\n", name)
+	},
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a group and its secrets",
+	Long: `Delete a group and its secrets from this machine. Projects that list
+it in their manifest warn that it is missing until they detach it.
+
+Deleted secrets cannot be recovered except from a backup.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := secrets.ValidateGroupName(name); err != nil {
+			exitWithCode(codeInvalidArgument, err.Error(), nil)
+		}
+		if !secrets.GroupExists(name) {
+			exitWithError("deleting group", fmt.Errorf("%w: %s", secrets.ErrNoGroup, name))
+		}
+		if !groupDeleteYes && !confirmAction(fmt.Sprintf("Delete group %s and its secrets?", name)) {
+			fmt.Println("Cancelled.")
+			os.Exit(1)
+		}
+		if err := secrets.RemoveGroup(name); err != nil {
+			exitWithError("deleting group", err)
+		}
+		updateLeakIndexAfterMove()
+
+		if machineOutput() {
+			printResult("group_delete", groupOutput{Name: name})
+			return
+		}
+		fmt.Printf("✓ Deleted group %s\n", name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupCreateCmd, groupListCmd, groupAttachCmd, groupDetachCmd, groupDeleteCmd)
+	groupDeleteCmd.Flags().BoolVarP(&groupDeleteYes, "yes", "y", false, "Delete without asking")
+	groupAttachCmd.ValidArgsFunction = completeGroupArg
+	groupDeleteCmd.ValidArgsFunction = completeGroupArg
+	groupDetachCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return filterPrefix(projectGroups(secrets.ResolveProject().Root), toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// projectGroups returns the groups the manifest in root lists, in order.
+// Invalid names and an unreadable manifest are warned about and skipped.
+func projectGroups(root string) []string {
+	groups, warnings := secrets.ProjectGroups(root)
+	printWarnings(warnings)
+	return groups
+}
+
+// completeGroupNames completes --group with the groups on this machine
+func completeGroupNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names, _ := secrets.ListGroups()
+	return filterPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeGroupArg completes the first argument with the groups on this
+// machine
+func completeGroupArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeGroupNames(cmd, args, toComplete)
+}
+
+// filterPrefix returns the names starting with prefix
+func filterPrefix(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+// groupsOutput is the machine-readable result of group list
+type groupsOutput struct {
+	Groups []groupOutput `json:"groups"`
+	// Attached are the groups the current project uses, in order
+	Attached []string `json:"attached"`
+}
+
+type groupOutput struct {
+	Name       string `json:"name"`
+	Exists     bool   `json:"exists"`
+	HasSecrets bool   `json:"has_secrets"`
+	// Position is where the current project layers the group, from 1, or
+	// 0 if it doesn't use it
+	Position int `json:"position,omitempty"`
+}
+
+func newGroupOutput(name string, attached []string) groupOutput {
+	out := groupOutput{Name: name, Exists: secrets.GroupExists(name)}
+	if dir, err := secrets.GroupDir(name); err == nil {
+		out.HasSecrets = secrets.StoreExists(dir)
+	}
+	for i, g := range attached {
+		if g == name {
+			out.Position = i + 1
+		}
+	}
+	return out
+}
+
+type groupAttachOutput struct {
+	Name     string   `json:"name"`
+	Manifest string   `json:"manifest"`
+	Groups   []string `json:"groups"`
+	Changed  bool     `json:"changed"`
+}
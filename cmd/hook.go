@@ -0,0 +1,494 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/leakcheck"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+const (
+	hookBlockStart = "# >>> alex pre-commit >>>"
+	hookBlockEnd   = "# <<< alex pre-commit <<<"
+)
+
+var hookPassphrase bool
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "Manage the git pre-commit hook that blocks committed secrets",
+	Long: `Manage a git pre-commit hook that refuses commits containing stored secrets.
+
+The hook compares staged changes against keyed hashes of every stored value
+(and its substrings), so it never decrypts your secret values while running.
+The hash index lives in ~/.alex/ and is updated whenever you set, import
+or unset a secret. Only values of at least 16 characters are indexed.
+
+The hashes are keyed with a key derived from the global store, so the hook
+unlocks it: a passphrase-keyed global store asks for its passphrase (or
+runs passphrase_command) on every commit.
+
+Add 'alex:allow' to a line to let it through on purpose.
+
+Examples:
+  alex hook install       # Install into the current repository
+  alex hook refresh       # Rebuild the hash index from all stores
+  alex hook uninstall     # Remove the hook again`,
+}
+
+var hookInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the pre-commit hook in the current repository",
+	Long: `Install the alex pre-commit hook in the current git repository.
+
+Existing hooks are kept: alex adds its check to the top of the existing
+pre-commit script. Husky (.husky/) and other core.hooksPath setups are
+detected automatically. With the pre-commit framework, alex prints the
+entry to add to .pre-commit-config.yaml instead of editing generated files.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		gitRoot := secrets.GetProjectRoot()
+		if gitRoot == "" {
+			exitWithCode(codeNotInRepository, "not inside a git repository", nil)
+		}
+
+		unlocker := newUnlocker(hookPassphrase)
+
+		if err := installPreCommitHook(gitRoot, unlocker); err != nil {
+			exitWithError("installing hook", err)
+		}
+	},
+}
+
+var hookUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the pre-commit hook from the current repository",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		gitRoot := secrets.GetProjectRoot()
+		if gitRoot == "" {
+			exitWithCode(codeNotInRepository, "not inside a git repository", nil)
+		}
+
+		hookPath, err := preCommitHookPath(gitRoot)
+		if err != nil {
+			exitWithError("locating hooks directory", err)
+		}
+
+		removed, err := uninstallHookBlock(hookPath)
+		if err != nil {
+			exitWithError("removing hook", err)
+		}
+		if !removed {
+			fmt.Println("alex pre-commit hook is not installed.")
+			return
+		}
+		fmt.Printf("✓ Pre-commit hook removed (%s)\n", hookPath)
+	},
+}
+
+var hookRefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Rebuild the hash index from all stores",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		unlocker := newUnlocker(hookPassphrase)
+
+		count, err := rebuildLeakIndex(unlocker)
+		if err != nil {
+			exitWithError("building hash index", err)
+		}
+		fmt.Printf("✓ Hash index built (%d secret(s))\n", count)
+	},
+}
+
+var hookPreCommitCmd = &cobra.Command{
+	Use:   "pre-commit",
+	Short: "Check staged changes for stored secrets (run by the git hook)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		alexDir, err := secrets.GetGlobalDir()
+		if err != nil {
+			exitWithError("getting alex directory", err)
+		}
+
+		if !leakcheck.Exists(alexDir) {
+			fmt.Fprintln(os.Stderr, "alex: no hash index found, skipping secret check (run 'alex hook refresh')")
+			return
+		}
+
+		key, err := leakIndexKey(newUnlocker(hookPassphrase))
+		if err != nil {
+			exitWithError("loading hash index", err)
+		}
+		index, err := leakcheck.Load(alexDir, key)
+		if err != nil {
+			exitWithError("loading hash index", err)
+		}
+
+		diffCmd := exec.Command("git", "diff", "--cached", "-U0", "--no-color", "--no-ext-diff", "--diff-filter=ACMR")
+		output, err := diffCmd.Output()
+		if err != nil {
+			exitWithError("reading staged changes", err)
+		}
+
+		lines, err := leakcheck.ParseDiff(bytes.NewReader(output))
+		if err != nil {
+			exitWithError("parsing staged changes", err)
+		}
+
+		findings := index.Check(lines)
+		if len(findings) == 0 {
+			return
+		}
+
+		currentProject := secrets.GetProjectID()
+		fmt.Fprintln(os.Stderr, "✗ alex: commit blocked - staged changes contain stored secrets")
+		fmt.Fprintln(os.Stderr)
+		for _, f := range findings {
+			location := fmt.Sprintf("%s:%d", f.File, f.Line)
+			fmt.Fprintf(os.Stderr, "  %-40s %s (%s)\n", location, f.Key, leakScopeLabel(f.Scope, currentProject))
+		}
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Remove the values and re-stage, or add 'alex:allow' to lines that are intentional.")
+		fmt.Fprintln(os.Stderr, "To skip this check once: git commit --no-verify")
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hookCmd)
+	hookCmd.AddCommand(hookInstallCmd)
+	hookCmd.AddCommand(hookUninstallCmd)
+	hookCmd.AddCommand(hookRefreshCmd)
+	hookCmd.AddCommand(hookPreCommitCmd)
+	hookCmd.PersistentFlags().BoolVar(&hookPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+}
+
+// installPreCommitHook builds the hash index and installs the hook for gitRoot
+func installPreCommitHook(gitRoot string, unlocker *secrets.Unlocker) error {
+	count, err := rebuildLeakIndex(unlocker)
+	if err != nil {
+		return fmt.Errorf("building hash index: %w", err)
+	}
+
+	if hasPreCommitFramework(gitRoot) {
+		fmt.Println("Detected the pre-commit framework (.pre-commit-config.yaml).")
+		fmt.Println("Add this entry to your repos list and run 'pre-commit install':")
+		fmt.Println()
+		fmt.Print(preCommitFrameworkSnippet)
+		fmt.Printf("\n✓ Hash index built (%d secret(s))\n", count)
+		return nil
+	}
+
+	hookPath, err := preCommitHookPath(gitRoot)
+	if err != nil {
+		return fmt.Errorf("locating hooks directory: %w", err)
+	}
+
+	updated, err := installHookBlock(hookPath)
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		fmt.Printf("✓ Pre-commit hook installed (%s)\n", hookPath)
+	} else {
+		fmt.Printf("✓ Pre-commit hook already installed (%s)\n", hookPath)
+	}
+	fmt.Printf("✓ Hash index built (%d secret(s))\n", count)
+	return nil
+}
+
+const preCommitFrameworkSnippet = `  - repo: local
+    hooks:
+      - id: alex
+        name: alex secret check
+        entry: alex hook pre-commit
+        language: system
+        pass_filenames: false
+        always_run: true
+`
+
+// leakScope returns the index scope name of a store: "global", "group:"
+// and the group name, or "project:" and the project ID
+func leakScope(store *secrets.Store) string {
+	if globalDir, err := secrets.GetGlobalDir(); err == nil && store.Dir() == globalDir {
+		return "global"
+	}
+	if groupsDir, err := secrets.GetGroupsDir(); err == nil && filepath.Dir(store.Dir()) == groupsDir {
+		return "group:" + filepath.Base(store.Dir())
+	}
+	return "project:" + filepath.Base(store.Dir())
+}
+
+// leakScopeLabel returns a human-readable name for an index scope
+func leakScopeLabel(scope, currentProject string) string {
+	switch {
+	case scope == "global":
+		return "global"
+	case scope == "project:"+currentProject:
+		return "project"
+	case strings.HasPrefix(scope, "group:"):
+		return "group " + strings.TrimPrefix(scope, "group:")
+	default:
+		return "project " + strings.TrimPrefix(scope, "project:")
+	}
+}
+
+// rebuildLeakIndex rebuilds the hash index used by the pre-commit hook
+// and returns the number of indexed secrets
+func rebuildLeakIndex(unlocker *secrets.Unlocker) (int, error) {
+	alexDir, err := secrets.GetGlobalDir()
+	if err != nil {
+		return 0, err
+	}
+
+	index, err := buildLeakIndex(unlocker)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := index.Save(alexDir); err != nil {
+		return 0, err
+	}
+	return index.Len(), nil
+}
+
+// buildLeakIndex hashes the values of the global store and every project store.
+// Project stores that cannot be unlocked are skipped with a warning.
+func buildLeakIndex(unlocker *secrets.Unlocker) (*leakcheck.Index, error) {
+	globalStore, err := unlocker.OpenGlobal()
+	if err != nil {
+		return nil, fmt.Errorf("opening global secret store: %w", err)
+	}
+	key, err := globalStore.DerivedKey(leakcheck.KeyPurpose)
+	if err != nil {
+		return nil, fmt.Errorf("deriving hash index key: %w", err)
+	}
+	leakKey = key
+	index := leakcheck.NewIndex(key)
+
+	globalValues, err := globalStore.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading global secret store: %w", err)
+	}
+	for k, v := range globalValues {
+		index.Put("global", k, v)
+	}
+
+	projectIDs, err := secrets.ListProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range projectIDs {
+		store, err := unlocker.OpenProjectByID(id)
+		var values map[string]string
+		if err == nil {
+			values, err = store.GetAll()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping project %s: %v\n", id, err)
+			continue
+		}
+		for k, v := range values {
+			index.Put("project:"+id, k, v)
+		}
+	}
+
+	groups, err := secrets.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range groups {
+		store, err := unlocker.OpenGroup(name)
+		var values map[string]string
+		if err == nil {
+			values, err = store.GetAll()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping group %s: %v\n", name, err)
+			continue
+		}
+		for k, v := range values {
+			index.Put("group:"+name, k, v)
+		}
+	}
+
+	return index, nil
+}
+
+// leakKey is the key of the hash index once leakIndexKey derived it
+var leakKey []byte
+
+// leakIndexKey returns the key of the hash index, derived from the global
+// store so the index can't be tested against without unlocking it
+func leakIndexKey(unlocker *secrets.Unlocker) ([]byte, error) {
+	if leakKey != nil {
+		return leakKey, nil
+	}
+	store, err := unlocker.OpenGlobal()
+	if err != nil {
+		return nil, fmt.Errorf("opening global secret store: %w", err)
+	}
+	if leakKey, err = store.DerivedKey(leakcheck.KeyPurpose); err != nil {
+		return nil, fmt.Errorf("deriving hash index key: %w", err)
+	}
+	return leakKey, nil
+}
+
+// updateLeakIndex keeps the hash index in sync after a secret changes.
+// An empty value removes the secret. Does nothing if the index was never built.
+func updateLeakIndex(unlocker *secrets.Unlocker, store *secrets.Store, key, value string) {
+	alexDir, err := secrets.GetGlobalDir()
+	if err != nil || !leakcheck.Exists(alexDir) {
+		return
+	}
+
+	indexKey, err := leakIndexKey(unlocker)
+	var index *leakcheck.Index
+	if err == nil {
+		index, err = leakcheck.Load(alexDir, indexKey)
+	}
+	if err == nil {
+		if value == "" {
+			index.Remove(leakScope(store), key)
+		} else {
+			index.Put(leakScope(store), key, value)
+		}
+		err = index.Save(alexDir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update pre-commit hash index: %v\n", err)
+	}
+}
+
+// preCommitHookPath returns the pre-commit script to edit, honoring
+// core.hooksPath (husky and friends)
+func preCommitHookPath(gitRoot string) (string, error) {
+	output, err := exec.Command("git", "config", "core.hooksPath").Output()
+	if err == nil {
+		hooksPath := strings.TrimSpace(string(output))
+		if !filepath.IsAbs(hooksPath) {
+			hooksPath = filepath.Join(gitRoot, hooksPath)
+		}
+		// husky v9 points core.hooksPath at .husky/_ (generated) and runs
+		// the user's scripts from .husky/
+		if filepath.Base(hooksPath) == "_" && filepath.Base(filepath.Dir(hooksPath)) == ".husky" {
+			hooksPath = filepath.Dir(hooksPath)
+		}
+		return filepath.Join(hooksPath, "pre-commit"), nil
+	}
+
+	output, err = exec.Command("git", "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", err
+	}
+	hooksPath := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(hooksPath) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		hooksPath = filepath.Join(cwd, hooksPath)
+	}
+	return filepath.Join(hooksPath, "pre-commit"), nil
+}
+
+// hasPreCommitFramework reports whether the repo is managed by pre-commit.com
+func hasPreCommitFramework(gitRoot string) bool {
+	_, err := os.Stat(filepath.Join(gitRoot, ".pre-commit-config.yaml"))
+	return err == nil
+}
+
+// hookBlock returns the shell snippet that runs the check
+func hookBlock() string {
+	alexPath := "alex"
+	if exe, err := os.Executable(); err == nil {
+		alexPath = exe
+	}
+	return fmt.Sprintf(`%s
+if command -v alex >/dev/null 2>&1; then
+  alex hook pre-commit || exit 1
+elif [ -x %q ]; then
+  %q hook pre-commit || exit 1
+else
+  echo "alex: not found, skipping secret check" >&2
+fi
+%s
+`, hookBlockStart, alexPath, alexPath, hookBlockEnd)
+}
+
+// installHookBlock adds the alex block to a pre-commit script, keeping any
+// existing content. The block goes right after the shebang so that an
+// "exit 0" at the end of an existing hook cannot skip it.
+// Returns false if the block was already present.
+func installHookBlock(path string) (bool, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	content := string(existing)
+
+	if strings.Contains(content, hookBlockStart) {
+		return false, nil
+	}
+
+	if content == "" {
+		content = "#!/bin/sh\n" + hookBlock()
+	} else if strings.HasPrefix(content, "#!") {
+		idx := strings.Index(content, "\n")
+		if idx == -1 {
+			content += "\n" + hookBlock()
+		} else {
+			content = content[:idx+1] + hookBlock() + content[idx+1:]
+		}
+	} else {
+		content = hookBlock() + content
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		return false, err
+	}
+	// WriteFile keeps the mode of existing files
+	return true, os.Chmod(path, 0755)
+}
+
+// uninstallHookBlock removes the alex block, deleting the script if nothing else is left.
+// Returns false if the block was not present.
+func uninstallHookBlock(path string) (bool, error) {
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	content := string(existing)
+
+	start := strings.Index(content, hookBlockStart)
+	end := strings.Index(content, hookBlockEnd)
+	if start == -1 || end == -1 || end < start {
+		return false, nil
+	}
+	end += len(hookBlockEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	content = content[:start] + content[end:]
+
+	if strings.TrimSpace(strings.TrimPrefix(content, "#!/bin/sh")) == "" {
+		return true, os.Remove(path)
+	}
+	return true, os.WriteFile(path, []byte(content), 0755)
+}
@@ -7,7 +7,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/portdeveloper/alex/internal/secrets"
 	"github.com/spf13/cobra"
 )
 
@@ -26,7 +25,8 @@ Parses KEY=VALUE pairs from the file, skipping comments (#) and empty lines.
 Supports quoted values (single and double quotes).
 
 Imports to project scope by default.
-Use --global to import to global scope.
+Use --global to import to global scope, --group to import to a group,
+--dir or --here to import to the store of a subdirectory of the project.
 
 After importing, consider deleting the .env file to keep secrets out of
 your repository and away from AI agents.
@@ -35,7 +35,9 @@ Examples:
   alex import .env                    # Import from .env
   alex import .env.local              # Import from .env.local (Next.js/React)
   alex import .env --global           # Import to global scope
-  alex import .env --prefix DB_       # Only import vars starting with DB_`,
+  alex import .env --group acme-backend
+  alex import .env --prefix DB_       # Only import vars starting with DB_
+  cd services/billing && alex import .env --here`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		filePath := args[0]
@@ -76,33 +78,19 @@ Examples:
 			return
 		}
 
-		// Get passphrase
-		passphrase, err := getPassphrase(importPassphrase)
-		if err != nil {
-			exitWithError("getting passphrase", err)
-		}
+		unlocker := newUnlocker(importPassphrase)
 
-		var store *secrets.Store
-		var scope string
-
-		if importGlobal {
-			store, err = secrets.NewGlobalStore(passphrase)
-			scope = "global"
-		} else {
-			store, err = secrets.NewProjectStore(passphrase)
-			scope = "project"
-		}
-		if err != nil {
-			exitWithError("opening secret store", err)
-		}
+		store, scope := openScopedStore(unlocker, importGlobal)
 
 		// Import each secret
+		stored := store.List()
 		var importedKeys, updatedKeys []string
 		for key, value := range envVars {
-			_, exists := store.Get(key)
+			_, exists := stored[key]
 			if err := store.Set(key, value); err != nil {
 				exitWithError(fmt.Sprintf("saving secret '%s'", key), err)
 			}
+			updateLeakIndex(unlocker, store, key, value)
 			if exists {
 				updatedKeys = append(updatedKeys, key)
 			} else {
@@ -136,9 +124,10 @@ Examples:
 
 func init() {
 	rootCmd.AddCommand(importCmd)
-	importCmd.Flags().BoolVar(&importPassphrase, "passphrase", false, "Use a passphrase instead of machine ID")
+	importCmd.Flags().BoolVar(&importPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
 	importCmd.Flags().StringVar(&importPrefix, "prefix", "", "Only import variables with this prefix")
 	importCmd.Flags().BoolVarP(&importGlobal, "global", "g", false, "Import into global scope (~/.alex/) instead of project")
+	addScopeFlags(importCmd)
 }
 
 // parseResult holds parsed env vars and any skipped entries
@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/discover"
+	"github.com/portdeveloper/alex/internal/manifest"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initPassphrase bool
+	initYes        bool
+	initNoImport   bool
+	initOverwrite  bool
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up alex for the current repository",
+	Long: `Set up alex for the current repository.
+
+alex init walks through onboarding a project:
+  - shows how the project is identified (and warns if there is no git remote)
+  - finds .env* files, docker compose env_file entries and framework
+    conventions (Next.js, Vite, Django, Rails) and offers to import the
+    keys not stored yet; stored values that differ are only replaced with
+    --overwrite or after asking, even with --yes
+  - makes sure .gitignore covers secret files
+  - optionally installs the pre-commit hook (see 'alex hook')
+  - writes a names-only manifest (.alex.json) that is safe to commit
+
+Running it again is safe: it only reports what changed since last time,
+such as new env files or secrets missing from this machine.
+
+Examples:
+  alex init
+  alex init --yes          # Accept every suggestion (never replaces stored values)
+  alex init --overwrite    # Replace stored values that differ from the env files
+  alex init --no-import    # Only check files and update the manifest`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		root := secrets.ResolveProject().Root
+
+		fmt.Println("alex init")
+		fmt.Println("=========")
+		fmt.Println()
+
+		printProjectIdentity(root)
+
+		found := discover.Project(root)
+		printFrameworks(found.Frameworks)
+
+		unlocker := newUnlocker(initPassphrase)
+
+		store, err := unlocker.OpenProject()
+		if err != nil {
+			exitWithError("opening project secret store", err)
+		}
+
+		importDiscoveredFiles(unlocker, store, root, found)
+		ensureGitignore(root, found.GitignorePatterns())
+		offerHookInstall(root, unlocker)
+		updateManifest(root, store)
+
+		fmt.Println("Done. Run 'alex init' again any time to check for drift.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVar(&initPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	initCmd.Flags().BoolVarP(&initYes, "yes", "y", false, "Accept every suggestion without prompting")
+	initCmd.Flags().BoolVar(&initNoImport, "no-import", false, "Don't offer to import env files")
+	initCmd.Flags().BoolVar(&initOverwrite, "overwrite", false, "Replace stored secrets whose value differs from an env file's")
+}
+
+// initConfirm asks for confirmation unless --yes was given
+func initConfirm(prompt string) bool {
+	if initYes {
+		return true
+	}
+	return confirmAction(prompt)
+}
+
+func printProjectIdentity(root string) {
+	fmt.Println("Project")
+	fmt.Println("-------")
+	fmt.Printf("  Root:    %s\n", root)
+	project := secrets.ResolveProject()
+	fmt.Printf("  ID:      %s\n", project.ID)
+
+	if project.Pin != "" {
+		fmt.Printf("  Pinned:  %s (from %s)\n", project.Pin, project.PinFile)
+	} else if project.Remote != "" {
+		fmt.Printf("  Remote:  %s\n", project.Remote)
+	} else if !project.InGit() {
+		fmt.Printf("  Warning: not in a git repository - secrets are tied to this path and\n")
+		fmt.Printf("           won't survive moving the project. Pin it with:\n")
+		fmt.Printf("           alex projects pin\n")
+	} else {
+		fmt.Printf("  Warning: no git remote - secrets are tied to this path and won't\n")
+		fmt.Printf("           survive moving the project. Add one with:\n")
+		fmt.Printf("           git remote add origin <url>\n")
+	}
+	fmt.Println()
+}
+
+func printFrameworks(frameworks []discover.Framework) {
+	if len(frameworks) == 0 {
+		return
+	}
+	fmt.Println("Frameworks")
+	fmt.Println("----------")
+	for _, fw := range frameworks {
+		fmt.Printf("  %-8s %s\n", fw.Name, fw.Note)
+	}
+	fmt.Println()
+}
+
+// importDiscoveredFiles offers to import each env file that has keys not
+// yet in the store. Keys stored with another value are only replaced with
+// --overwrite or an explicit confirmation, never by --yes alone: a stale
+// env file must not silently clobber the stored secret.
+func importDiscoveredFiles(unlocker *secrets.Unlocker, store *secrets.Store, root string, found *discover.Result) {
+	fmt.Println("Env Files")
+	fmt.Println("---------")
+
+	if len(found.EnvFiles) == 0 {
+		fmt.Println("  No env files found")
+		fmt.Println()
+		return
+	}
+
+	for _, file := range found.EnvFiles {
+		parsed, err := parseEnvFile(filepath.Join(root, file.Path))
+		if err != nil {
+			fmt.Printf("  %-24s cannot read: %v\n", file.Path, err)
+			continue
+		}
+
+		missing := make(map[string]string)
+		conflicting := make(map[string]string)
+		for k, v := range parsed.vars {
+			existing, ok, err := store.Get(k)
+			if err != nil {
+				exitWithError("reading project secret store", err)
+			}
+			switch {
+			case !ok:
+				missing[k] = v
+			case existing != v:
+				conflicting[k] = v
+			}
+		}
+
+		if len(missing) == 0 && len(conflicting) == 0 {
+			fmt.Printf("  %-24s %d key(s), already imported - consider deleting it\n", file.Path, len(parsed.vars))
+			continue
+		}
+		fmt.Printf("  %-24s %d key(s) to import, %d stored with another value (%s)\n", file.Path, len(missing), len(conflicting), file.Reason)
+		if initNoImport {
+			continue
+		}
+
+		if len(missing) > 0 && initConfirm(fmt.Sprintf("  Import %d new key(s) from %s into project scope?", len(missing), file.Path)) {
+			keys := importValues(unlocker, store, missing)
+			fmt.Printf("  ✓ Imported %d secret(s) from %s\n", len(keys), file.Path)
+			printKeyList("    ", keys)
+		}
+
+		if len(conflicting) == 0 {
+			continue
+		}
+		fmt.Printf("  Stored with a different value than in %s:\n", file.Path)
+		printKeyList("    ", sortedKeys(conflicting))
+		if !initOverwrite && (initYes || !confirmAction(fmt.Sprintf("  Replace the stored values with those in %s?", file.Path))) {
+			fmt.Println("  Kept the stored values (use --overwrite to replace them)")
+			continue
+		}
+		keys := importValues(unlocker, store, conflicting)
+		fmt.Printf("  ✓ Replaced %d secret(s) from %s\n", len(keys), file.Path)
+	}
+
+	if len(found.Templates) > 0 {
+		fmt.Printf("  Templates (not imported): %s\n", strings.Join(found.Templates, ", "))
+	}
+	fmt.Println()
+}
+
+// importValues stores values in the project store and returns their
+// sorted names
+func importValues(unlocker *secrets.Unlocker, store *secrets.Store, values map[string]string) []string {
+	for k, v := range values {
+		if err := store.Set(k, v); err != nil {
+			exitWithError(fmt.Sprintf("saving secret '%s'", k), err)
+		}
+		updateLeakIndex(unlocker, store, k, v)
+	}
+	return sortedKeys(values)
+}
+
+// sortedKeys returns the keys of values, sorted
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ensureGitignore appends any missing secret file patterns to .gitignore
+func ensureGitignore(root string, patterns []string) {
+	fmt.Println("Gitignore")
+	fmt.Println("---------")
+
+	path := filepath.Join(root, ".gitignore")
+	existing, err := readLines(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("  Warning: cannot read .gitignore: %v\n", err)
+		fmt.Println()
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, line := range existing {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	var missing []string
+	for _, p := range patterns {
+		if !present[p] {
+			missing = append(missing, p)
+		}
+	}
+
+	if len(missing) == 0 {
+		fmt.Println("  ✓ Secret files already ignored")
+		fmt.Println()
+		return
+	}
+
+	var b strings.Builder
+	if len(existing) > 0 && strings.TrimSpace(existing[len(existing)-1]) != "" {
+		b.WriteString("\n")
+	}
+	b.WriteString("# Secrets (managed by alex)\n")
+	for _, p := range missing {
+		b.WriteString(p + "\n")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.WriteString(b.String())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("  Warning: cannot update .gitignore: %v\n", err)
+	} else {
+		fmt.Printf("  ✓ Added %s\n", strings.Join(missing, ", "))
+	}
+	fmt.Println()
+}
+
+// offerHookInstall installs the pre-commit hook if the user wants it
+func offerHookInstall(root string, unlocker *secrets.Unlocker) {
+	if secrets.GetProjectRoot() == "" {
+		return
+	}
+
+	fmt.Println("Pre-commit Hook")
+	fmt.Println("---------------")
+
+	hookPath, err := preCommitHookPath(root)
+	if err == nil && hookInstalled(hookPath) {
+		fmt.Printf("  ✓ Already installed (%s)\n", hookPath)
+		fmt.Println()
+		return
+	}
+
+	if initConfirm("  Install a hook that blocks commits containing stored secrets?") {
+		if err := installPreCommitHook(root, unlocker); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		}
+	}
+	fmt.Println()
+}
+
+// updateManifest writes the names-only manifest and reports drift between
+// the manifest and this machine's project store
+func updateManifest(root string, store *secrets.Store) {
+	fmt.Printf("Manifest (%s)\n", manifest.FileName)
+	fmt.Println("---------------------")
+
+	m, err := manifest.LoadOrEmpty(root)
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		fmt.Println()
+		return
+	}
+
+	stored := store.List()
+	var missing []string
+	for _, name := range m.Secrets {
+		if _, ok := stored[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	var added []string
+	for name := range stored {
+		if m.Add(name) {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+
+	if err := m.Save(root); err != nil {
+		fmt.Printf("  Warning: cannot write manifest: %v\n", err)
+		fmt.Println()
+		return
+	}
+
+	if len(added) > 0 {
+		fmt.Printf("  ✓ Added %d name(s)\n", len(added))
+		printKeyList("    ", added)
+	}
+	fmt.Printf("  ✓ Lists %d secret name(s) - safe to commit\n", len(m.Secrets))
+
+	if len(missing) > 0 {
+		fmt.Printf("  Missing on this machine (set them with 'alex set KEY'):\n")
+		printKeyList("    ", missing)
+	}
+	fmt.Println()
+}
+
+// hookInstalled reports whether the alex block is present in a hook script
+func hookInstalled(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(data), hookBlockStart)
+}
+
+// readLines returns the lines of a file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
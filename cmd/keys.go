@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keysGlobal          bool
+	keysAll             bool
+	keysName            string
+	keysKeyFile         string
+	keysNewPassphraseFD int
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the key slots of your stores",
+	Long: `Manage the key slots of your stores.
+
+Every store has a primary key (the machine ID by default) and can have
+extra key slots: a personal passphrase, a recovery key, an age identity
+or SSH key. Any one of them unlocks the store on its own. When the
+primary key fails, for example after the machine ID changed, alex tries
+the other slots.
+
+Adding or removing a slot only re-wraps the store's data key; the secrets
+themselves are untouched.
+
+The commands work on the current project's store, the global store with
+--global, or every store with --all.
+
+Examples:
+  alex keys list --all
+  alex keys add passphrase --name backup
+  alex keys add identity --key-file ~/.config/age/keys.txt --all
+  alex keys remove backup`,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the key slots of stores",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		stores := selectedStores()
+
+		out := keySlotsOutput{Stores: []storeKeySlots{}}
+		for _, ref := range stores {
+			slots, err := secrets.ListSlots(ref.Dir)
+			if err != nil {
+				exitWithError(fmt.Sprintf("reading key header of %s store", ref.Label), err)
+			}
+			entry := storeKeySlots{Store: ref.Label, Dir: ref.Dir, Slots: []keySlotOutput{}}
+			for _, slot := range slots {
+				entry.Slots = append(entry.Slots, keySlotOutput{
+					Name:          slot.Name,
+					Mode:          slot.Mode,
+					Identity:      slot.Identity,
+					Recipient:     slot.Recipient,
+					MachineSource: slot.MachineSource,
+				})
+			}
+			out.Stores = append(out.Stores, entry)
+		}
+
+		if machineOutput() {
+			printResult("key_slots", out)
+			return
+		}
+		for i, s := range out.Stores {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", s.Store)
+			if len(s.Slots) == 0 {
+				fmt.Println("  (no key header yet)")
+			}
+			for _, slot := range s.Slots {
+				fmt.Printf("  %-12s %-11s %s\n", slot.Name, slot.Mode, slotDetail(slot))
+			}
+		}
+	},
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add MODE",
+	Short: "Add a key slot to stores",
+	Long: `Add a key slot that unlocks the selected stores on its own.
+
+MODE is one of machine, passphrase, identity, ssh or plugin. --key-file
+names the identity file, SSH key or plugin identity, and defaults to the
+one set in ~/.alex/config.json. The slot is named after MODE unless
+--name is given.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"machine", "passphrase", "identity", "ssh", "plugin"},
+	Run: func(cmd *cobra.Command, args []string) {
+		target := secrets.RekeyTarget{Mode: secrets.KeyMode(args[0]), KeyFile: keysKeyFile}
+		name := keysName
+		if name == "" {
+			name = args[0]
+		}
+
+		unlocker := newUnlocker(false)
+		opened := openSelectedStores(unlocker)
+
+		if keysNewPassphraseFD >= 0 {
+			target.Passphrase = secrets.PassphraseFD(keysNewPassphraseFD)
+		} else {
+			target.Passphrase = secrets.PromptPassphrase(readConfirmedPassphrase)
+		}
+		key, err := unlocker.TargetKey(target)
+		if err != nil {
+			exitWithError("getting the new key", err)
+		}
+
+		failed := 0
+		for _, o := range opened {
+			if err := o.store.AddSlot(name, key); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", o.ref.Label, err)
+				failed++
+				continue
+			}
+			fmt.Printf("✓ %s: added %s key slot %q\n", o.ref.Label, key.Mode(), name)
+		}
+		if failed > 0 {
+			exitWithError(fmt.Sprintf("%d store(s) could not be changed", failed), errors.New("see messages above"))
+		}
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Remove a key slot from stores",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		stores := selectedStores()
+
+		failed := 0
+		for _, ref := range stores {
+			// The remaining keys keep working, so nothing needs unlocking
+			if err := secrets.RemoveSlot(ref.Dir, name); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", ref.Label, err)
+				failed++
+				continue
+			}
+			fmt.Printf("✓ %s: removed key slot %q\n", ref.Label, name)
+		}
+		if failed > 0 {
+			exitWithError(fmt.Sprintf("%d store(s) could not be changed", failed), errors.New("see messages above"))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysListCmd, keysAddCmd, keysRemoveCmd)
+	keysCmd.PersistentFlags().BoolVarP(&keysGlobal, "global", "g", false, "Use the global store instead of the project's")
+	keysCmd.PersistentFlags().BoolVar(&keysAll, "all", false, "Use every global and project store")
+	keysAddCmd.Flags().StringVar(&keysName, "name", "", "Name of the new slot (default: MODE)")
+	keysAddCmd.Flags().StringVar(&keysKeyFile, "key-file", "", "Identity file, SSH key or plugin identity of the new key")
+	keysAddCmd.Flags().IntVar(&keysNewPassphraseFD, "new-passphrase-fd", -1, "Read the new passphrase from this file descriptor")
+}
+
+// keySlotsOutput is the machine-readable result of keys list
+type keySlotsOutput struct {
+	Stores []storeKeySlots `json:"stores"`
+}
+
+type storeKeySlots struct {
+	Store string          `json:"store"`
+	Dir   string          `json:"dir"`
+	Slots []keySlotOutput `json:"slots"`
+}
+
+type keySlotOutput struct {
+	Name          string          `json:"name"`
+	Mode          secrets.KeyMode `json:"mode"`
+	Identity      string          `json:"identity,omitempty"`
+	Recipient     string          `json:"recipient,omitempty"`
+	MachineSource string          `json:"machine_source,omitempty"`
+}
+
+// slotDetail describes where the key of a slot comes from
+func slotDetail(slot keySlotOutput) string {
+	switch {
+	case slot.Identity != "":
+		return slot.Identity
+	case slot.Recipient != "":
+		return slot.Recipient
+	case slot.MachineSource != "":
+		return slot.MachineSource
+	}
+	return ""
+}
+
+// selectedStores returns the stores chosen by --global and --all
+func selectedStores() []storeRef {
+	if keysGlobal && keysAll {
+		exitWithError("--global and --all cannot be combined", nil)
+	}
+	if keysAll {
+		stores, err := allStores()
+		if err != nil {
+			exitWithError("listing stores", err)
+		}
+		if len(stores) == 0 {
+			exitWithError("no stores found", nil)
+		}
+		return stores
+	}
+
+	if keysGlobal {
+		dir, err := secrets.GetGlobalDir()
+		if err != nil {
+			exitWithError("getting global store", err)
+		}
+		if !secrets.GlobalStoreExists() {
+			exitWithError("no global store yet", nil)
+		}
+		return []storeRef{{Label: "global", Dir: dir}}
+	}
+
+	exists, err := secrets.ProjectStoreExists()
+	if err != nil {
+		exitWithError("checking project store", err)
+	}
+	if !exists {
+		exitWithError("no project store yet (use --global or --all for other stores)", nil)
+	}
+	projectsDir, err := secrets.GetProjectsDir()
+	if err != nil {
+		exitWithError("getting project store", err)
+	}
+	id := secrets.GetProjectID()
+	return []storeRef{{Label: "project " + id, Dir: filepath.Join(projectsDir, id)}}
+}
+
+type openedStore struct {
+	ref   storeRef
+	store *secrets.Store
+}
+
+// openSelectedStores unlocks every selected store, exiting if any fails
+func openSelectedStores(unlocker *secrets.Unlocker) []openedStore {
+	var opened []openedStore
+	for _, ref := range selectedStores() {
+		store, err := unlocker.Open(ref.Dir)
+		if err != nil {
+			exitWithError(fmt.Sprintf("opening %s store", ref.Label), err)
+		}
+		opened = append(opened, openedStore{ref: ref, store: store})
+	}
+	return opened
+}
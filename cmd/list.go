@@ -4,86 +4,165 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/portdeveloper/alex/internal/secrets"
 	"github.com/spf13/cobra"
 )
 
-var listPassphrase bool
+var (
+	listPassphrase bool
+	listEffective  bool
+)
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List stored secrets (names only)",
 	Long: `List all stored secrets. Only shows names, not values.
 
-Shows both global (~/.alex/) and project secrets.
-Project is auto-detected from git root or current directory.
+Shows global (~/.alex/) secrets, those of the groups the project uses
+(see 'alex group') and project secrets. Project is auto-detected from
+git root or current directory. In a subdirectory, the secrets stored for
+it and the directories above it with 'alex set --dir' are listed too.
+
+--effective shows what 'alex run' injects instead: each secret once, the
+scope that supplies it and the values of other scopes it shadows. Values
+are compared by fingerprint, a keyed hash that only compares with other
+fingerprints from this machine, so none are shown.
 
-Example:
-  alex list`,
+Examples:
+  alex list
+  alex list --effective     # What 'alex run' injects and what it overrides
+  alex list --output json   # Machine-readable, for scripts and editors`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		passphrase, err := getPassphrase(listPassphrase)
-		if err != nil {
-			exitWithError("getting passphrase", err)
+		unlocker := newUnlocker(listPassphrase)
+
+		if listEffective {
+			out := buildEffective(layerValuesOf(openLayers(unlocker)), fingerprintKey())
+			out.ProjectID = secrets.GetProjectID()
+			if machineOutput() {
+				printResult("secret_effective", out)
+				return
+			}
+			printEffective(os.Stdout, out)
+			return
 		}
 
-		// Load global store
-		globalStore, err := secrets.NewGlobalStore(passphrase)
-		if err != nil {
-			exitWithError("opening global secret store", err)
+		var layers []listLayer
+		for _, layer := range openLayers(unlocker) {
+			layers = append(layers, listLayer{scope: layer.scope, secrets: layer.store.List()})
 		}
-		globalSecrets := globalStore.List()
-
-		// Load project store if it exists
-		var projectSecrets map[string]secrets.Secret
-		projectExists, projectErr := secrets.ProjectStoreExists()
-		if projectErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", projectErr)
-		} else if projectExists {
-			projectStore, err := secrets.NewProjectStore(passphrase)
-			if err != nil {
-				exitWithError("opening project secret store", err)
-			}
-			projectSecrets = projectStore.List()
+
+		if machineOutput() {
+			printResult("secret_list", buildListOutput(layers))
+			return
 		}
 
-		totalCount := len(globalSecrets) + len(projectSecrets)
+		globalCount, groupCount, projectCount := 0, 0, 0
+		for i, layer := range layers {
+			switch {
+			case i == 0:
+				globalCount = len(layer.secrets)
+			case strings.HasPrefix(layer.scope, "group:"):
+				groupCount += len(layer.secrets)
+			default:
+				projectCount += len(layer.secrets)
+			}
+		}
+		totalCount := globalCount + groupCount + projectCount
 		if totalCount == 0 {
 			fmt.Println("No secrets stored. Use 'alex set KEY VALUE' to add one.")
 			return
 		}
 
-		// Print global secrets
-		if len(globalSecrets) > 0 {
-			fmt.Println("GLOBAL:")
-			printSecretList(globalSecrets)
-		}
-
-		// Print project secrets
-		if len(projectSecrets) > 0 {
-			if len(globalSecrets) > 0 {
+		printed := false
+		for _, layer := range layers {
+			if len(layer.secrets) == 0 {
+				continue
+			}
+			if printed {
 				fmt.Println()
 			}
-			fmt.Println("PROJECT:")
-			printSecretList(projectSecrets)
+			fmt.Printf("%s:\n", scopeHeader(layer.scope))
+			printSecretList(layer.secrets)
+			printed = true
 		}
 
 		fmt.Printf("\n%d secret(s) stored", totalCount)
-		if len(globalSecrets) > 0 && len(projectSecrets) > 0 {
-			fmt.Printf(" (%d global, %d project)", len(globalSecrets), len(projectSecrets))
+		if groupCount > 0 {
+			fmt.Printf(" (%d global, %d group, %d project)", globalCount, groupCount, projectCount)
+		} else if globalCount > 0 && projectCount > 0 {
+			fmt.Printf(" (%d global, %d project)", globalCount, projectCount)
 		}
 		fmt.Println()
 	},
 }
 
-func printSecretList(secretList map[string]secrets.Secret) {
+// listOutput is the machine-readable form of 'alex list'
+type listOutput struct {
+	ProjectID string      `json:"project_id"`
+	Secrets   []listEntry `json:"secrets"`
+}
+
+// listEntry describes one stored secret. Values are never included.
+type listEntry struct {
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ShadowedBy string    `json:"shadowed_by,omitempty"`
+	Shadows    []string  `json:"shadows,omitempty"`
+}
+
+// listLayer is the secrets of one layer, see openLayers
+type listLayer struct {
+	scope   string
+	secrets map[string]secrets.Secret
+}
+
+// buildListOutput lists the secrets of every layer. layers go from the
+// least to the most specific, starting with global, then groups.
+func buildListOutput(layers []listLayer) listOutput {
+	out := listOutput{ProjectID: secrets.GetProjectID(), Secrets: []listEntry{}}
+
+	for i, layer := range layers {
+		for _, name := range sortedSecretNames(layer.secrets) {
+			entry := listEntry{
+				Name:      name,
+				Scope:     layer.scope,
+				CreatedAt: layer.secrets[name].CreatedAt,
+				UpdatedAt: layer.secrets[name].UpdatedAt,
+			}
+			for j := len(layers) - 1; j > i; j-- {
+				if _, ok := layers[j].secrets[name]; ok {
+					entry.ShadowedBy = layers[j].scope
+					break
+				}
+			}
+			for j := i - 1; j >= 0; j-- {
+				if _, ok := layers[j].secrets[name]; ok {
+					entry.Shadows = append(entry.Shadows, layers[j].scope)
+				}
+			}
+			out.Secrets = append(out.Secrets, entry)
+		}
+	}
+	return out
+}
+
+func sortedSecretNames(secretList map[string]secrets.Secret) []string {
 	keys := make([]string, 0, len(secretList))
 	for k := range secretList {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	return keys
+}
+
+func printSecretList(secretList map[string]secrets.Secret) {
+	keys := sortedSecretNames(secretList)
 
 	fmt.Printf("  %-28s %s\n", "NAME", "UPDATED")
 	fmt.Printf("  %-28s %s\n", "----", "-------")
@@ -96,7 +175,8 @@ func printSecretList(secretList map[string]secrets.Secret) {
 
 func init() {
 	rootCmd.AddCommand(listCmd)
-	listCmd.Flags().BoolVar(&listPassphrase, "passphrase", false, "Use a passphrase instead of machine ID")
+	listCmd.Flags().BoolVar(&listPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	listCmd.Flags().BoolVar(&listEffective, "effective", false, "Show what 'alex run' injects and which values each secret shadows")
 }
 
 // formatTimeAgo formats a time as a human-readable "time ago" string
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migratePassphrase bool
+	migrateCheck      bool
+	migrateRollback   bool
+	migrateYes        bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade stores to the current storage format",
+	Long: `Upgrade every store to the current storage format.
+
+Stores are also upgraded automatically the first time a command unlocks
+them. Before a store is upgraded, the original file is kept next to it as
+secrets.enc.v<N>.bak. When a store keyed with the machine ID alone moves to
+the install secret key, its backups move along: restored, they open with
+this version, not with one from before the install secret.
+
+  --check     list each store's format and pending migrations without
+              unlocking anything (exits 1 if any store needs migrating)
+  --rollback  restore the pre-migration backups, e.g. to go back to an
+              older alex; secrets changed since the migration are lost
+
+Examples:
+  alex migrate
+  alex migrate --check
+  alex migrate --rollback`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if migrateCheck && migrateRollback {
+			exitWithError("--check and --rollback cannot be combined", nil)
+		}
+
+		stores, err := allStores()
+		if err != nil {
+			exitWithError("listing stores", err)
+		}
+
+		switch {
+		case migrateCheck:
+			checkMigrations(stores)
+		case migrateRollback:
+			rollbackMigrations(stores)
+		default:
+			runMigrations(stores)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().BoolVar(&migratePassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	migrateCmd.Flags().BoolVar(&migrateCheck, "check", false, "Report pending migrations without changing anything")
+	migrateCmd.Flags().BoolVar(&migrateRollback, "rollback", false, "Restore the pre-migration backups")
+	migrateCmd.Flags().BoolVarP(&migrateYes, "yes", "y", false, "Don't ask for confirmation")
+}
+
+// storeRef is a store on disk with a human-readable label
+type storeRef struct {
+	Label string
+	Dir   string
+}
+
+// allStores returns the global store and every group and project store
+// that exist
+func allStores() ([]storeRef, error) {
+	var stores []storeRef
+
+	globalDir, err := secrets.GetGlobalDir()
+	if err != nil {
+		return nil, err
+	}
+	if secrets.GlobalStoreExists() {
+		stores = append(stores, storeRef{Label: "global", Dir: globalDir})
+	}
+
+	projectIDs, err := secrets.ListProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+	projectsDir, err := secrets.GetProjectsDir()
+	if err != nil {
+		return nil, err
+	}
+	currentID := secrets.GetProjectID()
+	for _, id := range projectIDs {
+		label := "project " + id
+		if id == currentID {
+			label += " (current)"
+		}
+		stores = append(stores, storeRef{Label: label, Dir: filepath.Join(projectsDir, id)})
+	}
+
+	groups, err := secrets.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range groups {
+		if dir, _ := secrets.GroupDir(name); secrets.StoreExists(dir) {
+			stores = append(stores, storeRef{Label: "group " + name, Dir: dir})
+		}
+	}
+	return stores, nil
+}
+
+// migrationOutput is the machine-readable result of migrate --check
+type migrationOutput struct {
+	CurrentFormat int               `json:"current_format"`
+	Stores        []migrationStatus `json:"stores"`
+}
+
+type migrationStatus struct {
+	Store   string   `json:"store"`
+	Dir     string   `json:"dir"`
+	Format  int      `json:"format"`
+	Pending []string `json:"pending"`
+	Backups []string `json:"backups"`
+}
+
+func checkMigrations(stores []storeRef) {
+	out := migrationOutput{CurrentFormat: secrets.CurrentFormat, Stores: []migrationStatus{}}
+	pending := 0
+	for _, store := range stores {
+		status, err := secrets.CheckMigration(store.Dir)
+		if err != nil {
+			exitWithError(fmt.Sprintf("checking %s store", store.Label), err)
+		}
+		out.Stores = append(out.Stores, migrationStatus{
+			Store:   store.Label,
+			Dir:     store.Dir,
+			Format:  status.Version,
+			Pending: nonNil(status.Pending),
+			Backups: nonNil(status.Backups),
+		})
+		if len(status.Pending) > 0 {
+			pending++
+		}
+	}
+
+	if machineOutput() {
+		printResult("migration_status", out)
+	} else {
+		fmt.Printf("Current storage format: v%d\n\n", secrets.CurrentFormat)
+		if len(out.Stores) == 0 {
+			fmt.Println("No stores found.")
+		}
+		for _, s := range out.Stores {
+			state := "up to date"
+			if len(s.Pending) > 0 {
+				state = "needs migration"
+			}
+			fmt.Printf("  %-36s v%d  %s\n", s.Store, s.Format, state)
+			for _, p := range s.Pending {
+				fmt.Printf("      pending: %s\n", p)
+			}
+			for _, b := range s.Backups {
+				fmt.Printf("      backup:  %s\n", b)
+			}
+		}
+		if pending > 0 {
+			fmt.Printf("\n%d store(s) need migrating. Run 'alex migrate' or use alex as usual.\n", pending)
+		}
+	}
+
+	if pending > 0 {
+		os.Exit(1)
+	}
+}
+
+func runMigrations(stores []storeRef) {
+	unlocker := newUnlocker(migratePassphrase)
+
+	migrated, failed := 0, 0
+	for _, store := range stores {
+		status, err := secrets.CheckMigration(store.Dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", store.Label, err)
+			failed++
+			continue
+		}
+		if len(status.Pending) == 0 {
+			continue
+		}
+
+		if _, err := unlocker.Open(store.Dir); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", store.Label, err)
+			failed++
+			continue
+		}
+		after, _ := secrets.CheckMigration(store.Dir)
+		fmt.Printf("✓ %s: v%d → v%d (backup: %s)\n", store.Label, status.Version, after.Version, strings.Join(after.Backups, ", "))
+		migrated++
+	}
+
+	if migrated == 0 && failed == 0 {
+		fmt.Printf("All stores already use format v%d.\n", secrets.CurrentFormat)
+	}
+	if failed > 0 {
+		exitWithError(fmt.Sprintf("%d store(s) could not be migrated", failed), nil)
+	}
+}
+
+func rollbackMigrations(stores []storeRef) {
+	var withBackups []storeRef
+	for _, store := range stores {
+		status, err := secrets.CheckMigration(store.Dir)
+		if err != nil {
+			exitWithError(fmt.Sprintf("checking %s store", store.Label), err)
+		}
+		if len(status.Backups) > 0 {
+			withBackups = append(withBackups, store)
+		}
+	}
+
+	if len(withBackups) == 0 {
+		fmt.Println("No migration backups to restore.")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Restoring pre-migration backups of %d store(s).\n", len(withBackups))
+	fmt.Fprintln(os.Stderr, "Secrets set, changed or removed since the migration will be lost.")
+	fmt.Fprintln(os.Stderr, "The restored stores are in the old format for an older alex, and their key")
+	fmt.Fprintln(os.Stderr, "headers are removed, except for machine-keyed ones that say how the key is derived:")
+	fmt.Fprintln(os.Stderr, "this version migrates them again when it next opens them.")
+	if !migrateYes && !confirmAction("Continue?") {
+		fmt.Println("Cancelled.")
+		return
+	}
+
+	failed := 0
+	for _, store := range withBackups {
+		version, err := secrets.RollbackMigration(store.Dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", store.Label, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ %s: restored format v%d\n", store.Label, version)
+	}
+	if failed > 0 {
+		exitWithError(fmt.Sprintf("%d store(s) could not be restored", failed), errors.New("see messages above"))
+	}
+}
+
+// nonNil returns an empty slice instead of nil, so JSON shows [] not null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/backup"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateOutFile  string
+	migrateOutForce bool
+	migrateInNew    bool
+)
+
+var migrateOutCmd = &cobra.Command{
+	Use:   "migrate-out CODE",
+	Short: "Write every store to a bundle for a new machine",
+	Long: `Move your stores to a new machine without re-entering secrets.
+
+On the new machine, 'alex migrate-in' prints a one-time code: the public
+half of a key pair it just generated. Pass that code here. Every store is
+unlocked and written to a bundle that only the new machine can decrypt;
+no passphrase is shared and nothing is written in plaintext.
+
+Copy the bundle over and run 'alex migrate-in BUNDLE' there. The new
+machine keys the stores to its own machine ID and keeps every project
+store under the same project ID. Settings in config.json, such as key
+providers, stay with this machine.
+
+Examples:
+  alex migrate-out age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+  alex migrate-out age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p -o /media/usb/alex-migrate.age`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		code := strings.TrimSpace(args[0])
+		if !strings.HasPrefix(code, "age1") {
+			exitWithError("invalid code: copy it from 'alex migrate-in' on the new machine (it starts with age1)", nil)
+		}
+		key, err := secrets.RecipientKey(code)
+		if err != nil {
+			exitWithError("invalid code (check for typos)", err)
+		}
+		if !migrateOutForce {
+			if _, err := os.Stat(migrateOutFile); err == nil {
+				exitWithError(fmt.Sprintf("%s already exists (use --force to overwrite)", migrateOutFile), nil)
+			}
+		}
+
+		archive, failures, err := backup.Collect(newUnlocker(false))
+		if err != nil {
+			exitWithError("reading stores", err)
+		}
+		if len(failures) > 0 {
+			for _, f := range failures {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", f.Name, f.Err)
+			}
+			exitWithError(fmt.Sprintf("%d store(s) could not be unlocked, nothing was written", len(failures)), errors.New("see messages above"))
+		}
+		if len(archive.Stores) == 0 {
+			exitWithError("no stores to migrate", nil)
+		}
+		// Key provider settings name files on this machine
+		archive.Files = nil
+
+		sealed, err := backup.Seal(archive, key)
+		if err != nil {
+			exitWithError("encrypting bundle", err)
+		}
+		if err := os.WriteFile(migrateOutFile, sealed, 0600); err != nil {
+			exitWithError("writing bundle", err)
+		}
+
+		out := backupOutput{File: migrateOutFile, EncryptedTo: code, Stores: []backupStore{}, Files: []string{}}
+		for _, s := range archive.Stores {
+			out.Stores = append(out.Stores, backupStore{Store: s.Label(), Project: s.Project, Secrets: len(s.Secrets)})
+		}
+		if machineOutput() {
+			printResult("migrate_out", out)
+			return
+		}
+		for _, s := range out.Stores {
+			fmt.Printf("✓ %s: %d secret(s)\n", s.Store, s.Secrets)
+		}
+		fmt.Printf("\nBundle written to %s\n", migrateOutFile)
+		fmt.Printf("Copy it to the new machine and run: alex migrate-in %s\n", migrateOutFile)
+	},
+}
+
+var migrateInCmd = &cobra.Command{
+	Use:   "migrate-in [BUNDLE]",
+	Short: "Receive stores from another machine",
+	Long: `Receive your stores from another machine, in two steps.
+
+Without arguments, generate a one-time key pair, keep its private half in
+~/.alex/migrate-in.key and print the public half as a code. Run
+'alex migrate-out CODE' on the old machine with it.
+
+With the bundle migrate-out wrote, decrypt it, merge its stores into this
+machine's keyed with this machine's ID, and delete the one-time key so
+the bundle can't be opened again. --on-conflict, --relink and --dry-run
+work as for 'alex restore'.
+
+Examples:
+  alex migrate-in                       # prints the code
+  alex migrate-in alex-migrate.age --dry-run
+  alex migrate-in alex-migrate.age`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			key, err := backup.StartMigration(migrateInNew)
+			if err != nil {
+				exitWithError("creating migration key", err)
+			}
+			if machineOutput() {
+				printResult("migrate_in", migrateInCode{Code: key.Recipient})
+				return
+			}
+			fmt.Printf("Migration code: %s\n\n", key.Recipient)
+			fmt.Println("On the old machine, run:")
+			fmt.Printf("  alex migrate-out %s\n", key.Recipient)
+			fmt.Println("then copy the bundle here and run 'alex migrate-in BUNDLE'.")
+			return
+		}
+
+		opts := restoreOptions()
+		key, err := backup.PendingMigration()
+		if err != nil {
+			exitWithError("reading migration key", err)
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			exitWithError("reading bundle", err)
+		}
+		archive, err := backup.Open(data, key)
+		if errors.Is(err, secrets.ErrWrongPassphrase) {
+			exitWithError("opening bundle", errors.New("it was made for another code (run migrate-out again with the code printed here)"))
+		}
+		if err != nil {
+			exitWithError("opening bundle", err)
+		}
+		checkRestoreOptions(archive, opts)
+
+		unlocker := newUnlocker(false)
+		results, files, err := backup.Restore(archive, unlocker, opts)
+		if err != nil {
+			exitWithError("importing bundle", err)
+		}
+		if !opts.DryRun && restoreSucceeded(results) {
+			if err := backup.FinishMigration(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not delete the migration key: %v\n", err)
+			}
+		}
+		reportRestore("migrate_in", archive, results, files, unlocker, opts.DryRun)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateOutCmd, migrateInCmd)
+	migrateOutCmd.Flags().StringVarP(&migrateOutFile, "out", "o", "alex-migrate.age", "File to write the bundle to")
+	migrateOutCmd.Flags().BoolVar(&migrateOutForce, "force", false, "Overwrite an existing file")
+	migrateInCmd.Flags().BoolVar(&migrateInNew, "new", false, "Replace the code of a migration in progress")
+	addRestoreFlags(migrateInCmd)
+}
+
+// migrateInCode is the machine-readable result of migrate-in without a bundle
+type migrateInCode struct {
+	Code string `json:"code"`
+}
+
+// restoreSucceeded reports whether no store failed to restore
+func restoreSucceeded(results []backup.Result) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/runner"
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// outputVersion is bumped whenever a machine-readable structure changes
+// in a way that could break consumers
+const outputVersion = 1
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// outputFormat is set by the global --output flag
+var outputFormat = formatTable
+
+// errorCode is a stable, machine-readable error identifier
+type errorCode string
+
+const (
+	codeInvalidArgument  errorCode = "invalid_argument"
+	codeNotFound         errorCode = "not_found"
+	codeWrongPassphrase  errorCode = "wrong_passphrase"
+	codeCorruptedStore   errorCode = "corrupted_store"
+	codeMachineIDChanged errorCode = "machine_id_changed"
+	codeInstallSecret    errorCode = "install_secret_mismatch"
+	codePassphraseNeeded errorCode = "passphrase_required"
+	codePermissionDenied errorCode = "permission_denied"
+	codeNotInRepository  errorCode = "not_in_repository"
+	codeCommandNotFound  errorCode = "command_not_found"
+	codeInternal         errorCode = "internal_error"
+)
+
+// envelope wraps every machine-readable result
+type envelope struct {
+	Version int        `json:"version"`
+	Kind    string     `json:"kind"`
+	Data    any        `json:"data,omitempty"`
+	Error   *errorBody `json:"error,omitempty"`
+}
+
+type errorBody struct {
+	Code    errorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// machineOutput reports whether results should be printed as JSON or YAML
+func machineOutput() bool {
+	return outputFormat == formatJSON || outputFormat == formatYAML
+}
+
+// validateOutputFormat checks the value of --output
+func validateOutputFormat() error {
+	switch outputFormat {
+	case formatTable, formatJSON, formatYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q (must be table, json or yaml)", outputFormat)
+}
+
+// printResult writes a versioned result of the given kind to stdout
+func printResult(kind string, data any) {
+	if err := writeEnvelope(os.Stdout, envelope{Version: outputVersion, Kind: kind, Data: data}, true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: encoding %s: %v\n", outputFormat, err)
+		os.Exit(1)
+	}
+}
+
+// printResultCompact writes a result on a single line (JSON) to w.
+// Used where stdout belongs to someone else, like the run preamble.
+func printResultCompact(w io.Writer, kind string, data any) {
+	writeEnvelope(w, envelope{Version: outputVersion, Kind: kind, Data: data}, false)
+}
+
+func writeEnvelope(w io.Writer, env envelope, indent bool) error {
+	var data []byte
+	var err error
+	if outputFormat == formatYAML {
+		data, err = toYAML(env)
+	} else if indent {
+		data, err = json.MarshalIndent(env, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = json.Marshal(env)
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// exitWithCode prints an error (as an envelope in machine output mode) and exits
+func exitWithCode(code errorCode, msg string, err error) {
+	if err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	if machineOutput() {
+		writeEnvelope(os.Stdout, envelope{
+			Version: outputVersion,
+			Kind:    "error",
+			Error:   &errorBody{Code: code, Message: msg},
+		}, true)
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+	}
+	os.Exit(1)
+}
+
+// classifyError picks a stable error code for err
+func classifyError(err error) errorCode {
+	switch {
+	case err == nil:
+		return codeInvalidArgument
+	case errors.Is(err, secrets.ErrMachineIDChanged):
+		return codeMachineIDChanged
+	case errors.Is(err, secrets.ErrInstallSecretMissing), errors.Is(err, secrets.ErrInstallSecretChanged):
+		return codeInstallSecret
+	case errors.Is(err, secrets.ErrPassphraseRequired):
+		return codePassphraseNeeded
+	case errors.Is(err, secrets.ErrWrongPassphrase):
+		return codeWrongPassphrase
+	case errors.Is(err, secrets.ErrCorruptedStore):
+		return codeCorruptedStore
+	case errors.Is(err, runner.ErrCommandNotFound):
+		return codeCommandNotFound
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, secrets.ErrNoGroup):
+		return codeNotFound
+	case errors.Is(err, os.ErrPermission):
+		return codePermissionDenied
+	default:
+		return codeInternal
+	}
+}
+
+// plainYAMLString matches strings that need no quoting in YAML
+var plainYAMLString = regexp.MustCompile(`^[A-Za-z_/.][A-Za-z0-9_ ./@+()-]*[A-Za-z0-9_./@+()-]$|^[A-Za-z_]$`)
+
+// yamlReserved are plain scalars YAML would not read back as strings
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"null": true, "y": true, "n": true, "~": true, ".inf": true, ".nan": true,
+}
+
+// toYAML converts a value to YAML by way of its JSON encoding, so the
+// json struct tags define the structure for both formats and field order
+// is preserved
+func toYAML(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := readJSONNode(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	writeYAMLNode(&b, node, 0)
+	return []byte(b.String()), nil
+}
+
+// jsonField is a key/value pair of a JSON object, kept in document order
+type jsonField struct {
+	key   string
+	value any
+}
+
+func readJSONNode(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			var fields []jsonField
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, jsonField{key: keyTok.(string), value: value})
+			}
+			_, err := dec.Token() // closing }
+			return fields, err
+		}
+		items := []any{}
+		for dec.More() {
+			item, err := readJSONNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := dec.Token() // closing ]
+		return items, err
+	default:
+		return t, nil
+	}
+}
+
+func writeYAMLNode(b *strings.Builder, node any, indent int) {
+	pad := strings.Repeat("  ", indent)
+
+	switch n := node.(type) {
+	case []jsonField:
+		for _, f := range n {
+			b.WriteString(pad + yamlString(f.key) + ":")
+			writeYAMLValue(b, f.value, indent)
+		}
+	case []any:
+		for _, item := range n {
+			b.WriteString(pad + "-")
+			if fields, ok := item.([]jsonField); ok && len(fields) > 0 {
+				// First field goes on the dash line, the rest align under it
+				var nested strings.Builder
+				writeYAMLNode(&nested, fields, indent+1)
+				b.WriteString(" " + strings.TrimPrefix(nested.String(), pad+"  "))
+				continue
+			}
+			writeYAMLValue(b, item, indent)
+		}
+	}
+}
+
+// writeYAMLValue writes the value part of "key:" or "-", including the newline
+func writeYAMLValue(b *strings.Builder, value any, indent int) {
+	switch v := value.(type) {
+	case []jsonField:
+		if len(v) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLNode(b, v, indent+1)
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLNode(b, v, indent+1)
+	default:
+		b.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+func yamlScalar(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if s {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return s.String()
+	case string:
+		return yamlString(s)
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// yamlString returns s as a YAML scalar, double-quoting it when needed.
+// JSON string escapes are valid in YAML double-quoted scalars.
+func yamlString(s string) string {
+	if plainYAMLString.MatchString(s) && !yamlReserved[strings.ToLower(s)] && !looksNumeric(s) {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// looksNumeric reports whether a plain scalar could be read as a number,
+// such as ".5" or "1e3"
+func looksNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+func TestToYAML(t *testing.T) {
+	type item struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags,omitempty"`
+		Count int      `json:"count"`
+	}
+	value := struct {
+		Version int    `json:"version"`
+		Kind    string `json:"kind"`
+		Empty   []item `json:"empty"`
+		Items   []item `json:"items"`
+		Flag    bool   `json:"flag"`
+		Note    string `json:"note"`
+		Nil     *item  `json:"nil"`
+	}{
+		Version: 1,
+		Kind:    "secret_list",
+		Empty:   []item{},
+		Items: []item{
+			{Name: "API_KEY", Tags: []string{"global", "2026-01-02T03:04:05Z"}, Count: 2},
+			{Name: "yes", Count: 0},
+		},
+		Flag: true,
+		Note: "a: b # c",
+	}
+
+	got, err := toYAML(value)
+	if err != nil {
+		t.Fatalf("toYAML() error = %v", err)
+	}
+
+	want := `version: 1
+kind: secret_list
+empty: []
+items:
+  - name: API_KEY
+    tags:
+      - global
+      - "2026-01-02T03:04:05Z"
+    count: 2
+  - name: "yes"
+    count: 0
+flag: true
+note: "a: b # c"
+nil: null
+`
+	if string(got) != want {
+		t.Errorf("toYAML() =\n%s\nwant:\n%s", got, want)
+	}
+
+	// Scalars YAML reads back as numbers or null
+	for _, s := range []string{".inf", ".NaN", "~", "null", "NULL", ".5", "1e3", "Infinity"} {
+		if got := yamlString(s); got[0] != '"' {
+			t.Errorf("yamlString(%q) = %s, want it quoted", s, got)
+		}
+	}
+	if got := yamlString("global"); got != "global" {
+		t.Errorf("yamlString(global) = %s, want it plain", got)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want errorCode
+	}{
+		{nil, codeInvalidArgument},
+		{fmt.Errorf("opening store: %w", secrets.ErrWrongPassphrase), codeWrongPassphrase},
+		{fmt.Errorf("%w (invalid JSON)", secrets.ErrCorruptedStore), codeCorruptedStore},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}, codeNotFound},
+		{fmt.Errorf("%w: acme-backend", secrets.ErrNoGroup), codeNotFound},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}, codePermissionDenied},
+		{errors.New("something else"), codeInternal},
+	}
+
+	for _, tc := range tests {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
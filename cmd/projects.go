@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/portdeveloper/alex/internal/leakcheck"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	projectsPruneDays   int
+	projectsPruneDryRun bool
+	projectsPruneYes    bool
+	projectsPinForce    bool
+)
+
+var projectsCmd = &cobra.Command{
+	Use:   "projects",
+	Short: "Manage project stores",
+	Long: `Manage the project stores in ~/.alex/projects.
+
+Each project store is named by a hash of its project's identity (see
+'alex doctor'). alex records the project's remote, the path it was last
+seen at and when it was last used next to the store, so the commands
+below can tell them apart.
+
+Stores are referred to by ID, a unique prefix of one (4 characters or
+more), a name given with 'alex projects rename', or the path of a
+checkout of the project.
+
+Examples:
+  alex projects list
+  alex projects link 3f2a9c     # after moving the repo or changing its remote
+  alex projects rename 3f2a9c api
+  alex projects pin             # give the project a UUID that survives moves
+  alex projects prune --days 180 --dry-run`,
+}
+
+var projectsListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List project stores",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		stores, err := secrets.ListProjectStores()
+		if err != nil {
+			exitWithError("listing project stores", err)
+		}
+		currentID := secrets.GetProjectID()
+
+		out := projectsOutput{Projects: []projectOutput{}}
+		for _, store := range stores {
+			out.Projects = append(out.Projects, newProjectOutput(store, currentID))
+		}
+		if machineOutput() {
+			printResult("projects", out)
+			return
+		}
+		if len(out.Projects) == 0 {
+			fmt.Println("No project stores.")
+			return
+		}
+		for _, p := range out.Projects {
+			label := p.ID
+			if p.Name != "" {
+				label += " (" + p.Name + ")"
+			}
+			if p.Current {
+				label += " ← current"
+			}
+			fmt.Println(label)
+			if p.Pin != "" {
+				fmt.Printf("  Pinned:    %s\n", p.Pin)
+			}
+			if p.Remote != "" {
+				fmt.Printf("  Remote:    %s\n", p.Remote)
+			}
+			switch {
+			case p.Path == "":
+				fmt.Println("  Path:      unknown (not used since alex started recording it)")
+			case p.RepoGone:
+				fmt.Printf("  Path:      %s (gone)\n", p.Path)
+			default:
+				fmt.Printf("  Path:      %s\n", p.Path)
+			}
+			if p.Dir != "" {
+				fmt.Printf("  Subdir:    %s\n", p.Dir)
+			}
+			if !p.LastUsed.IsZero() {
+				fmt.Printf("  Last used: %s\n", formatTimeAgo(p.LastUsed))
+			}
+		}
+	},
+}
+
+var projectsLinkCmd = &cobra.Command{
+	Use:   "link STORE",
+	Short: "Attach the current directory to an existing project store",
+	Long: `Attach the current directory's project to an existing project store.
+
+When a repository moves, or its remote or .alex-project pin changes, its
+project ID changes and alex no longer finds its secrets. Link moves the
+old store to the project's new ID. It fails if the project already has a
+store with secrets.
+
+Examples:
+  alex projects link 3f2a9c
+  alex projects link api`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := findProjectStore(args[0])
+		project := secrets.ResolveProject()
+		if err := secrets.LinkProjectStore(store.ID, project); err != nil {
+			exitWithError("linking project store", err)
+		}
+		if store.ID != project.ID {
+			if _, ok := secrets.LoadConfig().Stores[store.ID]; ok {
+				fmt.Fprintf(os.Stderr, "Warning: ~/.alex/config.json has settings for store %s; rename them to %s\n", store.ID, project.ID)
+			}
+			updateLeakIndexAfterMove()
+		}
+
+		if machineOutput() {
+			printResult("projects_link", projectLinkOutput{From: store.ID, To: project.ID, Path: project.Root})
+			return
+		}
+		if store.ID == project.ID {
+			fmt.Printf("✓ Project store %s already belongs to %s\n", project.ID, project.Root)
+			return
+		}
+		fmt.Printf("✓ Linked project store %s to %s (now %s)\n", store.ID, project.Root, project.ID)
+	},
+}
+
+var projectsRenameCmd = &cobra.Command{
+	Use:   "rename STORE NAME",
+	Short: "Name a project store",
+	Long: `Give a project store a name to refer to it by instead of its ID.
+An empty NAME removes the name.
+
+Examples:
+  alex projects rename 3f2a9c api
+  alex run --project api -- npm start
+  alex projects rename api ""`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := findProjectStore(args[0])
+		if err := secrets.RenameProjectStore(store.ID, args[1]); err != nil {
+			exitWithError("renaming project store", err)
+		}
+		if machineOutput() {
+			printResult("projects_rename", projectRenameOutput{ID: store.ID, Name: args[1]})
+			return
+		}
+		if args[1] == "" {
+			fmt.Printf("✓ Removed the name of project store %s\n", store.ID)
+			return
+		}
+		fmt.Printf("✓ Project store %s is now named %s\n", store.ID, args[1])
+	},
+}
+
+var projectsPinCmd = &cobra.Command{
+	Use:   "pin [NAME]",
+	Short: "Give the project a stable identity",
+	Long: `Write an .alex-project file to the project root, naming the project
+NAME or a new random UUID. The project is then identified by that name
+wherever it is checked out, even without a git remote, so commit the
+file or copy it along when moving the project. The project keeps its
+store.
+
+Examples:
+  alex projects pin
+  alex projects pin github.com/acme/api
+  alex projects pin --force acme-api   # replace an existing pin`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		project := secrets.ResolveProject()
+		path := filepath.Join(project.Root, ".alex-project")
+		if _, err := os.Stat(path); err == nil && !projectsPinForce {
+			exitWithCode(codeInvalidArgument, fmt.Sprintf("%s already exists (use --force to replace it)", path), nil)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			exitWithError("checking "+path, err)
+		}
+
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		} else {
+			var err error
+			if name, err = newProjectUUID(); err != nil {
+				exitWithError("generating project UUID", err)
+			}
+		}
+		content := "# Project identity for alex: stores secrets under this name\n" + name + "\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			exitWithError("writing "+path, err)
+		}
+
+		// Resolving again moves the store to the pinned ID
+		pinned := secrets.ResolveProject()
+		if pinned.ID != project.ID {
+			updateLeakIndexAfterMove()
+		}
+		if machineOutput() {
+			printResult("projects_pin", projectPinOutput{Pin: pinned.Pin, Path: path, From: project.ID, To: pinned.ID})
+			return
+		}
+		fmt.Printf("✓ Pinned project to %s (now %s)\n", pinned.Pin, pinned.ID)
+		fmt.Printf("  Wrote %s - commit it so every checkout shares the store\n", path)
+	},
+}
+
+var projectsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete project stores that are no longer used",
+	Long: `Delete project stores whose repository is gone from the path it was
+last seen at, or that were not used for --days days (0 to only delete
+stores whose repository is gone). The current project's store is kept.
+
+Pruned secrets cannot be recovered except from a backup.
+
+Examples:
+  alex projects prune --dry-run
+  alex projects prune --days 180
+  alex projects prune --days 0 --yes`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if projectsPruneDays < 0 {
+			exitWithCode(codeInvalidArgument, "--days cannot be negative", nil)
+		}
+		stores, err := secrets.ListProjectStores()
+		if err != nil {
+			exitWithError("listing project stores", err)
+		}
+		currentID := secrets.GetProjectID()
+		cutoff := time.Now().AddDate(0, 0, -projectsPruneDays)
+
+		out := projectsPruneOutput{DryRun: projectsPruneDryRun, Pruned: []prunedProject{}}
+		for _, store := range stores {
+			if store.ID == currentID {
+				continue
+			}
+			reason := ""
+			if store.RepoGone() {
+				reason = "repository gone from " + store.Record.Root
+			} else if last := store.LastUsed(); projectsPruneDays > 0 && !last.IsZero() && last.Before(cutoff) {
+				reason = "last used " + formatTimeAgo(last)
+			}
+			if reason != "" {
+				out.Pruned = append(out.Pruned, prunedProject{ID: store.ID, Name: store.Name(), Reason: reason})
+			}
+		}
+
+		if len(out.Pruned) == 0 {
+			if machineOutput() {
+				printResult("projects_prune", out)
+			} else {
+				fmt.Println("No project stores to prune.")
+			}
+			return
+		}
+		if !machineOutput() {
+			for _, p := range out.Pruned {
+				label := p.ID
+				if p.Name != "" {
+					label += " (" + p.Name + ")"
+				}
+				fmt.Printf("- %s: %s\n", label, p.Reason)
+			}
+		}
+		if projectsPruneDryRun {
+			if machineOutput() {
+				printResult("projects_prune", out)
+			} else {
+				fmt.Println("\nDry run: nothing was deleted.")
+			}
+			return
+		}
+		if !projectsPruneYes && !confirmAction(fmt.Sprintf("Delete %d project store(s) and their secrets?", len(out.Pruned))) {
+			fmt.Println("Cancelled.")
+			os.Exit(1)
+		}
+
+		for _, p := range out.Pruned {
+			if err := secrets.RemoveProjectStore(p.ID); err != nil {
+				exitWithError(fmt.Sprintf("deleting project store %s", p.ID), err)
+			}
+		}
+		updateLeakIndexAfterMove()
+		if machineOutput() {
+			printResult("projects_prune", out)
+			return
+		}
+		fmt.Printf("✓ Deleted %d project store(s)\n", len(out.Pruned))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(projectsCmd)
+	projectsCmd.AddCommand(projectsListCmd, projectsLinkCmd, projectsRenameCmd, projectsPinCmd, projectsPruneCmd)
+	projectsPinCmd.Flags().BoolVar(&projectsPinForce, "force", false, "Replace an existing .alex-project file")
+	projectsPruneCmd.Flags().IntVar(&projectsPruneDays, "days", 90, "Delete stores not used for this many days (0 to disable)")
+	projectsPruneCmd.Flags().BoolVar(&projectsPruneDryRun, "dry-run", false, "List the stores that would be deleted")
+	projectsPruneCmd.Flags().BoolVarP(&projectsPruneYes, "yes", "y", false, "Delete without asking")
+}
+
+// findProjectStore returns the project store ref names, exiting if there
+// is none
+func findProjectStore(ref string) secrets.ProjectStore {
+	store, err := secrets.FindProjectStore(ref)
+	if err != nil {
+		exitWithError("finding project store", err)
+	}
+	return store
+}
+
+// newProjectUUID returns a random (version 4) UUID to pin a project to
+func newProjectUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// updateLeakIndexAfterMove rebuilds the pre-commit hash index, if there
+// is one, after project stores changed ID or were deleted, or the global
+// store was rekeyed
+func updateLeakIndexAfterMove() {
+	alexDir, err := secrets.GetGlobalDir()
+	if err != nil || !leakcheck.Exists(alexDir) {
+		return
+	}
+	if _, err := rebuildLeakIndex(newUnlocker(false)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update pre-commit hash index: %v\n", err)
+	}
+}
+
+// projectsOutput is the machine-readable result of projects list
+type projectsOutput struct {
+	Projects []projectOutput `json:"projects"`
+}
+
+type projectOutput struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	Pin      string    `json:"pin,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Dir      string    `json:"dir,omitempty"`
+	RepoGone bool      `json:"repo_gone"`
+	LastUsed time.Time `json:"last_used"`
+	Current  bool      `json:"current"`
+}
+
+func newProjectOutput(store secrets.ProjectStore, currentID string) projectOutput {
+	out := projectOutput{
+		ID:       store.ID,
+		Name:     store.Name(),
+		RepoGone: store.RepoGone(),
+		LastUsed: store.LastUsed(),
+		Current:  store.ID == currentID,
+	}
+	if store.Record != nil {
+		out.Remote, out.Pin, out.Path, out.Dir = store.Record.Remote, store.Record.Pin, store.Record.Root, store.Record.Dir
+	}
+	return out
+}
+
+type projectLinkOutput struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Path string `json:"path"`
+}
+
+type projectPinOutput struct {
+	Pin  string `json:"pin"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type projectRenameOutput struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type projectsPruneOutput struct {
+	DryRun bool            `json:"dry_run"`
+	Pruned []prunedProject `json:"pruned"`
+}
+
+type prunedProject struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/recovery"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shareFormatWords = "words"
+	shareFormatArmor = "armor"
+)
+
+var (
+	recoveryShares    int
+	recoveryThreshold int
+	recoveryFormat    string
+	recoveryOutDir    string
+	recoveryReplace   bool
+)
+
+var recoveryCmd = &cobra.Command{
+	Use:   "recovery",
+	Short: "Split a recovery key into shares for disaster recovery",
+	Long: `Protect your stores against losing this machine.
+
+'alex recovery create' generates a recovery key, adds it to every store
+as the "recovery" key slot and splits it into Shamir shares. Hand the
+shares to teammates or keep them in separate safes: any threshold of them
+rebuild the key, fewer reveal nothing about it. The key itself is never
+written to disk.
+
+'alex recovery restore' rebuilds the key from the shares on a new machine,
+after ~/.alex has been copied over, and rekeys every store to that
+machine's ID.
+
+Examples:
+  alex recovery create --shares 5 --threshold 3
+  alex recovery create --shares 3 --threshold 2 --format armor --out-dir /media/usb
+  alex recovery restore share-1.txt share-4.txt share-5.txt
+  alex recovery restore          # paste shares, separated by blank lines`,
+}
+
+var recoveryCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a recovery key and print its shares",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if recoveryFormat != shareFormatWords && recoveryFormat != shareFormatArmor {
+			exitWithError(fmt.Sprintf("invalid --format %q (must be words or armor)", recoveryFormat), nil)
+		}
+		if recoveryThreshold < 2 || recoveryThreshold > recoveryShares || recoveryShares > 255 {
+			exitWithError("need 2 <= --threshold <= --shares <= 255", nil)
+		}
+
+		stores, err := allStores()
+		if err != nil {
+			exitWithError("listing stores", err)
+		}
+		if len(stores) == 0 {
+			exitWithError("no stores to protect yet", nil)
+		}
+
+		// Every store has to take the key, so unlock them all first
+		unlocker := newUnlocker(false)
+		var opened []openedStore
+		for _, ref := range stores {
+			if !recoveryReplace && hasSlot(ref.Dir, secrets.RecoverySlot) {
+				exitWithError(fmt.Sprintf("%s store already has a recovery key (use --replace to make a new one)", ref.Label), nil)
+			}
+			store, err := unlocker.Open(ref.Dir)
+			if err != nil {
+				exitWithError(fmt.Sprintf("opening %s store", ref.Label), err)
+			}
+			opened = append(opened, openedStore{ref: ref, store: store})
+		}
+
+		key, secret, err := secrets.NewRecoveryKey()
+		if err != nil {
+			exitWithError("generating recovery key", err)
+		}
+		shares, err := recovery.Split(secret, recoveryShares, recoveryThreshold)
+		if err != nil {
+			exitWithError("splitting recovery key", err)
+		}
+
+		for _, o := range opened {
+			if hasSlot(o.ref.Dir, secrets.RecoverySlot) {
+				if err := secrets.RemoveSlot(o.ref.Dir, secrets.RecoverySlot); err != nil {
+					exitWithError(fmt.Sprintf("removing old recovery key of %s store", o.ref.Label), err)
+				}
+			}
+			if err := o.store.AddSlot(secrets.RecoverySlot, key); err != nil {
+				exitWithError(fmt.Sprintf("adding recovery key to %s store", o.ref.Label), err)
+			}
+			fmt.Fprintf(os.Stderr, "✓ %s: recovery key added\n", o.ref.Label)
+		}
+		fmt.Fprintln(os.Stderr)
+
+		for _, share := range shares {
+			text := formatShare(share)
+			if recoveryOutDir == "" {
+				fmt.Println(text)
+				continue
+			}
+			path := filepath.Join(recoveryOutDir, fmt.Sprintf("alex-recovery-share-%d.txt", share.Index))
+			if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+				exitWithError("writing share", err)
+			}
+			fmt.Printf("Share %d written to %s\n", share.Index, path)
+		}
+
+		fmt.Fprintf(os.Stderr, "Any %d of these %d shares restore your stores with 'alex recovery restore'.\n", recoveryThreshold, recoveryShares)
+		fmt.Fprintln(os.Stderr, "Keep them apart: whoever holds enough of them can decrypt your secrets.")
+	},
+}
+
+var recoveryRestoreCmd = &cobra.Command{
+	Use:   "restore [SHARE_FILE...]",
+	Short: "Rebuild the recovery key from shares and rekey stores to this machine",
+	Run: func(cmd *cobra.Command, args []string) {
+		var shares []recovery.Share
+		if len(args) > 0 {
+			for _, path := range args {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					exitWithError("reading share", err)
+				}
+				parsed, err := recovery.ParseShares(string(data))
+				if err != nil {
+					exitWithError(fmt.Sprintf("reading share from %s", path), err)
+				}
+				shares = append(shares, parsed...)
+			}
+		} else {
+			var err error
+			if shares, err = readSharesInteractively(os.Stdin); err != nil {
+				exitWithError("reading shares", err)
+			}
+		}
+
+		secret, err := recovery.Combine(shares)
+		if err != nil {
+			exitWithError("combining shares", err)
+		}
+		key, err := secrets.ParseRecoveryKey(secret)
+		if err != nil {
+			exitWithError("rebuilding recovery key", err)
+		}
+
+		stores, err := allStores()
+		if err != nil {
+			exitWithError("listing stores", err)
+		}
+		if len(stores) == 0 {
+			exitWithError("no stores found - copy your ~/.alex directory to this machine first", nil)
+		}
+
+		unlocker := &secrets.Unlocker{Recovery: key, NoCommands: true}
+		var newKey *secrets.Key
+		failed := 0
+		for _, ref := range stores {
+			store, err := unlocker.Open(ref.Dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", ref.Label, err)
+				failed++
+				continue
+			}
+			if newKey == nil {
+				if newKey, err = unlocker.TargetKey(secrets.RekeyTarget{Mode: secrets.KeyModeMachine}); err != nil {
+					exitWithError("deriving this machine's key", err)
+				}
+			}
+			if err := secrets.Rekey(store, newKey); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", ref.Label, err)
+				failed++
+				continue
+			}
+			fmt.Printf("✓ %s: restored and keyed to this machine\n", ref.Label)
+		}
+		if failed > 0 {
+			exitWithError(fmt.Sprintf("%d store(s) could not be restored", failed), errors.New("see messages above"))
+		}
+		fmt.Println("\nThe recovery key still unlocks every store; the shares stay valid.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recoveryCmd)
+	recoveryCmd.AddCommand(recoveryCreateCmd, recoveryRestoreCmd)
+	recoveryCreateCmd.Flags().IntVar(&recoveryShares, "shares", 5, "Number of shares to create")
+	recoveryCreateCmd.Flags().IntVar(&recoveryThreshold, "threshold", 3, "Number of shares needed to restore")
+	recoveryCreateCmd.Flags().StringVar(&recoveryFormat, "format", shareFormatWords, "Share format: words or armor")
+	recoveryCreateCmd.Flags().StringVar(&recoveryOutDir, "out-dir", "", "Write each share to a file in this directory instead of printing")
+	recoveryCreateCmd.Flags().BoolVar(&recoveryReplace, "replace", false, "Replace an existing recovery key")
+	recoveryCreateCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{shareFormatWords, shareFormatArmor}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// formatShare prints a share with a comment saying what it is
+func formatShare(share recovery.Share) string {
+	header := fmt.Sprintf("# alex recovery share %d of %d (any %d restore the stores), set %08x\n",
+		share.Index, recoveryShares, share.Threshold, share.SetID)
+	if recoveryFormat == shareFormatArmor {
+		return header + share.Armor() + "\n"
+	}
+	return header + share.Words() + "\n"
+}
+
+// readSharesInteractively reads pasted shares, separated by blank lines,
+// until enough have been entered or input ends
+func readSharesInteractively(r io.Reader) ([]recovery.Share, error) {
+	fmt.Fprintln(os.Stderr, "Paste your shares, each followed by a blank line:")
+
+	var shares []recovery.Share
+	seen := make(map[int]bool)
+	var block []string
+	scanner := bufio.NewScanner(r)
+	for {
+		more := scanner.Scan()
+		line := strings.TrimSpace(scanner.Text())
+		if more && line != "" {
+			block = append(block, line)
+			continue
+		}
+
+		if len(block) > 0 {
+			parsed, err := recovery.ParseShares(strings.Join(block, "\n"))
+			block = nil
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %v - paste that share again\n", err)
+			}
+			for _, share := range parsed {
+				if !seen[share.Index] {
+					seen[share.Index] = true
+					shares = append(shares, share)
+				}
+				fmt.Fprintf(os.Stderr, "✓ share %d (%d of %d needed)\n", share.Index, len(shares), share.Threshold)
+			}
+			if len(shares) > 0 && len(shares) >= shares[0].Threshold {
+				return shares, nil
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// hasSlot reports whether the store in dir has a key slot with name
+func hasSlot(dir, name string) bool {
+	slots, _ := secrets.ListSlots(dir)
+	for _, slot := range slots {
+		if slot.Name == name {
+			return true
+		}
+	}
+	return false
+}
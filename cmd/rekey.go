@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rekeyTo               string
+	rekeyKeyFile          string
+	rekeyOldMachineIDFile string
+	rekeyNewPassphraseFD  int
+)
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey --to MODE",
+	Short: "Re-encrypt every store under a new key",
+	Long: `Unlock every global and project store with its current key and move
+them all to a new one: switch from the machine ID to a passphrase, change
+a passphrase, or move to an age identity, SSH key or age plugin.
+
+Each store is re-encrypted under a new data key, so the old key opens
+neither it nor copies made after the rekey. While a store is changed its
+file and key header are kept next to it as secrets.enc.rekey.bak and
+key.json.rekey.bak, and removed once it is done. Identity, SSH and
+recovery slots added with 'alex keys add' are kept; remove passphrase,
+machine and plugin slots before a rekey and add them again after.
+
+MODE is one of machine, passphrase, identity, ssh or plugin. --key-file
+names the identity file, SSH key or plugin identity, and defaults to the
+one set in ~/.alex/config.json.
+
+If /etc/machine-id was regenerated, machine-keyed stores report
+machine_id_changed. Pass a copy of the old machine ID with
+--old-machine-id-file to unlock them and move them to the new one.
+
+Examples:
+  alex rekey --to passphrase                 # machine ID → passphrase, or a new passphrase
+  alex rekey --to machine                    # passphrase → machine ID
+  alex rekey --to ssh --key-file ~/.ssh/id_ed25519
+  alex rekey --to machine --old-machine-id-file /backup/machine-id`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		target := secrets.RekeyTarget{Mode: secrets.KeyMode(rekeyTo), KeyFile: rekeyKeyFile}
+		switch target.Mode {
+		case secrets.KeyModeMachine, secrets.KeyModePassphrase, secrets.KeyModeIdentity, secrets.KeyModeSSH, secrets.KeyModePlugin:
+		default:
+			exitWithError(fmt.Sprintf("invalid --to %q (must be machine, passphrase, identity, ssh or plugin)", rekeyTo), nil)
+		}
+		if rekeyNewPassphraseFD >= 0 && rekeyNewPassphraseFD == passphraseFD {
+			exitWithError("--passphrase-fd and --new-passphrase-fd must be different descriptors", nil)
+		}
+
+		unlocker := newUnlocker(false)
+		if rekeyOldMachineIDFile != "" {
+			machine, err := secrets.MachineIDFromFile(rekeyOldMachineIDFile)
+			if err != nil {
+				exitWithError("reading old machine ID", err)
+			}
+			unlocker.MachineID = machine
+		}
+
+		stores, err := allStores()
+		if err != nil {
+			exitWithError("listing stores", err)
+		}
+		if len(stores) == 0 {
+			exitWithError("no stores to rekey", nil)
+		}
+
+		// Unlock everything with the old keys before asking for the new one
+		out := rekeyOutput{To: target.Mode, Stores: []rekeyStatus{}}
+		var opened []*secrets.Store
+		unlocked := 0
+		for _, ref := range stores {
+			status := rekeyStatus{Store: ref.Label, Dir: ref.Dir}
+			if info, _ := secrets.ReadKeyInfo(ref.Dir); info != nil {
+				status.From = info.Mode
+			}
+			store, err := unlocker.Open(ref.Dir)
+			if err != nil {
+				status.Error = err.Error()
+				if errors.Is(err, secrets.ErrMachineIDChanged) && rekeyOldMachineIDFile == "" {
+					status.Error += " (pass the old ID with --old-machine-id-file)"
+				}
+			} else {
+				status.From = store.KeyMode()
+				unlocked++
+			}
+			out.Stores = append(out.Stores, status)
+			opened = append(opened, store)
+		}
+
+		var key *secrets.Key
+		if unlocked > 0 {
+			if rekeyNewPassphraseFD >= 0 {
+				target.Passphrase = secrets.PassphraseFD(rekeyNewPassphraseFD)
+			} else {
+				target.Passphrase = secrets.PromptPassphrase(readConfirmedPassphrase)
+			}
+			if key, err = unlocker.TargetKey(target); err != nil {
+				exitWithError("getting the new key", err)
+			}
+		}
+
+		failed := 0
+		for i, store := range opened {
+			status := &out.Stores[i]
+			if store == nil {
+				status.Status = "failed"
+				failed++
+				continue
+			}
+			if err := secrets.Rekey(store, key); err != nil {
+				status.Status, status.Error = "failed", err.Error()
+				failed++
+				continue
+			}
+			status.Status = "rekeyed"
+			status.Backups = nonNil(secrets.RekeyBackups(store.Dir()))
+		}
+
+		// The hash index is keyed from the global store's data key
+		if failed < len(opened) {
+			updateLeakIndexAfterMove()
+		}
+
+		if machineOutput() {
+			printResult("rekey", out)
+		} else {
+			for _, s := range out.Stores {
+				if s.Status == "failed" {
+					fmt.Fprintf(os.Stderr, "✗ %s: %s\n", s.Store, s.Error)
+					continue
+				}
+				fmt.Printf("✓ %s: %s → %s\n", s.Store, modeOrUnknown(s.From), out.To)
+				if len(s.Backups) > 0 {
+					fmt.Fprintf(os.Stderr, "Warning: could not remove %s; it still opens with the old key, delete it\n", strings.Join(s.Backups, ", "))
+				}
+			}
+		}
+		if failed > 0 {
+			exitWithError(fmt.Sprintf("%d store(s) could not be rekeyed", failed), errors.New("see messages above"))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+	rekeyCmd.Flags().StringVar(&rekeyTo, "to", "", "New key mode: machine, passphrase, identity, ssh or plugin")
+	rekeyCmd.Flags().StringVar(&rekeyKeyFile, "key-file", "", "Identity file, SSH key or plugin identity of the new key")
+	rekeyCmd.Flags().StringVar(&rekeyOldMachineIDFile, "old-machine-id-file", "", "File with the machine ID the stores were keyed with")
+	rekeyCmd.Flags().IntVar(&rekeyNewPassphraseFD, "new-passphrase-fd", -1, "Read the new passphrase from this file descriptor")
+	rekeyCmd.MarkFlagRequired("to")
+	rekeyCmd.RegisterFlagCompletionFunc("to", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"machine", "passphrase", "identity", "ssh", "plugin"}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// rekeyOutput is the machine-readable result of rekey
+type rekeyOutput struct {
+	To     secrets.KeyMode `json:"to"`
+	Stores []rekeyStatus   `json:"stores"`
+}
+
+type rekeyStatus struct {
+	Store  string          `json:"store"`
+	Dir    string          `json:"dir"`
+	From   secrets.KeyMode `json:"from"`
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	// Backups are rekey backups that could not be removed
+	Backups []string `json:"backups,omitempty"`
+}
+
+// readConfirmedPassphrase asks for a new passphrase twice
+func readConfirmedPassphrase(prompt string) (string, error) {
+	passphrase, err := readHiddenInput(prompt)
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readHiddenInput("Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", errors.New("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func modeOrUnknown(mode secrets.KeyMode) secrets.KeyMode {
+	if mode == "" {
+		return "unknown"
+	}
+	return mode
+}
@@ -4,16 +4,18 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/portdeveloper/alex/internal/runner"
-	"github.com/portdeveloper/alex/internal/secrets"
 	"github.com/spf13/cobra"
 )
 
 var (
 	runPassphrase bool
 	runForce      bool
+	runProject    string
+	runExplainEnv bool
 )
 
 var runCmd = &cobra.Command{
@@ -24,8 +26,16 @@ var runCmd = &cobra.Command{
 The secrets are injected into the command's environment only - they are not
 visible in your shell's environment.
 
-Merges secrets from both global (~/.alex/) and project scopes.
-Project is auto-detected from git root. Project secrets override global.
+Merges secrets from the global (~/.alex/) scope, the groups listed in the
+project's manifest (see 'alex group') and the project scope. Project is
+auto-detected from git root. Project secrets override groups, and groups
+override global.
+--project runs with another project's secrets instead: its ID, name or
+the path of a checkout (see 'alex projects list'). Its groups and parent
+project apply as in its checkout, and with a path so do the stores of the
+directories down to it. If the checkout the store last recorded is gone,
+only global and the project's own secrets are used. --explain-env first
+prints the report of 'alex list --effective' to stderr.
 
 Use -- to separate alex flags from command arguments.
 
@@ -33,12 +43,14 @@ Examples:
   alex run npm start
   alex run pytest
   alex run -- docker-compose up -d
-  alex run --force env   # Skip confirmation for suspicious commands`,
+  alex run --force env   # Skip confirmation for suspicious commands
+  alex run --project api -- npm start
+  alex run --explain-env -- npm start`,
 	Args:               cobra.MinimumNArgs(1),
 	DisableFlagParsing: false,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
-			exitWithError("no command specified", nil)
+			exitWithCode(codeInvalidArgument, "no command specified", nil)
 		}
 
 		// Check for suspicious commands
@@ -55,51 +67,59 @@ Examples:
 			}
 		}
 
-		passphrase, err := getPassphrase(runPassphrase)
-		if err != nil {
-			exitWithError("getting passphrase", err)
-		}
+		unlocker := newUnlocker(runPassphrase)
 
-		// Load global secrets
-		globalStore, err := secrets.NewGlobalStore(passphrase)
-		if err != nil {
-			exitWithError("opening global secret store", err)
-		}
-		globalSecrets := globalStore.GetAll()
-
-		// Load project secrets if they exist
-		var projectSecrets map[string]string
-		projectExists, projectErr := secrets.ProjectStoreExists()
-		if projectErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", projectErr)
-		} else if projectExists {
-			projectStore, err := secrets.NewProjectStore(passphrase)
-			if err != nil {
-				exitWithError("opening project secret store", err)
-			}
-			projectSecrets = projectStore.GetAll()
+		var layers []storeLayer
+		if runProject != "" {
+			layers = openNamedProjectLayers(unlocker, runProject)
+		} else {
+			layers = openLayers(unlocker)
 		}
 
-		// Merge secrets (project overrides global)
+		// Merge secrets: groups override global, project overrides
+		// groups, and the store of a directory overrides those of the
+		// directories above it
+		values := layerValuesOf(layers)
 		secretMap := make(map[string]string)
-		for k, v := range globalSecrets {
-			secretMap[k] = v
+		for _, layer := range values {
+			for k, v := range layer.values {
+				secretMap[k] = v
+			}
 		}
-		for k, v := range projectSecrets {
-			secretMap[k] = v
+
+		if runExplainEnv {
+			explanation := buildEffective(values, fingerprintKey())
+			if machineOutput() {
+				printResultCompact(os.Stderr, "secret_effective", explanation)
+			} else {
+				printEffective(os.Stderr, explanation)
+				fmt.Fprintln(os.Stderr)
+			}
 		}
 
-		if len(secretMap) == 0 {
+		preamble := buildRunPreamble(args, values)
+		if machineOutput() {
+			printResultCompact(os.Stderr, "run_preamble", preamble)
+		} else if len(secretMap) == 0 {
 			fmt.Fprintln(os.Stderr, "Note: No secrets stored. Running command without injected secrets.")
 		} else {
 			// Print summary
-			globalCount := len(globalSecrets)
-			projectCount := len(projectSecrets)
-			if projectCount > 0 {
-				fmt.Fprintf(os.Stderr, "Using %d global, %d project secret(s)\n", globalCount, projectCount)
-			} else {
-				fmt.Fprintf(os.Stderr, "Using %d global secret(s)\n", globalCount)
+			fmt.Fprintf(os.Stderr, "Using %d global", preamble.GlobalCount)
+			if preamble.GroupCount > 0 {
+				fmt.Fprintf(os.Stderr, ", %d group", preamble.GroupCount)
+			}
+			if preamble.GroupCount > 0 || preamble.ProjectCount > 0 {
+				fmt.Fprintf(os.Stderr, ", %d project", preamble.ProjectCount)
+			}
+			fmt.Fprint(os.Stderr, " secret(s)")
+			if (preamble.GroupCount > 0 || preamble.ProjectCount > 0) && len(values) > 2 {
+				scopes := make([]string, 0, len(values)-1)
+				for _, layer := range values[1:] {
+					scopes = append(scopes, layer.scope)
+				}
+				fmt.Fprintf(os.Stderr, " from %s", strings.Join(scopes, ", "))
 			}
+			fmt.Fprintln(os.Stderr)
 		}
 
 		// Run replaces the current process, so this won't return on success
@@ -111,13 +131,81 @@ Examples:
 
 func init() {
 	rootCmd.AddCommand(runCmd)
-	runCmd.Flags().BoolVar(&runPassphrase, "passphrase", false, "Use a passphrase instead of machine ID")
+	runCmd.Flags().BoolVar(&runPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
 	runCmd.Flags().BoolVarP(&runForce, "force", "f", false, "Skip confirmation for suspicious commands")
+	runCmd.Flags().BoolVar(&runExplainEnv, "explain-env", false, "Print where each injected secret comes from and what it shadows before running")
+	runCmd.Flags().StringVar(&runProject, "project", "", "Use this project store (ID, name or path) instead of the current project's")
+}
+
+// runPreamble is the machine-readable summary printed (to stderr) before
+// 'alex run' starts the command. Values are never included.
+type runPreamble struct {
+	Command     string `json:"command"`
+	GlobalCount int    `json:"global_count"`
+	// GroupCount and ProjectCount are the secrets a group or the
+	// project supplies
+	GroupCount   int         `json:"group_count,omitempty"`
+	ProjectCount int         `json:"project_count"`
+	Secrets      []runSecret `json:"secrets"`
+}
+
+// runSecret is an injected secret name and the scope that supplies it
+type runSecret struct {
+	Name    string   `json:"name"`
+	Scope   string   `json:"scope"`
+	Shadows []string `json:"shadows,omitempty"`
+}
+
+// layerValues are the secrets of one layer with their values, see
+// openLayers
+type layerValues struct {
+	scope  string
+	values map[string]string
+}
+
+// buildRunPreamble describes what each secret is injected from. layers
+// go from the least to the most specific, starting with global, then
+// groups.
+func buildRunPreamble(args []string, layers []layerValues) runPreamble {
+	preamble := runPreamble{
+		Command:     args[0],
+		GlobalCount: len(layers[0].values),
+		Secrets:     []runSecret{},
+	}
+
+	scopes := make(map[string][]string)
+	for _, layer := range layers {
+		for k := range layer.values {
+			// Most specific first
+			scopes[k] = append([]string{layer.scope}, scopes[k]...)
+		}
+	}
+	names := make([]string, 0, len(scopes))
+	for name, found := range scopes {
+		names = append(names, name)
+		switch {
+		case found[0] == "global":
+		case strings.HasPrefix(found[0], "group:"):
+			preamble.GroupCount++
+		default:
+			preamble.ProjectCount++
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		secret := runSecret{Name: name, Scope: scopes[name][0]}
+		if len(scopes[name]) > 1 {
+			secret.Shadows = scopes[name][1:]
+		}
+		preamble.Secrets = append(preamble.Secrets, secret)
+	}
+	return preamble
 }
 
 // confirmAction prompts the user for yes/no confirmation
 func confirmAction(prompt string) bool {
-	fmt.Printf("%s [y/N] ", prompt)
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
 
 	reader := bufio.NewReader(os.Stdin)
 	response, err := reader.ReadString('\n')
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/leakcheck"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanPassphrase bool
+	scanLocal      bool
+	scanScrub      bool
+	scanPaths      []string
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan --local",
+	Short: "Find stored secrets leaked into shell histories and agent logs",
+	Long: `Search local files for leaked secret values.
+
+With --local, alex scans shell and REPL history files (~/.zsh_history,
+~/.bash_history, fish, ...) and AI agent transcripts and logs (~/.claude,
+~/.codex, ~/.gemini, aider history in the project, ...) for:
+  - any value stored in the global or a project store
+  - literal 'alex set KEY VALUE' commands typed into a shell
+
+Found leaks can be scrubbed in place: history lines are removed, and values
+in transcripts are replaced with a redaction marker. Every exposed key is
+listed so you know what to rotate.
+
+Examples:
+  alex scan --local
+  alex scan --local --path ~/notes    # Also scan extra files or directories
+  alex scan --local --scrub           # Scrub without asking`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !scanLocal {
+			exitWithError("nothing to scan - use --local to scan shell histories and agent transcripts", nil)
+		}
+
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			exitWithError("getting home directory", err)
+		}
+
+		unlocker := newUnlocker(scanPassphrase)
+
+		index, err := buildLeakIndex(unlocker)
+		if err != nil {
+			exitWithError("building hash index", err)
+		}
+
+		sources := leakcheck.LocalSources(homeDir, secrets.GetProjectRoot())
+		for _, path := range scanPaths {
+			sources = append(sources, extraScanSources(path)...)
+		}
+
+		fmt.Fprintf(os.Stderr, "Scanning %d file(s) for %d stored secret(s)...\n\n", len(sources), index.Len())
+
+		hitsByFile := make(map[string][]leakcheck.Hit)
+		var files []string
+		for _, src := range sources {
+			hits, err := index.ScanFile(src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", src.Path, err)
+				continue
+			}
+			if len(hits) > 0 {
+				hitsByFile[src.Path] = hits
+				files = append(files, src.Path)
+			}
+		}
+
+		if len(files) == 0 {
+			fmt.Println("✓ No leaked secrets found")
+			return
+		}
+
+		currentProject := secrets.GetProjectID()
+		exposed := make(map[string]string)
+		total := 0
+		for _, path := range files {
+			hits := hitsByFile[path]
+			fmt.Printf("%s (%s)\n", shortenHome(path, homeDir), hits[0].Kind)
+
+			// A typed 'alex set' of a stored value matches twice; show it once
+			stored := make(map[string]bool)
+			for _, h := range hits {
+				if !h.Literal {
+					stored[fmt.Sprintf("%d:%s", h.Line, h.Key)] = true
+				}
+			}
+
+			for _, h := range hits {
+				if h.Literal && stored[fmt.Sprintf("%d:%s", h.Line, h.Key)] {
+					continue
+				}
+				total++
+				scope := "typed with 'alex set'"
+				if !h.Literal {
+					scope = leakScopeLabel(h.Scope, currentProject)
+					exposed[h.Key] = scope
+				} else if _, ok := exposed[h.Key]; !ok {
+					exposed[h.Key] = scope
+				}
+				fmt.Printf("  line %-8d %s (%s)\n", h.Line, h.Key, scope)
+			}
+			fmt.Println()
+		}
+
+		fmt.Printf("Found %d leak(s) in %d file(s).\n\n", total, len(files))
+
+		keys := make([]string, 0, len(exposed))
+		for k := range exposed {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Println("Rotate these keys (their values were exposed in plaintext):")
+		for _, k := range keys {
+			fmt.Printf("  %-28s %s\n", k, exposed[k])
+		}
+		fmt.Println()
+
+		if !scanScrub {
+			fmt.Fprintln(os.Stderr, "Scrubbing removes history lines and redacts values in transcripts.")
+			if !confirmAction(fmt.Sprintf("Scrub %d file(s) in place?", len(files))) {
+				fmt.Println("Not scrubbed.")
+				return
+			}
+		}
+
+		failed := false
+		for _, path := range files {
+			if err := leakcheck.Scrub(path, hitsByFile[path]); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", shortenHome(path, homeDir), err)
+				failed = true
+				continue
+			}
+			fmt.Printf("✓ Scrubbed %s\n", shortenHome(path, homeDir))
+		}
+		if failed {
+			os.Exit(1)
+		}
+		fmt.Println("\nNote: running shells keep their history in memory and may write it back on exit.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+	scanCmd.Flags().BoolVar(&scanPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
+	scanCmd.Flags().BoolVar(&scanLocal, "local", false, "Scan shell histories and AI agent transcripts on this machine")
+	scanCmd.Flags().BoolVar(&scanScrub, "scrub", false, "Scrub found leaks without asking")
+	scanCmd.Flags().StringArrayVar(&scanPaths, "path", nil, "Additional file or directory to scan (repeatable)")
+}
+
+// extraScanSources expands a user-supplied path into transcript sources
+func extraScanSources(path string) []leakcheck.Source {
+	var sources []leakcheck.Source
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", p, err)
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			sources = append(sources, leakcheck.Source{Path: p, Kind: leakcheck.KindTranscript})
+		}
+		return nil
+	})
+	return sources
+}
+
+// shortenHome replaces the home directory prefix of a path with ~
+func shortenHome(path, homeDir string) string {
+	if homeDir != "" && strings.HasPrefix(path, homeDir+string(filepath.Separator)) {
+		return "~" + strings.TrimPrefix(path, homeDir)
+	}
+	return path
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// --dir and --here choose the project store a command works on: the
+// store of a subdirectory instead of the project's own. --group chooses
+// the store of a shared group instead.
+var (
+	scopeDir   string
+	scopeHere  bool
+	scopeGroup string
+)
+
+func addScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&scopeDir, "dir", "", "Use the store of this subdirectory of the project root")
+	cmd.Flags().BoolVar(&scopeHere, "here", false, "Use the store of the current directory within the project")
+	cmd.Flags().StringVar(&scopeGroup, "group", "", "Use the store of this group (see 'alex group')")
+	cmd.RegisterFlagCompletionFunc("group", completeGroupNames)
+}
+
+// scopeFlagsSet reports whether --dir or --here was given
+func scopeFlagsSet() bool {
+	return scopeDir != "" || scopeHere
+}
+
+// scopedProject returns the project store chosen by --dir and --here and
+// its scope label, exiting if the flags are invalid
+func scopedProject() (secrets.ProjectInfo, string) {
+	if scopeDir != "" && scopeHere {
+		exitWithCode(codeInvalidArgument, "--dir and --here cannot be combined", nil)
+	}
+	project := secrets.ResolveProject()
+	dir := project.Dir
+	if !scopeHere {
+		clean, err := secrets.CleanScopeDir(scopeDir)
+		if err != nil {
+			exitWithCode(codeInvalidArgument, "invalid --dir", err)
+		}
+		dir = clean
+	}
+	return project.Scope(dir), scopeLabel("project", dir)
+}
+
+// openScopedStore opens the store set, unset and import work on: the
+// global store with --global, a group's with --group, or else the project
+// store chosen by --dir and --here. Also returns its scope label.
+func openScopedStore(unlocker *secrets.Unlocker, global bool) (*secrets.Store, string) {
+	if global && scopeFlagsSet() {
+		exitWithCode(codeInvalidArgument, "--global cannot be combined with --dir or --here", nil)
+	}
+	if scopeGroup != "" && (global || scopeFlagsSet()) {
+		exitWithCode(codeInvalidArgument, "--group cannot be combined with --global, --dir or --here", nil)
+	}
+
+	var store *secrets.Store
+	var err error
+	var scope string
+	switch {
+	case global:
+		store, err = unlocker.OpenGlobal()
+		scope = "global"
+	case scopeGroup != "":
+		store, err = unlocker.OpenGroup(scopeGroup)
+		scope = scopeLabel("group", scopeGroup)
+	default:
+		var project secrets.ProjectInfo
+		project, scope = scopedProject()
+		store, err = unlocker.OpenProjectScope(project)
+	}
+	if err != nil {
+		exitWithError("opening secret store", err)
+	}
+	return store, scope
+}
+
+// scopeLabel names a layer of secrets, see secrets.ScopeLabel
+func scopeLabel(kind, dir string) string {
+	return secrets.ScopeLabel(kind, dir)
+}
+
+// storeLayer is a store whose secrets apply in the current directory
+type storeLayer struct {
+	scope string
+	store *secrets.Store
+}
+
+// openLayers opens the global store and every store that applies in the
+// current directory, see openProjectLayers
+func openLayers(unlocker *secrets.Unlocker) []storeLayer {
+	return openProjectLayers(unlocker, secrets.ResolveProject())
+}
+
+// openNamedProjectLayers opens the layers of the project store ref names
+// (see 'alex projects list') as they apply in the checkout or directory
+// ref is the path of, or else at the root of the checkout the store last
+// recorded. If that checkout is gone, only the global store and the
+// project's own apply.
+func openNamedProjectLayers(unlocker *secrets.Unlocker, ref string) []storeLayer {
+	projectStore := findProjectStore(ref)
+	project, ok := projectStore.Project()
+	if info, err := os.Stat(ref); err == nil && info.IsDir() {
+		if here, err := secrets.ResolveProjectAt(ref); err == nil && here.ID == projectStore.ID {
+			project, ok = here, true
+		}
+	}
+	if ok {
+		return openProjectLayers(unlocker, project)
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: no checkout of project %s found; using only global and its own secrets, without groups\n", projectStore.ID)
+	globalStore, err := unlocker.OpenGlobal()
+	if err != nil {
+		exitWithError("opening global secret store", err)
+	}
+	store, err := unlocker.Open(projectStore.Dir)
+	if err != nil {
+		exitWithError("opening project secret store", err)
+	}
+	return []storeLayer{{scope: "global", store: globalStore}, {scope: "project", store: store}}
+}
+
+// openProjectLayers opens the global store and every store that applies
+// in the directory Dir of project, see secrets.Unlocker.OpenLayers
+func openProjectLayers(unlocker *secrets.Unlocker, project secrets.Project) []storeLayer {
+	opened, warnings, err := unlocker.OpenLayers(project)
+	printWarnings(warnings)
+	if err != nil {
+		exitWithError("opening secret stores", err)
+	}
+	layers := make([]storeLayer, 0, len(opened))
+	for _, layer := range opened {
+		layers = append(layers, storeLayer{scope: layer.Scope, store: layer.Store})
+	}
+	return layers
+}
+
+// printWarnings writes each of warnings to stderr
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+}
+
+// scopeHeader is the heading of a layer in human-readable output, e.g.
+// "PROJECT services/billing"
+func scopeHeader(scope string) string {
+	kind, dir, _ := strings.Cut(scope, ":")
+	if dir == "" {
+		return strings.ToUpper(kind)
+	}
+	return fmt.Sprintf("%s %s", strings.ToUpper(kind), dir)
+}
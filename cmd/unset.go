@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/portdeveloper/alex/internal/secrets"
 	"github.com/spf13/cobra"
 )
 
@@ -20,38 +19,27 @@ var unsetCmd = &cobra.Command{
 	Long: `Remove a stored secret.
 
 Removes from project scope by default.
-Use --global to remove from global scope.
+Use --global to remove from global scope, --group to remove from a
+group, --dir or --here to remove from the store of a subdirectory of the
+project.
 
 Examples:
   alex unset DATABASE_URL
   alex unset --global OPENAI_KEY  # Remove from global scope
+  alex unset --group acme-backend REGISTRY_TOKEN
+  alex unset --dir services/billing DATABASE_URL
   alex unset -f DATABASE_URL      # Skip confirmation`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		key := args[0]
 
-		passphrase, err := getPassphrase(unsetPassphrase)
-		if err != nil {
-			exitWithError("getting passphrase", err)
-		}
-
-		var store *secrets.Store
-		var scope string
+		unlocker := newUnlocker(unsetPassphrase)
 
-		if unsetGlobal {
-			store, err = secrets.NewGlobalStore(passphrase)
-			scope = "global"
-		} else {
-			store, err = secrets.NewProjectStore(passphrase)
-			scope = "project"
-		}
-		if err != nil {
-			exitWithError("opening secret store", err)
-		}
+		store, scope := openScopedStore(unlocker, unsetGlobal)
 
 		// Verify secret exists before prompting
-		if _, exists := store.Get(key); !exists {
-			exitWithError(fmt.Sprintf("secret '%s' not found in %s scope", key, scope), nil)
+		if _, exists := store.List()[key]; !exists {
+			exitWithCode(codeNotFound, fmt.Sprintf("secret '%s' not found in %s scope", key, scope), nil)
 		}
 
 		// Confirm deletion unless --force is used
@@ -66,6 +54,7 @@ Examples:
 		if err := store.Delete(key); err != nil {
 			exitWithError(fmt.Sprintf("removing secret '%s'", key), err)
 		}
+		updateLeakIndex(unlocker, store, key, "")
 
 		fmt.Printf("✓ Secret '%s' removed (%s)\n", key, scope)
 	},
@@ -73,7 +62,8 @@ Examples:
 
 func init() {
 	rootCmd.AddCommand(unsetCmd)
-	unsetCmd.Flags().BoolVar(&unsetPassphrase, "passphrase", false, "Use a passphrase instead of machine ID")
+	unsetCmd.Flags().BoolVar(&unsetPassphrase, "passphrase", false, "Key new stores with a passphrase instead of machine ID")
 	unsetCmd.Flags().BoolVarP(&unsetGlobal, "global", "g", false, "Remove from global scope (~/.alex/) instead of project")
+	addScopeFlags(unsetCmd)
 	unsetCmd.Flags().BoolVarP(&unsetForce, "force", "f", false, "Skip confirmation prompt")
 }
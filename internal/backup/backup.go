@@ -0,0 +1,236 @@
+// Package backup collects every alex store and its settings into a single
+// archive and merges such an archive back into ~/.alex.
+//
+// Archives hold decrypted secrets, gzipped JSON, encrypted with age to a
+// passphrase or recipient of the user's choosing. They never depend on
+// the machine ID, so they restore on any machine.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+const (
+	archiveFormat = "alex-backup/1"
+
+	// GlobalStore is the name of the global store in an archive
+	GlobalStore = "global"
+	// groupPrefix starts the names of group stores in an archive
+	groupPrefix = "group:"
+)
+
+// settingsFiles are the files in ~/.alex that are backed up next to the
+// stores. Key headers, install secrets and the leak check index are tied
+// to the machine or derived from the stores, so they are left out.
+var settingsFiles = []string{"config.json"}
+
+// projectIDPattern matches project store names
+var projectIDPattern = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// Archive is the decrypted content of a backup
+type Archive struct {
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	Host      string    `json:"host,omitempty"`
+	Stores    []Store   `json:"stores"`
+	// Files holds settings files from ~/.alex by name
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// Store is one store in an archive
+type Store struct {
+	// Name is GlobalStore, "group:" and the group name, or the project ID
+	Name string `json:"name"`
+	// Project is the project a project store belongs to, if it was known
+	Project *secrets.ProjectInfo      `json:"project,omitempty"`
+	Secrets map[string]secrets.Secret `json:"secrets"`
+}
+
+// Label names the store for messages
+func (s Store) Label() string {
+	if s.Name == GlobalStore {
+		return "global"
+	}
+	if group, ok := s.Group(); ok {
+		return "group " + group
+	}
+	if s.Project != nil && s.Project.Identifier() != "" {
+		return fmt.Sprintf("project %s (%s)", s.Name, s.Project.Identifier())
+	}
+	return "project " + s.Name
+}
+
+// Group returns the name of the group a group store belongs to
+func (s Store) Group() (string, bool) {
+	return strings.CutPrefix(s.Name, groupPrefix)
+}
+
+// Failure is a store that could not be read into an archive
+type Failure struct {
+	Name string
+	Err  error
+}
+
+// Collect unlocks every store with unlocker and reads it into an
+// archive, along with the settings files. Stores that cannot be unlocked
+// are returned as failures and left out.
+func Collect(unlocker *secrets.Unlocker) (*Archive, []Failure, error) {
+	globalDir, err := secrets.GetGlobalDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	projectsDir, err := secrets.GetProjectsDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	projectIDs, err := secrets.ListProjectIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive := &Archive{Format: archiveFormat, CreatedAt: time.Now().UTC(), Files: make(map[string][]byte)}
+	archive.Host, _ = os.Hostname()
+
+	var failures []Failure
+	add := func(name, dir string, project *secrets.ProjectInfo) {
+		store, err := unlocker.Open(dir)
+		if err == nil {
+			var all map[string]secrets.Secret
+			if all, err = store.Export(); err == nil {
+				archive.Stores = append(archive.Stores, Store{Name: name, Project: project, Secrets: all})
+				return
+			}
+		}
+		failures = append(failures, Failure{Name: name, Err: err})
+	}
+
+	if secrets.GlobalStoreExists() {
+		add(GlobalStore, globalDir, nil)
+	}
+	current := secrets.CurrentProject()
+	for _, id := range projectIDs {
+		dir := filepath.Join(projectsDir, id)
+		project, err := secrets.ReadProjectInfo(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if project == nil && id == current.ID() {
+			project = &current
+		}
+		add(id, dir, project)
+	}
+
+	groups, err := secrets.ListGroups()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range groups {
+		dir, err := secrets.GroupDir(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secrets.StoreExists(dir) {
+			add(groupPrefix+name, dir, nil)
+		}
+	}
+
+	for _, name := range settingsFiles {
+		data, err := os.ReadFile(filepath.Join(globalDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		archive.Files[name] = data
+	}
+	return archive, failures, nil
+}
+
+// Seal encodes the archive and encrypts it to key
+func Seal(archive *Archive, key *secrets.Key) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return key.Encrypt(buf.Bytes())
+}
+
+// Open decrypts an archive with key and validates it
+func Open(data []byte, key *secrets.Key) (*Archive, error) {
+	plain, err := key.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("not an alex backup: %w", err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("damaged backup: %w", err)
+	}
+
+	var archive Archive
+	if err := json.Unmarshal(decoded, &archive); err != nil {
+		return nil, fmt.Errorf("damaged backup: %w", err)
+	}
+	if err := archive.validate(); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// validate checks an archive before anything is restored from it
+func (a *Archive) validate() error {
+	if a.Format != archiveFormat {
+		return fmt.Errorf("unsupported backup format %q (want %s)", a.Format, archiveFormat)
+	}
+	seen := make(map[string]bool)
+	for _, s := range a.Stores {
+		group, isGroup := s.Group()
+		if s.Name != GlobalStore && !projectIDPattern.MatchString(s.Name) &&
+			(!isGroup || secrets.ValidateGroupName(group) != nil) {
+			return fmt.Errorf("invalid store name %q in backup", s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("store %s appears twice in backup", s.Name)
+		}
+		seen[s.Name] = true
+		for name := range s.Secrets {
+			if name == "" {
+				return fmt.Errorf("secret without a name in %s store", s.Label())
+			}
+		}
+	}
+	for name := range a.Files {
+		if !isSettingsFile(name) {
+			return fmt.Errorf("unexpected file %q in backup", name)
+		}
+	}
+	return nil
+}
+
+func isSettingsFile(name string) bool {
+	for _, f := range settingsFiles {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// isolateHome points ~/.alex and the install secret at a fresh directory
+func isolateHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ALEX_INSTALL_SECRET_FILE", filepath.Join(home, "state", "install-secret"))
+	return home
+}
+
+func setSecrets(t *testing.T, store *secrets.Store, values map[string]string) {
+	t.Helper()
+	for k, v := range values {
+		if err := store.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectAndRestoreOnNewMachine(t *testing.T) {
+	home := isolateHome(t)
+	unlocker := &secrets.Unlocker{}
+
+	global, err := unlocker.OpenGlobal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSecrets(t, global, map[string]string{"SHARED": "global-value"})
+	project, err := unlocker.OpenProjectByID("0123456789ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSecrets(t, project, map[string]string{"DB_URL": "postgres://db"})
+	info := secrets.ProjectInfo{Remote: "git@github.com:acme/api.git", Root: "/src/api"}
+	if err := secrets.WriteProjectInfo(project.Dir(), info); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.CreateGroup("acme-backend"); err != nil {
+		t.Fatal(err)
+	}
+	group, err := unlocker.OpenGroup("acme-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSecrets(t, group, map[string]string{"REGISTRY_TOKEN": "token"})
+	if err := os.WriteFile(filepath.Join(home, ".alex", "config.json"), []byte(`{"use_passphrase":false}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, failures, err := Collect(unlocker)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("Collect() failures = %v, error = %v", failures, err)
+	}
+	key, err := secrets.PassphraseKey("backup passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(archive, key)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	// A new machine: empty home and a different install secret
+	isolateHome(t)
+	opened, err := Open(sealed, key)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	results, files, err := Restore(opened, &secrets.Unlocker{}, Options{})
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Status != StatusCreated {
+			t.Errorf("%s: status = %s (%v), want created", r.Store.Label(), r.Status, r.Err)
+		}
+	}
+	if len(files) != 1 || files[0].Status != StatusCreated {
+		t.Errorf("files = %+v, want config.json created", files)
+	}
+
+	restored, err := (&secrets.Unlocker{}).OpenProjectByID("0123456789ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := restored.Get("DB_URL"); value != "postgres://db" {
+		t.Errorf("DB_URL = %q, want postgres://db", value)
+	}
+	if recorded, _ := secrets.ReadProjectInfo(restored.Dir()); recorded == nil || *recorded != info {
+		t.Errorf("project = %+v, want %+v", recorded, info)
+	}
+	if restored.KeyMode() != secrets.KeyModeMachine {
+		t.Errorf("KeyMode() = %q, want machine", restored.KeyMode())
+	}
+
+	restoredGroup, err := (&secrets.Unlocker{}).OpenGroup("acme-backend")
+	if err != nil {
+		t.Fatalf("OpenGroup() after restore error = %v", err)
+	}
+	if value, _, _ := restoredGroup.Get("REGISTRY_TOKEN"); value != "token" {
+		t.Errorf("REGISTRY_TOKEN = %q, want token", value)
+	}
+}
+
+func TestOpenRejectsWrongKey(t *testing.T) {
+	archive := &Archive{Format: archiveFormat, CreatedAt: time.Now()}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := secrets.RecipientKey(identity.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(archive, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := secrets.PassphraseKey("not it")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(sealed, other); !errors.Is(err, secrets.ErrWrongPassphrase) {
+		t.Errorf("Open() with the wrong key error = %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := Open(sealed, key); err == nil {
+		t.Error("Open() with a recipient-only key succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive Archive
+		wantErr bool
+	}{
+		{"valid", Archive{Format: archiveFormat, Stores: []Store{{Name: GlobalStore}, {Name: "0123456789ab"}}, Files: map[string][]byte{"config.json": nil}}, false},
+		{"wrong format", Archive{Format: "alex-backup/9"}, true},
+		{"path in store name", Archive{Format: archiveFormat, Stores: []Store{{Name: "../../etc"}}}, true},
+		{"group store", Archive{Format: archiveFormat, Stores: []Store{{Name: "group:acme-backend"}}}, false},
+		{"path in group name", Archive{Format: archiveFormat, Stores: []Store{{Name: "group:../projects"}}}, true},
+		{"duplicate store", Archive{Format: archiveFormat, Stores: []Store{{Name: GlobalStore}, {Name: GlobalStore}}}, true},
+		{"unknown file", Archive{Format: archiveFormat, Files: map[string][]byte{"../.bashrc": nil}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.archive.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	isolateHome(t)
+	unlocker := &secrets.Unlocker{}
+	global, err := unlocker.OpenGlobal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSecrets(t, global, map[string]string{"SAME": "1", "LOCAL": "mine", "CHANGED": "local"})
+
+	archive := &Archive{Format: archiveFormat, Stores: []Store{{
+		Name: GlobalStore,
+		Secrets: map[string]secrets.Secret{
+			"SAME":    {Value: "1"},
+			"CHANGED": {Value: "archived"},
+			"NEW":     {Value: "new"},
+		},
+	}}}
+
+	tests := []struct {
+		name         string
+		opts         Options
+		wantStatus   string
+		wantAdded    []string
+		wantReplaced []string
+		wantKept     []string
+	}{
+		{"dry run", Options{Conflict: Overwrite, DryRun: true}, StatusMerged, []string{"NEW"}, []string{"CHANGED"}, nil},
+		{"skip", Options{Stores: map[string]Conflict{GlobalStore: Skip}}, StatusSkipped, nil, nil, nil},
+		{"keep", Options{}, StatusMerged, []string{"NEW"}, nil, []string{"CHANGED"}},
+		{"overwrite", Options{Conflict: Overwrite}, StatusMerged, nil, []string{"CHANGED"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, _, err := Restore(archive, unlocker, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := results[0]
+			if r.Status != tt.wantStatus || r.Err != nil {
+				t.Errorf("status = %s (%v), want %s", r.Status, r.Err, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(r.Added, tt.wantAdded) || !reflect.DeepEqual(r.Replaced, tt.wantReplaced) || !reflect.DeepEqual(r.Kept, tt.wantKept) {
+				t.Errorf("added %v replaced %v kept %v, want %v %v %v", r.Added, r.Replaced, r.Kept, tt.wantAdded, tt.wantReplaced, tt.wantKept)
+			}
+		})
+	}
+
+	reopened, err := (&secrets.Unlocker{}).OpenGlobal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"SAME": "1", "LOCAL": "mine", "CHANGED": "archived", "NEW": "new"}
+	if got, err := reopened.GetAll(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestRestoreRelink(t *testing.T) {
+	isolateHome(t)
+	moved := secrets.ProjectInfo{Root: "/new/checkout"}
+	archive := &Archive{Format: archiveFormat, Stores: []Store{{
+		Name:    "0123456789ab",
+		Project: &secrets.ProjectInfo{Root: "/old/checkout"},
+		Secrets: map[string]secrets.Secret{"TOKEN": {Value: "t"}},
+	}}}
+
+	results, _, err := Restore(archive, &secrets.Unlocker{}, Options{Relink: map[string]secrets.ProjectInfo{"0123456789ab": moved}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Name != moved.ID() || results[0].Status != StatusCreated {
+		t.Fatalf("result = %s %s (%v), want %s created", results[0].Name, results[0].Status, results[0].Err, moved.ID())
+	}
+	if recorded, _ := secrets.ReadProjectInfo(results[0].Dir); recorded == nil || *recorded != moved {
+		t.Errorf("project = %+v, want %+v", recorded, moved)
+	}
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/age"
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// migrationKeyFile holds the one-time key of a machine migration in
+// ~/.alex on the target machine until the bundle is imported
+const migrationKeyFile = "migrate-in.key"
+
+// ErrNoMigration indicates migrate-in was not started on this machine
+var ErrNoMigration = errors.New("no migration in progress: run 'alex migrate-in' first")
+
+// migrationKeyPath returns where the pending migration key is kept
+func migrationKeyPath() (string, error) {
+	dir, err := secrets.GetGlobalDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, migrationKeyFile), nil
+}
+
+// StartMigration generates the one-time key pair a migration bundle is
+// encrypted to and keeps the private half in ~/.alex. The returned key's
+// Recipient is the code to give to migrate-out. If a migration is already
+// in progress its key is returned, unless replace is set.
+func StartMigration(replace bool) (*secrets.Key, error) {
+	path, err := migrationKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if !replace {
+		if key, err := PendingMigration(); !errors.Is(err, ErrNoMigration) {
+			return key, err
+		}
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("# alex migrate-in key, created %s\n# public key: %s\n%s\n",
+		time.Now().UTC().Format(time.RFC3339), identity.Recipient(), identity)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return nil, err
+	}
+	return PendingMigration()
+}
+
+// PendingMigration returns the key of the migration in progress, or
+// ErrNoMigration
+func PendingMigration() (*secrets.Key, error) {
+	path, err := migrationKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoMigration
+	}
+	return secrets.IdentityFile(path).Key("migration key")
+}
+
+// FinishMigration deletes the one-time key, so the bundle can't be
+// decrypted again
+func FinishMigration() error {
+	path, err := migrationKeyPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
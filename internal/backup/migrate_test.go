@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+func TestMigrationKeyIsOneTime(t *testing.T) {
+	isolateHome(t)
+
+	if _, err := PendingMigration(); !errors.Is(err, ErrNoMigration) {
+		t.Fatalf("PendingMigration() before start error = %v, want ErrNoMigration", err)
+	}
+	key, err := StartMigration(false)
+	if err != nil {
+		t.Fatalf("StartMigration() error = %v", err)
+	}
+	again, err := StartMigration(false)
+	if err != nil || again.Recipient != key.Recipient {
+		t.Errorf("StartMigration() again = %v, %v, want the pending code %s", again, err, key.Recipient)
+	}
+
+	// migrate-out only has the code
+	code, err := secrets.RecipientKey(key.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := &Archive{Format: archiveFormat, CreatedAt: time.Now(), Stores: []Store{{
+		Name:    "0123456789ab",
+		Secrets: map[string]secrets.Secret{"TOKEN": {Value: "t"}},
+	}}}
+	sealed, err := Seal(archive, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := PendingMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := Open(sealed, pending)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if opened.Stores[0].Secrets["TOKEN"].Value != "t" {
+		t.Errorf("TOKEN = %q, want t", opened.Stores[0].Secrets["TOKEN"].Value)
+	}
+
+	if err := FinishMigration(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PendingMigration(); !errors.Is(err, ErrNoMigration) {
+		t.Errorf("PendingMigration() after finish error = %v, want ErrNoMigration", err)
+	}
+	replaced, err := StartMigration(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(sealed, replaced); !errors.Is(err, secrets.ErrWrongPassphrase) {
+		t.Errorf("Open() with a new migration key error = %v, want ErrWrongPassphrase", err)
+	}
+}
@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// Conflict says what happens to a store that exists both in the archive
+// and on this machine
+type Conflict string
+
+const (
+	// Keep merges the stores; secrets in both keep the local value
+	Keep Conflict = "keep"
+	// Overwrite merges the stores; secrets in both get the archive's value
+	Overwrite Conflict = "overwrite"
+	// Skip leaves the local store alone
+	Skip Conflict = "skip"
+)
+
+// ParseConflict checks a conflict policy given by the user
+func ParseConflict(s string) (Conflict, error) {
+	switch c := Conflict(s); c {
+	case Keep, Overwrite, Skip:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (must be keep, overwrite or skip)", s)
+}
+
+// Options controls Restore
+type Options struct {
+	// Conflict applies to every store not listed in Stores; empty means Keep
+	Conflict Conflict
+	// Stores overrides Conflict by archived store name
+	Stores map[string]Conflict
+	// Relink restores project stores to other projects, keyed by archived
+	// project ID, e.g. when a checkout without a remote moved
+	Relink map[string]secrets.ProjectInfo
+	// DryRun reports what would change without writing anything
+	DryRun bool
+}
+
+func (o Options) conflict(name string) Conflict {
+	if c, ok := o.Stores[name]; ok {
+		return c
+	}
+	if o.Conflict == "" {
+		return Keep
+	}
+	return o.Conflict
+}
+
+// Store statuses reported by Restore
+const (
+	StatusCreated   = "created"
+	StatusMerged    = "merged"
+	StatusUnchanged = "unchanged"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+// Result is what Restore did, or would do, with one archived store
+type Result struct {
+	Store Store
+	// Name is the store it was restored to: GlobalStore, a group store
+	// or a project ID
+	Name string
+	Dir  string
+	// Status is one of the Status constants
+	Status string
+	// Added are secrets that were missing locally
+	Added []string
+	// Replaced are local secrets that got the archive's value
+	Replaced []string
+	// Kept are local secrets that differ from the archive and were kept
+	Kept []string
+	Err  error
+}
+
+// FileResult is what Restore did, or would do, with a settings file
+type FileResult struct {
+	Name string
+	// Status is StatusCreated, StatusUnchanged, "replaced" or "kept"
+	Status string
+}
+
+// Restore merges the archive into the stores on this machine. Settings
+// files are restored first so new stores are keyed the way they say.
+// New stores are keyed like any store alex creates, so they don't depend
+// on the machine the archive came from. A store that fails is reported in
+// its Result and doesn't stop the others.
+func Restore(archive *Archive, unlocker *secrets.Unlocker, opts Options) ([]Result, []FileResult, error) {
+	globalDir, err := secrets.GetGlobalDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	projectsDir, err := secrets.GetProjectsDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := restoreFiles(archive, globalDir, opts)
+	if err != nil {
+		return nil, files, err
+	}
+
+	var results []Result
+	for _, s := range archive.Stores {
+		r := Result{Store: s, Name: s.Name, Dir: globalDir}
+		project := s.Project
+		if relinked, ok := opts.Relink[s.Name]; ok {
+			if s.Project != nil {
+				// The store of a subdirectory stays with the subdirectory
+				relinked.Dir = s.Project.Dir
+			}
+			project = &relinked
+			r.Name = relinked.ID()
+		}
+		if group, ok := s.Group(); ok {
+			if r.Dir, err = secrets.GroupDir(group); err != nil {
+				return results, files, err
+			}
+		} else if r.Name != GlobalStore {
+			r.Dir = filepath.Join(projectsDir, r.Name)
+		}
+		restoreStore(&r, project, unlocker, opts)
+		results = append(results, r)
+	}
+	return results, files, nil
+}
+
+// restoreStore merges one archived store into r.Dir
+func restoreStore(r *Result, project *secrets.ProjectInfo, unlocker *secrets.Unlocker, opts Options) {
+	conflict := opts.conflict(r.Store.Name)
+	exists := secrets.StoreExists(r.Dir)
+	if exists && conflict == Skip {
+		r.Status = StatusSkipped
+		return
+	}
+
+	changes := make(map[string]secrets.Secret)
+	var store *secrets.Store
+	if exists {
+		var err error
+		if store, err = unlocker.Open(r.Dir); err != nil {
+			r.Status, r.Err = StatusFailed, err
+			return
+		}
+		local, err := store.Export()
+		if err != nil {
+			r.Status, r.Err = StatusFailed, err
+			return
+		}
+		for name, secret := range r.Store.Secrets {
+			existing, ok := local[name]
+			switch {
+			case !ok:
+				r.Added = append(r.Added, name)
+				changes[name] = secret
+			case existing.Value == secret.Value:
+			case conflict == Overwrite:
+				r.Replaced = append(r.Replaced, name)
+				changes[name] = secret
+			default:
+				r.Kept = append(r.Kept, name)
+			}
+		}
+	} else {
+		for name, secret := range r.Store.Secrets {
+			r.Added = append(r.Added, name)
+			changes[name] = secret
+		}
+	}
+	sort.Strings(r.Added)
+	sort.Strings(r.Replaced)
+	sort.Strings(r.Kept)
+
+	switch {
+	case len(changes) == 0:
+		r.Status = StatusUnchanged
+	case !exists:
+		r.Status = StatusCreated
+	default:
+		r.Status = StatusMerged
+	}
+	if opts.DryRun || len(changes) == 0 {
+		return
+	}
+
+	if store == nil {
+		var err error
+		if store, err = unlocker.Open(r.Dir); err != nil {
+			r.Status, r.Err = StatusFailed, err
+			return
+		}
+	}
+	if err := store.Import(changes); err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return
+	}
+	if project != nil && r.Name != GlobalStore {
+		if recorded, _ := secrets.ReadProjectInfo(r.Dir); recorded == nil || r.Name != r.Store.Name {
+			if err := secrets.WriteProjectInfo(r.Dir, *project); err != nil {
+				r.Status, r.Err = StatusFailed, fmt.Errorf("recording project: %w", err)
+			}
+		}
+	}
+}
+
+// restoreFiles writes the archive's settings files that are missing
+// locally, and replaces differing ones only with Overwrite
+func restoreFiles(archive *Archive, globalDir string, opts Options) ([]FileResult, error) {
+	names := make([]string, 0, len(archive.Files))
+	for name := range archive.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []FileResult
+	for _, name := range names {
+		data := archive.Files[name]
+		path := filepath.Join(globalDir, name)
+		local, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			results = append(results, FileResult{Name: name, Status: StatusCreated})
+		case err != nil:
+			return results, err
+		case bytes.Equal(local, data):
+			results = append(results, FileResult{Name: name, Status: StatusUnchanged})
+			continue
+		case opts.Conflict == Overwrite:
+			results = append(results, FileResult{Name: name, Status: "replaced"})
+		default:
+			results = append(results, FileResult{Name: name, Status: "kept"})
+			continue
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := os.MkdirAll(globalDir, 0700); err != nil {
+			return results, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package discover
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialNames are file names that usually hold plaintext credentials
+var credentialNames = map[string]bool{
+	"credentials.json": true,
+	"credentials":      true,
+	".netrc":           true,
+	".pypirc":          true,
+	"id_rsa":           true,
+	"id_ecdsa":         true,
+	"id_ed25519":       true,
+	"master.key":       true,
+}
+
+// credentialPrefixes match generated key files like service-account-1234.json
+var credentialPrefixes = []string{"service-account", "client_secret"}
+
+// credentialExtensions are private key and keystore formats
+var credentialExtensions = map[string]bool{
+	".pem": true,
+	".key": true,
+	".p12": true,
+	".pfx": true,
+	".jks": true,
+}
+
+// CredentialFiles returns files under root that look like plaintext
+// credentials (private keys, cloud service account keys, token files).
+// Paths are relative to root.
+func CredentialFiles(root string) []string {
+	var files []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.IsDir() {
+			if path != root && (skipDirs[d.Name()] || strings.Count(rel, string(filepath.Separator)) >= maxDepth-1) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isCredentialFile(path, d.Name()) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+func isCredentialFile(path, name string) bool {
+	lower := strings.ToLower(name)
+	if credentialNames[lower] || credentialExtensions[filepath.Ext(lower)] {
+		return true
+	}
+	if filepath.Ext(lower) == ".json" {
+		for _, prefix := range credentialPrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				return true
+			}
+		}
+	}
+	// .npmrc is only a problem when it carries an auth token
+	if lower == ".npmrc" {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Contains(string(data), "_authToken=") && !strings.Contains(string(data), "_authToken=${")
+	}
+	return false
+}
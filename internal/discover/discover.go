@@ -0,0 +1,305 @@
+package discover
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxDepth limits how far below the project root .env files are searched
+const maxDepth = 3
+
+// skipDirs are never searched for env files
+var skipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"vendor":       true,
+	".venv":        true,
+	"venv":         true,
+	"__pycache__":  true,
+	"dist":         true,
+	"build":        true,
+	".next":        true,
+	"target":       true,
+}
+
+// templateSuffixes mark committed example files that hold no real values
+var templateSuffixes = []string{".example", ".sample", ".template", ".dist", ".defaults"}
+
+// composeFiles are the docker compose file names, in lookup order
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}
+
+// EnvFile is a file holding secrets that could be imported
+type EnvFile struct {
+	Path   string // relative to the project root
+	Reason string // why it was found
+}
+
+// Framework is a detected framework and its secret file conventions
+type Framework struct {
+	Name string
+	// Gitignore lists patterns the framework's secret files need
+	Gitignore []string
+	// Note is an extra hint shown to the user
+	Note string
+}
+
+// Result holds everything found in a project
+type Result struct {
+	EnvFiles   []EnvFile
+	Templates  []string // .env.example and friends, relative paths
+	Frameworks []Framework
+}
+
+// GitignorePatterns returns every pattern that should be in .gitignore
+func (r *Result) GitignorePatterns() []string {
+	patterns := []string{".env", ".env.*", "!.env.example"}
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		seen[p] = true
+	}
+	for _, fw := range r.Frameworks {
+		for _, p := range fw.Gitignore {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	return patterns
+}
+
+// Project inspects a project root for env files, compose env_file entries
+// and framework conventions
+func Project(root string) *Result {
+	result := &Result{}
+	found := make(map[string]bool)
+
+	addFile := func(rel, reason string) {
+		rel = filepath.Clean(rel)
+		if found[rel] || !inRoot(root, rel) {
+			return
+		}
+		info, err := os.Stat(filepath.Join(root, rel))
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		found[rel] = true
+		result.EnvFiles = append(result.EnvFiles, EnvFile{Path: rel, Reason: reason})
+	}
+
+	// docker compose env_file entries
+	for _, name := range composeFiles {
+		for _, envFile := range composeEnvFiles(filepath.Join(root, name)) {
+			addFile(envFile, "env_file in "+name)
+		}
+	}
+
+	// .env* files anywhere near the root
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.IsDir() {
+			if path != root && (skipDirs[d.Name()] || strings.Count(rel, string(filepath.Separator)) >= maxDepth-1) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if name != ".env" && !strings.HasPrefix(name, ".env.") {
+			return nil
+		}
+		if isTemplate(name) {
+			result.Templates = append(result.Templates, rel)
+			return nil
+		}
+		addFile(rel, "env file")
+		return nil
+	})
+
+	result.Frameworks = detectFrameworks(root)
+
+	sort.Slice(result.EnvFiles, func(i, j int) bool { return result.EnvFiles[i].Path < result.EnvFiles[j].Path })
+	sort.Strings(result.Templates)
+	return result
+}
+
+// inRoot reports whether rel names a file under root, also once symlinks
+// are followed, so a committed compose file can't point at files outside
+// the project
+func inRoot(root, rel string) bool {
+	if filepath.IsAbs(rel) {
+		return false
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, rel))
+	if err != nil {
+		return false
+	}
+	inside, err := filepath.Rel(realRoot, path)
+	return err == nil && inside != ".." && !strings.HasPrefix(inside, ".."+string(filepath.Separator))
+}
+
+func isTemplate(name string) bool {
+	for _, suffix := range templateSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// composeEnvFiles returns the env_file entries of a compose file.
+// Handles the short form (env_file: .env), lists (- .env) and the long
+// form (- path: .env). Paths are relative to the compose file's directory,
+// which is the project root for the files we look at.
+func composeEnvFiles(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var files []string
+	inEnvFile := false
+	envFileIndent := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		if strings.HasPrefix(trimmed, "env_file:") {
+			value := strings.TrimSpace(strings.TrimPrefix(trimmed, "env_file:"))
+			switch {
+			case value == "":
+				inEnvFile = true
+				envFileIndent = indent
+			case strings.HasPrefix(value, "["):
+				for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+					files = appendYAMLValue(files, item)
+				}
+			default:
+				files = appendYAMLValue(files, value)
+			}
+			continue
+		}
+
+		if !inEnvFile {
+			continue
+		}
+		if indent <= envFileIndent && !strings.HasPrefix(trimmed, "-") {
+			inEnvFile = false
+			continue
+		}
+		if strings.HasPrefix(trimmed, "-") {
+			item := strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			item = strings.TrimPrefix(item, "path:")
+			files = appendYAMLValue(files, item)
+		} else if strings.HasPrefix(trimmed, "path:") {
+			files = appendYAMLValue(files, strings.TrimPrefix(trimmed, "path:"))
+		}
+	}
+	return files
+}
+
+// appendYAMLValue appends an unquoted scalar, ignoring comments and empty values
+func appendYAMLValue(values []string, raw string) []string {
+	if idx := strings.Index(raw, " #"); idx >= 0 {
+		raw = raw[:idx]
+	}
+	raw = strings.Trim(strings.TrimSpace(raw), `"'`)
+	if raw == "" || strings.Contains(raw, "${") {
+		return values
+	}
+	return append(values, raw)
+}
+
+// detectFrameworks recognizes frameworks with their own secret file conventions
+func detectFrameworks(root string) []Framework {
+	var frameworks []Framework
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(root, rel))
+		return err == nil
+	}
+	deps := packageJSONDeps(filepath.Join(root, "package.json"))
+
+	if deps["next"] || anyExists(exists, "next.config.js", "next.config.mjs", "next.config.ts") {
+		frameworks = append(frameworks, Framework{
+			Name:      "Next.js",
+			Gitignore: []string{".env*.local"},
+			Note:      "Next.js loads .env, .env.local and .env.[environment].local",
+		})
+	}
+
+	if deps["vite"] || anyExists(exists, "vite.config.js", "vite.config.mjs", "vite.config.ts") {
+		frameworks = append(frameworks, Framework{
+			Name:      "Vite",
+			Gitignore: []string{"*.local"},
+			Note:      "Vite exposes VITE_* variables to client code - never store secrets under that prefix",
+		})
+	}
+
+	if exists("manage.py") {
+		frameworks = append(frameworks, Framework{
+			Name: "Django",
+			Note: "Read settings with os.environ (or django-environ) and drop the .env file after importing",
+		})
+	}
+
+	if exists("config/application.rb") || exists("bin/rails") {
+		frameworks = append(frameworks, Framework{
+			Name:      "Rails",
+			Gitignore: []string{"/config/master.key", "/config/credentials/*.key"},
+			Note:      "Store RAILS_MASTER_KEY in alex instead of keeping config/master.key on disk",
+		})
+	}
+
+	return frameworks
+}
+
+func anyExists(exists func(string) bool, names ...string) bool {
+	for _, name := range names {
+		if exists(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// packageJSONDeps returns the dependency names declared in a package.json
+func packageJSONDeps(path string) map[string]bool {
+	deps := make(map[string]bool)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return deps
+	}
+
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return deps
+	}
+	for name := range pkg.Dependencies {
+		deps[name] = true
+	}
+	for name := range pkg.DevDependencies {
+		deps[name] = true
+	}
+	return deps
+}
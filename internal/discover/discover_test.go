@@ -0,0 +1,115 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".env":                  "A=1",
+		".env.local":            "B=2",
+		".env.example":          "A=",
+		"services/api/.env":     "C=3",
+		"node_modules/pkg/.env": "D=4",
+		"deep/a/b/c/.env":       "E=5",
+		"config/app.env":        "F=6",
+		"package.json":          `{"dependencies": {"next": "14.0.0"}}`,
+		"docker-compose.yml":    "services:\n  web:\n    env_file:\n      - config/app.env\n      - path: .env.local\n        required: false\n    environment:\n      - FOO=bar\n",
+		"config/application.rb": "",
+		"manage.py":             "",
+	})
+
+	result := Project(root)
+
+	var paths []string
+	for _, f := range result.EnvFiles {
+		paths = append(paths, f.Path)
+	}
+	wantPaths := []string{".env", ".env.local", "config/app.env", "services/api/.env"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("EnvFiles = %v, want %v", paths, wantPaths)
+	}
+
+	if !reflect.DeepEqual(result.Templates, []string{".env.example"}) {
+		t.Errorf("Templates = %v, want [.env.example]", result.Templates)
+	}
+
+	var names []string
+	for _, fw := range result.Frameworks {
+		names = append(names, fw.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Next.js", "Django", "Rails"}) {
+		t.Errorf("Frameworks = %v, want [Next.js Django Rails]", names)
+	}
+}
+
+func TestProjectIgnoresEnvFilesOutsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "project")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(parent, "outside.env"): "SECRET=x\n",
+		filepath.Join(root, "app.env"):       "KEY=y\n",
+		filepath.Join(root, "compose.yaml"):  "services:\n  web:\n    env_file: [../outside.env, app.env, " + filepath.Join(parent, "outside.env") + ", link.env]\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(parent, "outside.env"), filepath.Join(root, "link.env")); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, f := range Project(root).EnvFiles {
+		paths = append(paths, f.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"app.env"}) {
+		t.Errorf("EnvFiles = %v, want only app.env", paths)
+	}
+}
+
+func TestComposeEnvFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		compose string
+		want    []string
+	}{
+		{"short form", "services:\n  web:\n    env_file: .env\n", []string{".env"}},
+		{"quoted", "services:\n  web:\n    env_file: \"./secrets.env\" # comment\n", []string{"./secrets.env"}},
+		{"flow list", "services:\n  web:\n    env_file: [a.env, b.env]\n", []string{"a.env", "b.env"}},
+		{"block list", "services:\n  web:\n    env_file:\n      - a.env\n      - b.env\n    image: x\n", []string{"a.env", "b.env"}},
+		{"interpolated", "services:\n  web:\n    env_file: ${ENV_FILE}\n", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "compose.yaml")
+			if err := os.WriteFile(path, []byte(tc.compose), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := composeEnvFiles(path); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("composeEnvFiles() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
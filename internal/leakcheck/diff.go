@@ -0,0 +1,111 @@
+package leakcheck
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AddedLine is a line added by a diff
+type AddedLine struct {
+	File string
+	Line int
+	Text string
+}
+
+// Finding is a secret found on an added line
+type Finding struct {
+	AddedLine
+	Match
+}
+
+// ParseDiff extracts added lines from unified diff output
+// (as produced by `git diff --cached -U0`).
+func ParseDiff(r io.Reader) ([]AddedLine, error) {
+	var lines []AddedLine
+	var file string
+	lineNum := 0
+	inHeader := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(text, "diff "):
+			inHeader = true
+			file = ""
+		case strings.HasPrefix(text, "@@"):
+			inHeader = false
+			lineNum = parseHunkStart(text)
+		case inHeader && strings.HasPrefix(text, "+++ "):
+			file = parseDiffPath(strings.TrimPrefix(text, "+++ "))
+		case inHeader:
+			// Old file name, mode changes, index lines
+		case strings.HasPrefix(text, "+"):
+			if file != "" {
+				lines = append(lines, AddedLine{File: file, Line: lineNum, Text: text[1:]})
+			}
+			lineNum++
+		case strings.HasPrefix(text, " "):
+			lineNum++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// Check returns every indexed secret found on the given lines.
+// Lines containing the marker "alex:allow" are skipped.
+func (idx *Index) Check(lines []AddedLine) []Finding {
+	var findings []Finding
+	for _, line := range lines {
+		if strings.Contains(line.Text, "alex:allow") {
+			continue
+		}
+		for _, m := range idx.Match(line.Text) {
+			findings = append(findings, Finding{AddedLine: line, Match: m})
+		}
+	}
+	return findings
+}
+
+// parseDiffPath turns "b/path/to/file" into "path/to/file".
+// Returns an empty string for deleted files.
+func parseDiffPath(path string) string {
+	path = strings.TrimSuffix(path, "\t")
+	if path == "/dev/null" {
+		return ""
+	}
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		path = unquoted
+	}
+	return strings.TrimPrefix(path, "b/")
+}
+
+// parseHunkStart returns the first new-file line number of a hunk header
+// like "@@ -10,2 +12,3 @@ func main() {"
+func parseHunkStart(header string) int {
+	fields := strings.Fields(header)
+	for _, f := range fields {
+		if !strings.HasPrefix(f, "+") {
+			continue
+		}
+		f = strings.TrimPrefix(f, "+")
+		if idx := strings.Index(f, ","); idx >= 0 {
+			f = f[:idx]
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
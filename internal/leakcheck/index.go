@@ -0,0 +1,336 @@
+package leakcheck
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// WindowSize is the length of the substrings that get hashed.
+	// Values longer than this are indexed as every WindowSize-long substring,
+	// so a partially pasted secret is still caught.
+	WindowSize = 16
+
+	// MinValueLength is the shortest value that gets indexed. Shorter
+	// values ("true", "3000") would match almost every diff, and their
+	// hashes would be few enough guesses to test.
+	MinValueLength = WindowSize
+
+	// KeyPurpose derives the HMAC key of the index from the global store,
+	// see secrets.Store.DerivedKey
+	KeyPurpose = "alex leakcheck index v2"
+
+	indexVersion = 2
+	indexFile    = "leakcheck.json"
+	// legacyKeyFile held the HMAC key in plaintext in version 1
+	legacyKeyFile = "leakcheck.key"
+
+	// hashBytes is how much of each HMAC is kept in the index
+	hashBytes = 12
+
+	// filterBits is the size of the prefilter, see Index.Filter
+	filterShift = 18
+	filterBits  = 1 << filterShift
+)
+
+// ErrStaleIndex is returned when the index was built with another key,
+// e.g. before the global store was rekeyed
+var ErrStaleIndex = errors.New("hash index was built with another key (run 'alex hook refresh')")
+
+// Entry holds the keyed hashes for a single stored secret
+type Entry struct {
+	Scope  string `json:"scope"`
+	Key    string `json:"key"`
+	Length int    `json:"length"` // window length used for this value
+	// Hashes are sorted, so they don't tell which window comes after
+	// which
+	Hashes []string `json:"hashes"`
+}
+
+// Index maps keyed hashes of secret values (and their substrings) back to
+// secret names. It never contains plaintext values, and its key is not
+// stored with it.
+type Index struct {
+	Version int `json:"version"`
+	// KeyID tells whether a key is the one the index was built with
+	KeyID   string  `json:"key_id"`
+	Entries []Entry `json:"entries"`
+	// Filter has a bit set for the keyed rolling hash of every indexed
+	// window, so Match only computes the HMAC of the windows of a text
+	// that may be indexed. Bits of removed entries stay set until the
+	// index is rebuilt, which only costs time.
+	Filter []byte `json:"filter"`
+
+	hmacKey []byte
+	lookup  map[int]map[string][]int // window length -> hash -> entry indexes
+	// rollBase is the base of the rolling hash, derived from hmacKey
+	rollBase uint64
+}
+
+// Match is a secret found in a piece of text
+type Match struct {
+	Scope string
+	Key   string
+	Start int // byte offset of the match
+	End   int
+}
+
+// NewIndex creates an empty index using the given HMAC key
+func NewIndex(hmacKey []byte) *Index {
+	return &Index{Version: indexVersion, KeyID: keyID(hmacKey), Filter: make([]byte, filterBits/8), hmacKey: hmacKey}
+}
+
+// Exists reports whether an index has been written to dir
+func Exists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, indexFile))
+	return err == nil
+}
+
+// Load reads the index from dir. key must be the one it was built with.
+func Load(dir string, key []byte) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{hmacKey: key}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("corrupted leak check index (invalid JSON): %w", err)
+	}
+	if idx.Version < indexVersion {
+		return nil, fmt.Errorf("leak check index version %d is outdated (run 'alex hook refresh')", idx.Version)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported leak check index version %d", idx.Version)
+	}
+	if !hmac.Equal([]byte(idx.KeyID), []byte(keyID(key))) {
+		return nil, ErrStaleIndex
+	}
+	if len(idx.Filter) != filterBits/8 {
+		// Match everything through the HMAC
+		idx.Filter = nil
+	}
+	return idx, nil
+}
+
+// Save writes the index to dir
+func (idx *Index) Save(dir string) error {
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		if idx.Entries[i].Scope != idx.Entries[j].Scope {
+			return idx.Entries[i].Scope < idx.Entries[j].Scope
+		}
+		return idx.Entries[i].Key < idx.Entries[j].Key
+	})
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, indexFile), data, 0600); err != nil {
+		return err
+	}
+	// The key of earlier indexes, which could test guesses against them
+	if err := os.Remove(filepath.Join(dir, legacyKeyFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Put indexes a secret value, replacing any previous entry for scope/key
+func (idx *Index) Put(scope, key, value string) {
+	idx.Remove(scope, key)
+	if len(value) < MinValueLength {
+		return
+	}
+
+	length := WindowSize
+	seen := make(map[string]bool)
+	var hashes []string
+	for i := 0; i+length <= len(value); i++ {
+		h := idx.hash(value[i : i+length])
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+	if idx.Filter != nil {
+		roll := idx.newRoller(value)
+		for i := 0; i+length <= len(value); i++ {
+			bit := filterBit(roll.next(i))
+			idx.Filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+
+	idx.Entries = append(idx.Entries, Entry{Scope: scope, Key: key, Length: length, Hashes: hashes})
+	idx.lookup = nil
+}
+
+// Remove drops the entry for scope/key
+func (idx *Index) Remove(scope, key string) {
+	idx.filter(func(e Entry) bool { return e.Scope == scope && e.Key == key })
+}
+
+// RemoveScope drops every entry for scope
+func (idx *Index) RemoveScope(scope string) {
+	idx.filter(func(e Entry) bool { return e.Scope == scope })
+}
+
+func (idx *Index) filter(drop func(Entry) bool) {
+	kept := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if !drop(e) {
+			kept = append(kept, e)
+		}
+	}
+	idx.Entries = kept
+	idx.lookup = nil
+}
+
+// Len returns the number of indexed secrets
+func (idx *Index) Len() int {
+	return len(idx.Entries)
+}
+
+// Match returns the indexed secrets found in text.
+// Overlapping windows of the same secret are merged into a single match.
+func (idx *Index) Match(text string) []Match {
+	idx.buildLookup()
+
+	var matches []Match
+	for length, hashes := range idx.lookup {
+		var roll *roller
+		if idx.Filter != nil && length == WindowSize {
+			roll = idx.newRoller(text)
+		}
+		for i := 0; i+length <= len(text); i++ {
+			if roll != nil {
+				if bit := filterBit(roll.next(i)); idx.Filter[bit/8]&(1<<(bit%8)) == 0 {
+					continue
+				}
+			}
+			entries, ok := hashes[idx.hash(text[i:i+length])]
+			if !ok {
+				continue
+			}
+			for _, n := range entries {
+				matches = appendMatch(matches, Match{
+					Scope: idx.Entries[n].Scope,
+					Key:   idx.Entries[n].Key,
+					Start: i,
+					End:   i + length,
+				})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches
+}
+
+// appendMatch adds m, extending an existing match of the same secret if they overlap
+func appendMatch(matches []Match, m Match) []Match {
+	for i := range matches {
+		prev := &matches[i]
+		if prev.Scope == m.Scope && prev.Key == m.Key && m.Start <= prev.End && m.End >= prev.Start {
+			if m.Start < prev.Start {
+				prev.Start = m.Start
+			}
+			if m.End > prev.End {
+				prev.End = m.End
+			}
+			return matches
+		}
+	}
+	return append(matches, m)
+}
+
+func (idx *Index) buildLookup() {
+	if idx.lookup != nil {
+		return
+	}
+	idx.lookup = make(map[int]map[string][]int)
+	for n, e := range idx.Entries {
+		if idx.lookup[e.Length] == nil {
+			idx.lookup[e.Length] = make(map[string][]int)
+		}
+		for _, h := range e.Hashes {
+			idx.lookup[e.Length][h] = append(idx.lookup[e.Length][h], n)
+		}
+	}
+}
+
+// roller computes a polynomial rolling hash of the WindowSize-long
+// windows of a text, keyed by its base, so each window costs a few
+// multiplications instead of an HMAC
+type roller struct {
+	text string
+	base uint64
+	// top is base^(WindowSize-1), the weight of the byte leaving a window
+	top  uint64
+	hash uint64
+}
+
+func (idx *Index) newRoller(text string) *roller {
+	if idx.rollBase == 0 {
+		mac := hmac.New(sha256.New, idx.hmacKey)
+		mac.Write([]byte("rolling hash base"))
+		// Odd, so multiplying by it loses no bits
+		idx.rollBase = binary.LittleEndian.Uint64(mac.Sum(nil)) | 1
+	}
+	r := &roller{text: text, base: idx.rollBase, top: 1}
+	for i := 1; i < WindowSize; i++ {
+		r.top *= r.base
+	}
+	return r
+}
+
+// next returns the hash of the window at i. Windows must be asked for in
+// order, starting at 0.
+func (r *roller) next(i int) uint64 {
+	if i == 0 {
+		r.hash = 0
+		for j := 0; j < WindowSize; j++ {
+			r.hash = r.hash*r.base + uint64(r.text[j])
+		}
+		return r.hash
+	}
+	r.hash = (r.hash-uint64(r.text[i-1])*r.top)*r.base + uint64(r.text[i+WindowSize-1])
+	return r.hash
+}
+
+// filterBit maps a rolling hash to a bit of Index.Filter
+func filterBit(hash uint64) uint64 {
+	hash ^= hash >> 31
+	hash *= 0x9e3779b97f4a7c15
+	return hash >> (64 - filterShift)
+}
+
+// keyID identifies key without revealing it
+func keyID(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("key id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (idx *Index) hash(s string) string {
+	mac := hmac.New(sha256.New, idx.hmacKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:hashBytes])
+}
@@ -0,0 +1,173 @@
+package leakcheck
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces scrubbed values in transcripts
+const Redacted = "[REDACTED by alex]"
+
+// setCommandPattern matches `alex set [flags] KEY VALUE` as typed in a shell
+var setCommandPattern = regexp.MustCompile(`\balex\s+set\s+(?:--?[A-Za-z-]+\s+)*([A-Za-z_][A-Za-z0-9_]*)\s+("[^"]+"|'[^']+'|\\"[^"\\]+\\"|[^\s"'\\]+)`)
+
+// placeholderValues are example values from docs that are not real secrets
+var placeholderValues = []string{"...", "xxx", "VALUE", "<value>", "your-"}
+
+// Hit is a leaked value found in a source file
+type Hit struct {
+	Source
+	Line    int
+	Key     string
+	Scope   string // empty for `alex set` hits whose value is not stored
+	Literal bool   // true if found via the `alex set KEY VALUE` pattern
+	Start   int    // byte range of the leaked value within the line
+	End     int
+}
+
+// ScanFile reports every indexed value and every `alex set KEY VALUE`
+// command found in a source file
+func (idx *Index) ScanFile(src Source) ([]Hit, error) {
+	f, err := os.Open(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hits []Hit
+	err = eachLine(f, func(lineNum int, line string) {
+		for _, m := range idx.Match(line) {
+			hits = append(hits, Hit{Source: src, Line: lineNum, Key: m.Key, Scope: m.Scope, Start: m.Start, End: m.End})
+		}
+		for _, loc := range setCommandPattern.FindAllStringSubmatchIndex(line, -1) {
+			value := line[loc[4]:loc[5]]
+			if isPlaceholder(value) {
+				continue
+			}
+			hits = append(hits, Hit{Source: src, Line: lineNum, Key: line[loc[2]:loc[3]], Literal: true, Start: loc[4], End: loc[5]})
+		}
+	})
+	return hits, err
+}
+
+// Scrub removes the given hits from their file in place.
+// Shell history lines are dropped entirely; in transcripts only the leaked
+// values are replaced, so the rest of the log stays readable.
+// All hits must belong to the same file.
+func Scrub(path string, hits []Hit) error {
+	byLine := make(map[int][]Hit)
+	kind := KindTranscript
+	for _, h := range hits {
+		byLine[h.Line] = append(byLine[h.Line], h)
+		kind = h.Kind
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".alex-scrub-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = eachLineRaw(in, func(lineNum int, line, ending string) {
+		lineHits, ok := byLine[lineNum]
+		if !ok {
+			w.WriteString(line + ending)
+			return
+		}
+		if kind == KindHistory {
+			return
+		}
+		w.WriteString(redact(line, lineHits) + ending)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// redact replaces the hit ranges of a line with Redacted
+func redact(line string, hits []Hit) string {
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Start < hits[j].Start })
+
+	var b strings.Builder
+	pos := 0
+	for _, h := range hits {
+		if h.End <= pos {
+			continue
+		}
+		start := h.Start
+		if start < pos {
+			start = pos
+		}
+		b.WriteString(line[pos:start])
+		b.WriteString(Redacted)
+		pos = h.End
+	}
+	b.WriteString(line[pos:])
+	return b.String()
+}
+
+func isPlaceholder(value string) bool {
+	for _, p := range placeholderValues {
+		if strings.Contains(value, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// eachLine calls fn for every line of r (without line endings), numbered from 1
+func eachLine(r io.Reader, fn func(lineNum int, line string)) error {
+	return eachLineRaw(r, func(lineNum int, line, _ string) { fn(lineNum, line) })
+}
+
+// eachLineRaw is like eachLine but also passes the original line ending,
+// so files can be rewritten byte for byte. Lines may be arbitrarily long.
+func eachLineRaw(r io.Reader, fn func(lineNum int, line, ending string)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	lineNum := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lineNum++
+			ending := ""
+			if strings.HasSuffix(line, "\n") {
+				line = strings.TrimSuffix(line, "\n")
+				ending = "\n"
+			}
+			fn(lineNum, line, ending)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package leakcheck
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceKind describes what kind of file a source is
+type SourceKind string
+
+const (
+	// KindHistory is a shell or REPL history file, one command per line
+	KindHistory SourceKind = "shell history"
+	// KindTranscript is an AI agent session log or transcript
+	KindTranscript SourceKind = "agent transcript"
+)
+
+// Source is a local file that may contain leaked secret values
+type Source struct {
+	Path string
+	Kind SourceKind
+}
+
+// historyFiles are shell and REPL history files, relative to the home directory
+var historyFiles = []string{
+	".bash_history",
+	".zsh_history",
+	".zhistory",
+	".histfile",
+	".sh_history",
+	".history",
+	".local/share/fish/fish_history",
+	".local/share/powershell/PSReadLine/ConsoleHost_history.txt",
+	".python_history",
+	".node_repl_history",
+	".psql_history",
+	".mysql_history",
+	".sqlite_history",
+	".irb_history",
+}
+
+// transcriptDirs are directories where AI agents keep session logs,
+// relative to the home directory
+var transcriptDirs = []string{
+	".claude",
+	".codex",
+	".gemini",
+	".continue/sessions",
+	".local/share/opencode",
+	".aider",
+}
+
+// projectTranscriptFiles are agent logs written into the project root
+var projectTranscriptFiles = []string{
+	".aider.chat.history.md",
+	".aider.input.history",
+	".aider.llm.history",
+}
+
+// transcriptExtensions are the file types scanned inside transcript directories
+var transcriptExtensions = map[string]bool{
+	".jsonl": true,
+	".json":  true,
+	".log":   true,
+	".md":    true,
+	".txt":   true,
+}
+
+// LocalSources returns the shell histories and agent transcripts that exist
+// under home, plus agent logs in projectRoot (which may be empty).
+func LocalSources(home, projectRoot string) []Source {
+	var sources []Source
+	seen := make(map[string]bool)
+
+	add := func(path string, kind SourceKind) {
+		if seen[path] {
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		seen[path] = true
+		sources = append(sources, Source{Path: path, Kind: kind})
+	}
+
+	if histfile := os.Getenv("HISTFILE"); histfile != "" {
+		add(histfile, KindHistory)
+	}
+	for _, name := range historyFiles {
+		add(filepath.Join(home, name), KindHistory)
+	}
+
+	for _, dir := range transcriptDirs {
+		root := filepath.Join(home, dir)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.Type().IsRegular() && transcriptExtensions[strings.ToLower(filepath.Ext(path))] {
+				add(path, KindTranscript)
+			}
+			return nil
+		})
+	}
+
+	if projectRoot != "" {
+		for _, name := range projectTranscriptFiles {
+			add(filepath.Join(projectRoot, name), KindTranscript)
+		}
+	}
+
+	return sources
+}
@@ -0,0 +1,122 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName is the manifest file written to the project root.
+// It lists secret names only and is meant to be committed.
+const FileName = ".alex.json"
+
+const currentVersion = 1
+
+// Manifest describes which secrets a project expects, without values
+type Manifest struct {
+	Version int      `json:"version"`
+	Secrets []string `json:"secrets"`
+	// Groups are the shared secret groups the project uses, in the order
+	// their secrets are layered: later groups override earlier ones
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Path returns the manifest path for a project root
+func Path(root string) string {
+	return filepath.Join(root, FileName)
+}
+
+// Load reads the manifest from a project root.
+// Returns os.ErrNotExist if there is no manifest.
+func Load(root string) (*Manifest, error) {
+	data, err := os.ReadFile(Path(root))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", FileName, err)
+	}
+	if m.Version > currentVersion {
+		return nil, fmt.Errorf("%s has version %d, this alex only understands version %d", FileName, m.Version, currentVersion)
+	}
+	return &m, nil
+}
+
+// LoadOrEmpty reads the manifest, returning an empty one if none exists
+func LoadOrEmpty(root string) (*Manifest, error) {
+	m, err := Load(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Version: currentVersion}, nil
+	}
+	return m, err
+}
+
+// Save writes the manifest to a project root
+func (m *Manifest) Save(root string) error {
+	m.Version = currentVersion
+	sort.Strings(m.Secrets)
+	if m.Secrets == nil {
+		m.Secrets = []string{}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(root), append(data, '\n'), 0644)
+}
+
+// Has reports whether the manifest lists a secret name
+func (m *Manifest) Has(name string) bool {
+	for _, s := range m.Secrets {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add lists a secret name, returning false if it was already listed
+func (m *Manifest) Add(name string) bool {
+	if m.Has(name) {
+		return false
+	}
+	m.Secrets = append(m.Secrets, name)
+	return true
+}
+
+// HasGroup reports whether the manifest lists a group
+func (m *Manifest) HasGroup(name string) bool {
+	for _, g := range m.Groups {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddGroup lists a group after the others, returning false if it was
+// already listed
+func (m *Manifest) AddGroup(name string) bool {
+	if m.HasGroup(name) {
+		return false
+	}
+	m.Groups = append(m.Groups, name)
+	return true
+}
+
+// RemoveGroup unlists a group, returning false if it was not listed
+func (m *Manifest) RemoveGroup(name string) bool {
+	for i, g := range m.Groups {
+		if g == name {
+			m.Groups = append(m.Groups[:i], m.Groups[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+// Package recovery splits a recovery secret into Shamir shares that can be
+// printed and handed out, and reassembles the secret from enough of them.
+//
+// A share is printed either as a list of words, one per byte, or as
+// armored base64 text. Both carry the same payload:
+//
+//	version (1) | set ID (4) | threshold (1) | index (1) | data | checksum (2)
+//
+// The set ID tells shares of different splits apart and the checksum
+// catches typos in a single share.
+package recovery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	shareVersion = 1
+
+	armorBegin = "-----BEGIN ALEX RECOVERY SHARE-----"
+	armorEnd   = "-----END ALEX RECOVERY SHARE-----"
+
+	// wordsPerLine is how many words a printed share has per line
+	wordsPerLine = 8
+)
+
+// ErrChecksum indicates a share was mistyped or damaged
+var ErrChecksum = errors.New("share checksum mismatch (check for typos)")
+
+// Share is one part of a split secret
+type Share struct {
+	// SetID is shared by all shares of one split
+	SetID uint32
+	// Threshold is how many shares are needed to reassemble the secret
+	Threshold int
+	// Index is the share's number, from 1
+	Index int
+	Data  []byte
+}
+
+// Split splits secret into n shares, any threshold of which reassemble it
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= 255 (got %d of %d)", threshold, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	ys, err := splitBytes(secret, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]Share, n)
+	for i, y := range ys {
+		shares[i] = Share{SetID: binary.BigEndian.Uint32(id[:]), Threshold: threshold, Index: i + 1, Data: y}
+	}
+	return shares, nil
+}
+
+// Combine reassembles a secret from at least Threshold shares of one split
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	first := shares[0]
+	seen := make(map[int]bool)
+	var xs []byte
+	var ys [][]byte
+	for _, s := range shares {
+		if s.SetID != first.SetID {
+			return nil, fmt.Errorf("share %d belongs to another recovery key (set %08x, not %08x)", s.Index, s.SetID, first.SetID)
+		}
+		if len(s.Data) != len(first.Data) || s.Threshold != first.Threshold {
+			return nil, fmt.Errorf("share %d doesn't match the others", s.Index)
+		}
+		if seen[s.Index] {
+			continue
+		}
+		seen[s.Index] = true
+		xs = append(xs, byte(s.Index))
+		ys = append(ys, s.Data)
+	}
+	if len(xs) < first.Threshold {
+		return nil, fmt.Errorf("need %d different shares, have %d", first.Threshold, len(xs))
+	}
+	return combineBytes(xs[:first.Threshold], ys[:first.Threshold])
+}
+
+// payload encodes the share with its checksum
+func (s Share) payload() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(shareVersion)
+	binary.Write(&buf, binary.BigEndian, s.SetID)
+	buf.WriteByte(byte(s.Threshold))
+	buf.WriteByte(byte(s.Index))
+	buf.Write(s.Data)
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:2])
+	return buf.Bytes()
+}
+
+// Words returns the share as lines of words
+func (s Share) Words() string {
+	var b strings.Builder
+	for i, c := range s.payload() {
+		switch {
+		case i == 0:
+		case i%wordsPerLine == 0:
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(wordList[c])
+	}
+	return b.String()
+}
+
+// Armor returns the share as armored base64 text
+func (s Share) Armor() string {
+	encoded := base64.StdEncoding.EncodeToString(s.payload())
+	var b strings.Builder
+	b.WriteString(armorBegin + "\n")
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n" + armorEnd)
+	return b.String()
+}
+
+// ParseShare reads one share in either format. Words may be abbreviated
+// to their first four letters.
+func ParseShare(text string) (Share, error) {
+	var payload []byte
+	if strings.Contains(text, armorBegin) {
+		body, _, _ := strings.Cut(text[strings.Index(text, armorBegin)+len(armorBegin):], armorEnd)
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+		if err != nil {
+			return Share{}, fmt.Errorf("invalid armored share: %w", err)
+		}
+		payload = decoded
+	} else {
+		for _, word := range strings.Fields(text) {
+			b, ok := wordByte(word)
+			if !ok {
+				return Share{}, fmt.Errorf("unknown word %q in share", word)
+			}
+			payload = append(payload, b)
+		}
+	}
+
+	if len(payload) < 10 {
+		return Share{}, errors.New("share is too short")
+	}
+	body, sum := payload[:len(payload)-2], payload[len(payload)-2:]
+	want := sha256.Sum256(body)
+	if !bytes.Equal(sum, want[:2]) {
+		return Share{}, ErrChecksum
+	}
+	if body[0] != shareVersion {
+		return Share{}, fmt.Errorf("share version %d is not supported", body[0])
+	}
+	s := Share{
+		SetID:     binary.BigEndian.Uint32(body[1:5]),
+		Threshold: int(body[5]),
+		Index:     int(body[6]),
+		Data:      body[7:],
+	}
+	if s.Index == 0 || s.Threshold < 2 {
+		return Share{}, errors.New("invalid share header")
+	}
+	return s, nil
+}
+
+// ParseShares reads shares separated by blank lines. Lines starting with
+// # are ignored.
+func ParseShares(text string) ([]Share, error) {
+	var shares []Share
+	var block []string
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		s, err := ParseShare(strings.Join(block, "\n"))
+		if err != nil {
+			return err
+		}
+		shares = append(shares, s)
+		block = nil
+		return nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case line == "":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			block = append(block, line)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Index < shares[j].Index })
+	return shares, nil
+}
+
+// wordByte returns the byte of a word or its four-letter abbreviation
+func wordByte(word string) (byte, bool) {
+	word = strings.ToLower(word)
+	for i, w := range wordList {
+		if w == word || (len(word) == 4 && strings.HasPrefix(w, word)) {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,84 @@
+package recovery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var subset []Share
+		for _, i := range pick {
+			subset = append(subset, shares[i])
+		}
+		got, err := Combine(subset)
+		if err != nil {
+			t.Fatalf("Combine(%v) error = %v", pick, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("Combine(%v) = %x, want %x", pick, got, secret)
+		}
+	}
+
+	if _, err := Combine(shares[:2]); err == nil {
+		t.Error("Combine() with fewer shares than the threshold succeeded")
+	}
+	if _, err := Combine([]Share{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("Combine() with a repeated share succeeded")
+	}
+	other, _ := Split(secret, 5, 3)
+	if _, err := Combine([]Share{shares[0], shares[1], other[2]}); err == nil {
+		t.Error("Combine() with shares of two splits succeeded")
+	}
+}
+
+func TestShareEncodings(t *testing.T) {
+	shares, err := Split([]byte("0123456789abcdef0123456789abcdef"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := "# share 1\n" + shares[0].Words() + "\n\n" + shares[2].Armor() + "\n"
+	parsed, err := ParseShares(text)
+	if err != nil {
+		t.Fatalf("ParseShares() error = %v", err)
+	}
+	if len(parsed) != 2 || parsed[0].Index != 1 || parsed[1].Index != 3 {
+		t.Fatalf("ParseShares() = %+v, want shares 1 and 3", parsed)
+	}
+	got, err := Combine(parsed)
+	if err != nil || string(got) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Combine() = %q, %v", got, err)
+	}
+
+	// Four-letter abbreviations work; a changed word is caught
+	words := strings.Fields(shares[1].Words())
+	abbreviated := make([]string, len(words))
+	for i, w := range words {
+		if len(w) > 4 {
+			w = w[:4]
+		}
+		abbreviated[i] = w
+	}
+	if s, err := ParseShare(strings.Join(abbreviated, " ")); err != nil || s.Index != 2 {
+		t.Errorf("ParseShare() abbreviated = %+v, %v", s, err)
+	}
+	if words[10] == wordList[0] {
+		words[10] = wordList[1]
+	} else {
+		words[10] = wordList[0]
+	}
+	if _, err := ParseShare(strings.Join(words, " ")); !errors.Is(err, ErrChecksum) {
+		t.Errorf("ParseShare() with a typo error = %v, want ErrChecksum", err)
+	}
+}
@@ -0,0 +1,87 @@
+package recovery
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Shamir's secret sharing over GF(2^8), byte by byte: each byte of the
+// secret is the constant term of a random polynomial of degree
+// threshold-1, and each share holds the polynomials evaluated at its x.
+
+// gfMul multiplies in GF(2^8) with the AES polynomial x^8+x^4+x^3+x+1
+func gfMul(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// gfInv returns the multiplicative inverse of a non-zero a, which is a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// splitBytes returns the y values of secret at x = 1..n
+func splitBytes(secret []byte, n, threshold int) ([][]byte, error) {
+	ys := make([][]byte, n)
+	for i := range ys {
+		ys[i] = make([]byte, len(secret))
+	}
+
+	coefficients := make([]byte, threshold)
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range ys {
+			x := byte(i + 1)
+			// Horner's method, highest coefficient first
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			ys[i][pos] = y
+		}
+	}
+	return ys, nil
+}
+
+// combineBytes interpolates the polynomials through (xs[i], ys[i]) at 0
+func combineBytes(xs []byte, ys [][]byte) ([]byte, error) {
+	if len(xs) == 0 {
+		return nil, errors.New("no shares")
+	}
+	secret := make([]byte, len(ys[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial of share i at 0
+		basis := byte(1)
+		for j, xj := range xs {
+			if i == j {
+				continue
+			}
+			if xi == xj {
+				return nil, errors.New("the same share was given twice")
+			}
+			basis = gfMul(basis, gfMul(xj, gfInv(xi^xj)))
+		}
+		for pos := range secret {
+			secret[pos] ^= gfMul(ys[i][pos], basis)
+		}
+	}
+	return secret, nil
+}
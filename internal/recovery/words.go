@@ -0,0 +1,38 @@
+package recovery
+
+// wordList maps each byte to a word. Every word has a distinct first four
+// letters, so shares can be typed back with abbreviations.
+var wordList = [256]string{
+	"acid", "acorn", "actor", "adapt", "agent", "alarm", "album", "alert",
+	"alley", "alpha", "amber", "angle", "ankle", "apple", "apron", "arena",
+	"arrow", "atlas", "attic", "audio", "autumn", "avocado", "badge", "bagel",
+	"baker", "bamboo", "banjo", "barrel", "basil", "beach", "walrus", "beaver",
+	"bench", "berry", "bicycle", "biscuit", "blanket", "blossom", "boat", "bonus",
+	"book", "border", "bottle", "brave", "bread", "bridge", "bronze", "brush",
+	"bubble", "bucket", "buffalo", "butter", "cabin", "cactus", "camera", "tulip",
+	"candle", "canoe", "canyon", "carbon", "carpet", "castle", "cedar", "cello",
+	"chalk", "cheese", "cherry", "chess", "circle", "citrus", "clock", "cloud",
+	"clover", "cobalt", "cocoa", "comet", "copper", "coral", "cotton", "cousin",
+	"crane", "crayon", "cricket", "crystal", "cupcake", "curtain", "cycle", "dagger",
+	"daisy", "dancer", "delta", "denim", "desert", "diamond", "dinner", "dolphin",
+	"domino", "donkey", "dragon", "drift", "drum", "eagle", "earth", "echo",
+	"eclipse", "elbow", "elder", "ember", "engine", "envoy", "fabric", "falcon",
+	"feather", "fern", "ferry", "fiddle", "finch", "flame", "flute", "forest",
+	"fossil", "fountain", "fox", "frost", "galaxy", "garden", "garlic", "gazelle",
+	"gecko", "ginger", "glacier", "globe", "gold", "gopher", "granite", "grape",
+	"gravel", "guitar", "hammer", "harbor", "harvest", "hazel", "helmet", "heron",
+	"hickory", "honey", "horizon", "hotel", "husky", "igloo", "indigo", "iris",
+	"island", "ivory", "jacket", "jaguar", "jasmine", "jelly", "jigsaw", "jungle",
+	"kayak", "kernel", "kettle", "kiwi", "koala", "ladder", "lagoon", "lantern",
+	"laser", "lemon", "lilac", "linen", "lizard", "lobster", "locket", "lotus",
+	"magnet", "mango", "maple", "marble", "meadow", "melon", "meteor", "mirror",
+	"mitten", "monkey", "mosaic", "muffin", "museum", "napkin", "nectar", "needle",
+	"nickel", "noodle", "nutmeg", "oasis", "ocean", "olive", "onion", "opal",
+	"orbit", "orchid", "otter", "oyster", "paddle", "panda", "papaya", "parrot",
+	"peanut", "pebble", "pelican", "pepper", "piano", "pickle", "pillow", "pilot",
+	"pine", "pizza", "planet", "plum", "poem", "pony", "poppy", "prism",
+	"pumpkin", "puzzle", "quail", "quartz", "quilt", "rabbit", "radar", "radish",
+	"raven", "ribbon", "river", "robin", "rocket", "ruby", "saddle", "salmon",
+	"sandal", "satin", "scarf", "shadow", "shell", "silver", "sketch", "sleet",
+	"snow", "sofa", "spider", "sponge", "spruce", "squid", "summit", "sunset",
+}
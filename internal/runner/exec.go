@@ -1,12 +1,16 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"syscall"
 )
 
+// ErrCommandNotFound indicates the command to run is not on PATH
+var ErrCommandNotFound = errors.New("command not found")
+
 // Run executes a command with the given secrets injected into the environment
 func Run(args []string, secrets map[string]string) error {
 	if len(args) == 0 {
@@ -16,7 +20,7 @@ func Run(args []string, secrets map[string]string) error {
 	// Find the executable
 	executable, err := exec.LookPath(args[0])
 	if err != nil {
-		return fmt.Errorf("command not found: %s", args[0])
+		return fmt.Errorf("%w: %s", ErrCommandNotFound, args[0])
 	}
 
 	// Build environment: current env + secrets
@@ -2,6 +2,8 @@ package secrets
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,15 +15,31 @@ import (
 // ErrWrongPassphrase indicates the passphrase was incorrect
 var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted secrets file")
 
+// ErrCorruptedStore indicates a secrets file that is not valid encrypted data
+var ErrCorruptedStore = errors.New("corrupted secrets file")
+
 // encrypt encrypts data using age with a passphrase
 func encrypt(data []byte, passphrase string) ([]byte, error) {
 	recipient, err := age.NewScryptRecipient(passphrase)
 	if err != nil {
 		return nil, err
 	}
+	return encryptTo(data, recipient)
+}
+
+// decrypt decrypts data using age with a passphrase
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passphrase format: %w", err)
+	}
+	return decryptWith(data, identity)
+}
 
+// encryptTo encrypts data to age recipients
+func encryptTo(data []byte, recipients ...age.Recipient) ([]byte, error) {
 	var buf bytes.Buffer
-	writer, err := age.Encrypt(&buf, recipient)
+	writer, err := age.Encrypt(&buf, recipients...)
 	if err != nil {
 		return nil, err
 	}
@@ -37,14 +55,9 @@ func encrypt(data []byte, passphrase string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// decrypt decrypts data using age with a passphrase
-func decrypt(data []byte, passphrase string) ([]byte, error) {
-	identity, err := age.NewScryptIdentity(passphrase)
-	if err != nil {
-		return nil, fmt.Errorf("invalid passphrase format: %w", err)
-	}
-
-	reader, err := age.Decrypt(bytes.NewReader(data), identity)
+// decryptWith decrypts data with age identities
+func decryptWith(data []byte, identities ...age.Identity) ([]byte, error) {
+	reader, err := age.Decrypt(bytes.NewReader(data), identities...)
 	if err != nil {
 		// age returns generic errors, make them more user-friendly
 		errStr := err.Error()
@@ -55,7 +68,7 @@ func decrypt(data []byte, passphrase string) ([]byte, error) {
 		}
 		if strings.Contains(errStr, "unknown format") ||
 			strings.Contains(errStr, "header") {
-			return nil, fmt.Errorf("corrupted secrets file (not a valid encrypted file): %w", err)
+			return nil, fmt.Errorf("%w (not a valid encrypted file): %v", ErrCorruptedStore, err)
 		}
 		return nil, fmt.Errorf("decryption failed: %w", err)
 	}
@@ -67,3 +80,53 @@ func decrypt(data []byte, passphrase string) ([]byte, error) {
 
 	return result, nil
 }
+
+// wrapDataKey encrypts a store's data key with the store key
+func wrapDataKey(dataKey *age.X25519Identity, key *Key) ([]byte, error) {
+	return encryptTo([]byte(dataKey.String()), key.recipient)
+}
+
+// unwrapDataKey decrypts a store's data key with the store key
+func unwrapDataKey(data []byte, key *Key) (*age.X25519Identity, error) {
+	plain, err := decryptWith(data, key.identity)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := age.ParseX25519Identity(strings.TrimSpace(string(plain)))
+	if err != nil {
+		return nil, fmt.Errorf("%w (invalid data key): %v", ErrCorruptedStore, err)
+	}
+	return dataKey, nil
+}
+
+// SelfTest runs an encrypt/decrypt round-trip with a throwaway passphrase
+// and checks that a wrong passphrase is rejected
+func SelfTest() error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return fmt.Errorf("reading random data: %w", err)
+	}
+	data := random[:16]
+	passphrase := hex.EncodeToString(random[16:])
+
+	encrypted, err := encrypt(data, passphrase)
+	if err != nil {
+		return fmt.Errorf("encrypt failed: %w", err)
+	}
+	if bytes.Contains(encrypted, data) {
+		return errors.New("encrypted output contains the plaintext")
+	}
+
+	decrypted, err := decrypt(encrypted, passphrase)
+	if err != nil {
+		return fmt.Errorf("decrypt failed: %w", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		return errors.New("round-trip returned different data")
+	}
+
+	if _, err := decrypt(encrypted, passphrase+"-wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		return errors.New("wrong passphrase was not rejected")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fingerprintKeyFile keys the fingerprints of this machine. It never
+// leaves the machine, so its fingerprints only compare with each other.
+const fingerprintKeyFile = "fingerprint.key"
+
+// FingerprintKey returns the key of this machine's fingerprints, creating
+// it on first use
+func FingerprintKey() ([]byte, error) {
+	dir, err := GetGlobalDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fingerprintKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(string(data))
+		if err != nil || len(key) != sha256.Size {
+			return nil, fmt.Errorf("corrupted fingerprint key %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileExclusive(path, []byte(hex.EncodeToString(key))); errors.Is(err, os.ErrExist) {
+		// Another alex created it first
+		return FingerprintKey()
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Fingerprint returns a short HMAC of a secret value under key. Equal
+// fingerprints under the same key mean equal values; without the key a
+// fingerprint tells nothing about the value.
+func Fingerprint(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// sharedFingerprintDomain separates shared fingerprint keys from any
+// other use of SHA-256
+const sharedFingerprintDomain = "alex shared fingerprint v1\x00"
+
+// SharedFingerprint returns a short HMAC of a secret value that compares
+// across machines: its key derives from context, the name of the secret
+// and a phrase a team agrees on, rather than from this machine. A name
+// alone is public, so context must include the phrase. Whoever knows
+// context can test guesses against the 64-bit result, so it only hides
+// values that are hard to guess, and it shows when a value changes.
+func SharedFingerprint(context, value string) string {
+	key := sha256.Sum256([]byte(sharedFingerprintDomain + context))
+	return Fingerprint(key[:], value)
+}
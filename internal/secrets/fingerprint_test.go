@@ -0,0 +1,44 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	isolateHome(t)
+	key, err := FingerprintKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := FingerprintKey()
+	if err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("FingerprintKey() again = %x, %v, want the same key", again, err)
+	}
+
+	a, b := Fingerprint(key, "postgres://db"), Fingerprint(key, "postgres://db")
+	if a != b || len(a) != 16 {
+		t.Errorf("Fingerprint() = %q and %q, want equal 16-character fingerprints", a, b)
+	}
+	if Fingerprint(key, "postgres://other") == a {
+		t.Error("different values have the same fingerprint")
+	}
+	if Fingerprint([]byte("other key"), "postgres://db") == a {
+		t.Error("fingerprints under different keys are equal")
+	}
+}
+
+func TestSharedFingerprint(t *testing.T) {
+	// Teammates compare these across machines and versions, so they must
+	// never change
+	if got := SharedFingerprint("STRIPE_KEY", "sk_test_123"); got != "6fd18a2e7db04052" {
+		t.Errorf("SharedFingerprint() = %q, want 6fd18a2e7db04052", got)
+	}
+	a := SharedFingerprint("STRIPE_KEY", "sk_test_123")
+	if SharedFingerprint("STRIPE_KEY", "sk_test_124") == a {
+		t.Error("different values have the same shared fingerprint")
+	}
+	if SharedFingerprint("STRIPE_KEY\x00acme", "sk_test_123") == a {
+		t.Error("shared fingerprints under different contexts are equal")
+	}
+}
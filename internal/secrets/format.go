@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Store file formats:
+//
+//	v1: a bare age scrypt blob wrapping a JSON map of name to Secret
+//	v2: a container that starts with the line "alex-secrets/2", followed by
+//	    named sections of the form "<name> <length>\n<bytes>\n":
+//	      key     the store's X25519 data key, wrapped with the primary key
+//	      key.*   the data key wrapped with an extra key slot, e.g. key.backup
+//	      index   names and timestamps, encrypted to the data key
+//	      values  names and values, encrypted to the data key
+//
+// Splitting index and values lets commands like list read metadata without
+// decrypting any value, and the data key means only the key section is
+// protected by scrypt.
+const (
+	formatV1 = 1
+	formatV2 = 2
+
+	// CurrentFormat is the format version new and migrated stores use
+	CurrentFormat = formatV2
+
+	formatMagic = "alex-secrets/"
+)
+
+const (
+	sectionKey    = "key"
+	sectionIndex  = "index"
+	sectionValues = "values"
+
+	// sectionSlotPrefix starts the sections of extra key slots
+	sectionSlotPrefix = "key."
+)
+
+// slotSection returns the section holding the data key wrapped with the
+// named key slot; "" is the primary key
+func slotSection(slot string) string {
+	if slot == "" {
+		return sectionKey
+	}
+	return sectionSlotPrefix + slot
+}
+
+// section is a named, length-prefixed part of a container
+type section struct {
+	name string
+	data []byte
+}
+
+// container is a parsed v2+ store file
+type container struct {
+	version  int
+	sections []section
+}
+
+// FormatVersion returns the format version of store file data. Files
+// without the container magic are v1.
+func FormatVersion(data []byte) int {
+	if !bytes.HasPrefix(data, []byte(formatMagic)) {
+		return formatV1
+	}
+	line, _, _ := bytes.Cut(data[len(formatMagic):], []byte("\n"))
+	version, err := strconv.Atoi(string(line))
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// get returns the data of the first section with name
+func (c *container) get(name string) ([]byte, bool) {
+	for _, s := range c.sections {
+		if s.name == name {
+			return s.data, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the section with name, or appends it
+func (c *container) set(name string, data []byte) {
+	for i := range c.sections {
+		if c.sections[i].name == name {
+			c.sections[i].data = data
+			return
+		}
+	}
+	c.sections = append(c.sections, section{name: name, data: data})
+}
+
+// slotSections returns the sections of extra key slots
+func (c *container) slotSections() []section {
+	var slots []section
+	for _, s := range c.sections {
+		if strings.HasPrefix(s.name, sectionSlotPrefix) {
+			slots = append(slots, s)
+		}
+	}
+	return slots
+}
+
+// remove deletes the section with name
+func (c *container) remove(name string) {
+	for i := range c.sections {
+		if c.sections[i].name == name {
+			c.sections = append(c.sections[:i], c.sections[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *container) encode() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%d\n", formatMagic, c.version)
+	for _, s := range c.sections {
+		fmt.Fprintf(&buf, "%s %d\n", s.name, len(s.data))
+		buf.Write(s.data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func parseContainer(data []byte) (*container, error) {
+	version := FormatVersion(data)
+	if version < formatV2 {
+		return nil, fmt.Errorf("%w (not a v2 container)", ErrCorruptedStore)
+	}
+
+	src := bytes.NewReader(data)
+	r := bufio.NewReader(src)
+	if _, err := r.ReadString('\n'); err != nil {
+		return nil, fmt.Errorf("%w (truncated header)", ErrCorruptedStore)
+	}
+
+	c := &container{version: version}
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return c, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w (truncated section header)", ErrCorruptedStore)
+		}
+
+		name, lengthStr, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		length, convErr := strconv.Atoi(lengthStr)
+		if !ok || convErr != nil || length < 0 {
+			return nil, fmt.Errorf("%w (invalid section header %q)", ErrCorruptedStore, strings.TrimSpace(line))
+		}
+
+		// The length comes from the file: never allocate more than is left
+		if remaining := src.Len() + r.Buffered(); length >= remaining {
+			return nil, fmt.Errorf("%w (truncated %s section)", ErrCorruptedStore, name)
+		}
+		body := make([]byte, length+1)
+		if _, err := io.ReadFull(r, body); err != nil || body[length] != '\n' {
+			return nil, fmt.Errorf("%w (truncated %s section)", ErrCorruptedStore, name)
+		}
+		c.sections = append(c.sections, section{name: name, data: body[:length]})
+	}
+}
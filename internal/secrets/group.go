@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// groupsDir holds the stores of secret groups, by name
+const groupsDir = "groups"
+
+// ErrNoGroup indicates a group was never created
+var ErrNoGroup = errors.New("no such group")
+
+// ErrGroupExists indicates a group was already created
+var ErrGroupExists = errors.New("group already exists")
+
+// groupNamePattern matches group names: lowercase letters, digits, dots,
+// dashes and underscores, starting with a letter or digit
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// ValidateGroupName checks a group name given by the user
+func ValidateGroupName(name string) error {
+	if !groupNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid group name %q: use lowercase letters, digits, '.', '-' and '_'", name)
+	}
+	return nil
+}
+
+// GetGroupsDir returns the directory holding all group stores
+func GetGroupsDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, alexDir, groupsDir), nil
+}
+
+// GroupDir returns the store directory of the group name
+func GroupDir(name string) (string, error) {
+	if err := ValidateGroupName(name); err != nil {
+		return "", err
+	}
+	dir, err := GetGroupsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// groupName returns the name of the group whose store is in dir, or an
+// empty string if dir is not a group store
+func groupName(dir string) string {
+	groups, err := GetGroupsDir()
+	if err != nil || filepath.Dir(filepath.Clean(dir)) != groups {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// GroupExists reports whether the group name was created
+func GroupExists(name string) bool {
+	dir, err := GroupDir(name)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// CreateGroup creates the group name. Its store gets a file with the
+// first secret set in it.
+func CreateGroup(name string) error {
+	dir, err := GroupDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrGroupExists, name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListGroups returns the names of all groups, sorted
+func ListGroups() ([]string, error) {
+	dir, err := GetGroupsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && groupNamePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RemoveGroup deletes the group name and its secrets
+func RemoveGroup(name string) error {
+	dir, err := GroupDir(name)
+	if err != nil {
+		return err
+	}
+	if !GroupExists(name) {
+		return fmt.Errorf("%w: %s", ErrNoGroup, name)
+	}
+	return os.RemoveAll(dir)
+}
+
+// OpenGroup opens the store of the group name, which must have been
+// created with CreateGroup
+func (u *Unlocker) OpenGroup(name string) (*Store, error) {
+	dir, err := GroupDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if !GroupExists(name) {
+		return nil, fmt.Errorf("%w: %s (create it with 'alex group create %s')", ErrNoGroup, name, name)
+	}
+	return u.Open(dir)
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateGroupName(t *testing.T) {
+	for _, name := range []string{"acme-backend", "staging", "team.infra", "v2_shared"} {
+		if err := ValidateGroupName(name); err != nil {
+			t.Errorf("ValidateGroupName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Acme", "../projects", "a/b", "-flag", ".hidden", "with space"} {
+		if err := ValidateGroupName(name); err == nil {
+			t.Errorf("ValidateGroupName(%q) accepted an invalid name", name)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	isolateHome(t)
+	u := &Unlocker{}
+
+	if _, err := u.OpenGroup("acme-backend"); !errors.Is(err, ErrNoGroup) {
+		t.Fatalf("OpenGroup() before CreateGroup error = %v, want ErrNoGroup", err)
+	}
+	for _, name := range []string{"staging", "acme-backend"} {
+		if err := CreateGroup(name); err != nil {
+			t.Fatalf("CreateGroup(%q) error = %v", name, err)
+		}
+	}
+	if err := CreateGroup("staging"); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("CreateGroup() twice error = %v, want ErrGroupExists", err)
+	}
+	if names, err := ListGroups(); err != nil || !reflect.DeepEqual(names, []string{"acme-backend", "staging"}) {
+		t.Errorf("ListGroups() = %v, %v", names, err)
+	}
+
+	store, err := u.OpenGroup("acme-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("REGISTRY_TOKEN", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storeName(store.Dir()); got != "group:acme-backend" {
+		t.Errorf("storeName() = %q, want group:acme-backend", got)
+	}
+	if info, _ := ReadProjectInfo(store.Dir()); info != nil {
+		t.Errorf("group store recorded a project: %+v", info)
+	}
+
+	if err := RemoveGroup("acme-backend"); err != nil {
+		t.Fatal(err)
+	}
+	if GroupExists("acme-backend") || StoreExists(store.Dir()) {
+		t.Error("group still exists after RemoveGroup()")
+	}
+	if err := RemoveGroup("acme-backend"); !errors.Is(err, ErrNoGroup) {
+		t.Errorf("RemoveGroup() twice error = %v, want ErrNoGroup", err)
+	}
+}
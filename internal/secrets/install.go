@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	installSecretFile  = "install-secret"
+	installSecretBytes = 32
+
+	// installSecretEnv overrides where the install secret is kept
+	installSecretEnv = "ALEX_INSTALL_SECRET_FILE"
+)
+
+// Machine key versions recorded in KeyInfo.MachineKeyVersion
+const (
+	// machineKeyV1 is the hashed machine ID alone
+	machineKeyV1 = 1
+	// machineKeyV2 combines the machine ID with the install secret
+	machineKeyV2 = 2
+)
+
+// ErrInstallSecretMissing indicates the install secret needed to unlock a
+// machine-keyed store is not on this machine
+var ErrInstallSecretMissing = errors.New("install secret is missing")
+
+// ErrInstallSecretChanged indicates a machine-keyed store was encrypted
+// with a different install secret than the one on this machine
+var ErrInstallSecretChanged = errors.New("install secret has changed since the store was encrypted")
+
+// InstallSecretPath returns where the per-installation secret is kept.
+// It lives outside ~/.alex, in the user's state directory, so it is not
+// copied along with the stores; $ALEX_INSTALL_SECRET_FILE or
+// install_secret_file in config.json choose another location, such as a
+// separately protected volume.
+func InstallSecretPath() (string, error) {
+	if path := os.Getenv(installSecretEnv); path != "" {
+		return path, nil
+	}
+	if path := LoadConfig().InstallSecretFile; path != "" {
+		return expandHome(path)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(homeDir, "Library", "Application Support", "alex", installSecretFile), nil
+	}
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateDir, "alex", installSecretFile), nil
+}
+
+// loadInstallSecret reads the install secret, generating a random one if
+// create is set and none exists yet
+func loadInstallSecret(create bool) ([]byte, error) {
+	path, err := InstallSecretPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, decodeErr := hex.DecodeString(strings.TrimSpace(string(data)))
+		if decodeErr != nil || len(secret) != installSecretBytes {
+			return nil, fmt.Errorf("invalid install secret in %s", path)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("%w (expected at %s)", ErrInstallSecretMissing, path)
+	}
+
+	secret := make([]byte, installSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// O_EXCL: if another alex process created it first, use theirs
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return loadInstallSecret(false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.WriteString(hex.EncodeToString(secret) + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return secret, nil
+}
+
+// InstallSecretExists reports whether the install secret has been created
+func InstallSecretExists() bool {
+	path, err := InstallSecretPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// machineKey combines the hashed machine ID with the install secret, so
+// knowing /etc/machine-id alone is not enough to derive the key
+func machineKey(machineID string, installSecret []byte) string {
+	mac := hmac.New(sha256.New, installSecret)
+	mac.Write([]byte("alex-machine-key-v2:" + machineID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~")), nil
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	keyInfoFile    = "key.json"
+	keyInfoVersion = 1
+)
+
+// KeyMode says how the key of a store is obtained
+type KeyMode string
+
+const (
+	// KeyModeMachine stores are encrypted with a key derived from the machine ID
+	KeyModeMachine KeyMode = "machine"
+	// KeyModePassphrase stores are encrypted with a passphrase, typed or
+	// read from passphrase_command or --passphrase-fd
+	KeyModePassphrase KeyMode = "passphrase"
+	// KeyModeIdentity stores are encrypted to an age X25519 identity file
+	KeyModeIdentity KeyMode = "identity"
+	// KeyModeSSH stores are encrypted to an SSH key
+	KeyModeSSH KeyMode = "ssh"
+	// KeyModePlugin stores are encrypted to an age plugin identity
+	KeyModePlugin KeyMode = "plugin"
+	// KeyModeRecovery key slots hold a recovery key that is split into
+	// shares instead of being kept on the machine
+	KeyModeRecovery KeyMode = "recovery"
+)
+
+// KeyInfo is the unencrypted header kept next to secrets.enc that records
+// how the store is keyed, so commands can unlock it without guessing.
+// It holds nothing that helps decrypt the store.
+type KeyInfo struct {
+	Version int     `json:"version"`
+	Mode    KeyMode `json:"mode"`
+	// MachineCheck fingerprints the hashed machine ID of a machine-keyed
+	// store, which tells a changed machine ID apart from a damaged file
+	MachineCheck string `json:"machine_check,omitempty"`
+	// MachineSource is where the machine ID was read from
+	MachineSource string `json:"machine_source,omitempty"`
+	// MachineKeyVersion is how the machine key was derived: 1 (or unset)
+	// is the machine ID alone, 2 adds the install secret
+	MachineKeyVersion int `json:"machine_key_version,omitempty"`
+	// InstallCheck fingerprints the install secret of a v2 machine key
+	InstallCheck string `json:"install_check,omitempty"`
+	// Identity is the key file of an identity, ssh or plugin store
+	Identity string `json:"identity,omitempty"`
+	// Recipient is the public key the data key is wrapped to
+	Recipient string `json:"recipient,omitempty"`
+	// Master is the public key of the master key a machine or passphrase
+	// store's data key is wrapped to, if it has one
+	Master string `json:"master,omitempty"`
+
+	// Name is the name of an extra key slot
+	Name string `json:"name,omitempty"`
+	// Slots are extra keys that each unlock the store on their own
+	Slots []KeyInfo `json:"slots,omitempty"`
+}
+
+// ReadKeyInfo reads the key header of the store in dir.
+// Returns nil without an error if the store has no header yet.
+func ReadKeyInfo(dir string) (*KeyInfo, error) {
+	data, err := os.ReadFile(filepath.Join(dir, keyInfoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info KeyInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%w (invalid key header %s): %v", ErrCorruptedStore, keyInfoFile, err)
+	}
+	return &info, nil
+}
+
+// WriteKeyInfo writes the key header of the store in dir
+func WriteKeyInfo(dir string, info *KeyInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, keyInfoFile), append(data, '\n'), 0600)
+}
+
+// machineKeyInfo returns the header for a store keyed with the machine ID
+// and install secret
+func machineKeyInfo(machine *MachineIDResult, installSecret []byte) *KeyInfo {
+	return &KeyInfo{
+		Version:           keyInfoVersion,
+		Mode:              KeyModeMachine,
+		MachineCheck:      keyCheck("machine", machine.ID),
+		MachineSource:     machine.Source,
+		MachineKeyVersion: machineKeyV2,
+		InstallCheck:      keyCheck("install", hex.EncodeToString(installSecret)),
+	}
+}
+
+// providerKeyInfo returns the header for a store keyed with key
+func providerKeyInfo(key *Key) *KeyInfo {
+	return &KeyInfo{
+		Version:   keyInfoVersion,
+		Mode:      key.mode,
+		Identity:  key.Identity,
+		Recipient: key.Recipient,
+	}
+}
+
+// keyHeader returns a new header for a store keyed with key
+func keyHeader(key *Key) *KeyInfo {
+	if key.info != nil {
+		info := *key.info
+		return &info
+	}
+	return providerKeyInfo(key)
+}
+
+// keyCheck returns a short fingerprint of key material. It is not enough
+// to recover the material or derive a store key from.
+func keyCheck(purpose, value string) string {
+	hash := sha256.Sum256([]byte("alex-" + purpose + "-check-v1:" + value))
+	return hex.EncodeToString(hash[:8])
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/portdeveloper/alex/internal/manifest"
+)
+
+// Layer is a store whose secrets apply in a directory of a project
+type Layer struct {
+	// Scope names the layer, see ScopeLabel
+	Scope string
+	Store *Store
+}
+
+// ScopeLabel names a layer of secrets: "global", "group:NAME", "project",
+// or "project:DIR" for the store of a subdirectory. The stores of the
+// project a submodule is checked out in are "parent" and "parent:DIR".
+func ScopeLabel(kind, dir string) string {
+	if dir == "" {
+		return kind
+	}
+	return kind + ":" + dir
+}
+
+// ProjectGroups returns the groups the manifest in root lists, in order.
+// Names that are not valid group names are left out, and all groups if
+// the manifest cannot be read; warnings describes what was left out.
+// Without a manifest there are no groups.
+func ProjectGroups(root string) (groups, warnings []string) {
+	m, err := manifest.Load(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []string{fmt.Sprintf("ignoring groups in %s: %v", manifest.FileName, err)}
+	}
+	for _, name := range m.Groups {
+		if err := ValidateGroupName(name); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", manifest.FileName, err))
+			continue
+		}
+		groups = append(groups, name)
+	}
+	return groups, warnings
+}
+
+// OpenLayers opens the global store and every store whose secrets 'alex
+// run' injects in the directory Dir of project, from the least to the
+// most specific: the groups the project's manifest lists, in order, then
+// the stores of the parent project of a submodule, the project's own
+// store and the store of each directory down to Dir. Stores without
+// secrets are left out, and so are groups that are not on this machine;
+// warnings describes those and the manifest's invalid entries.
+func (u *Unlocker) OpenLayers(project Project) (layers []Layer, warnings []string, err error) {
+	global, err := u.OpenGlobal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening global store: %w", err)
+	}
+	layers = []Layer{{Scope: "global", Store: global}}
+
+	groups, warnings := ProjectGroups(project.Root)
+	for _, name := range groups {
+		if !GroupExists(name) {
+			warnings = append(warnings, fmt.Sprintf("group %s is not on this machine (create it with 'alex group create %s')", name, name))
+			continue
+		}
+		dir, err := GroupDir(name)
+		if err != nil || !StoreExists(dir) {
+			continue
+		}
+		store, err := u.OpenGroup(name)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("opening group %s: %w", name, err)
+		}
+		layers = append(layers, Layer{Scope: ScopeLabel("group", name), Store: store})
+	}
+
+	projectsDir, err := GetProjectsDir()
+	if err != nil {
+		return nil, warnings, fmt.Errorf("opening project store: %w", err)
+	}
+	inherited := 0
+	if project.Parent != nil {
+		inherited = len(project.Parent.Layers())
+	}
+	for i, info := range project.Layers() {
+		if !StoreExists(filepath.Join(projectsDir, info.ID())) {
+			continue
+		}
+		store, err := u.OpenProjectScope(info)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("opening project store: %w", err)
+		}
+		kind := "project"
+		if i < inherited {
+			kind = "parent"
+		}
+		layers = append(layers, Layer{Scope: ScopeLabel(kind, info.Dir), Store: store})
+	}
+	return layers, warnings, nil
+}
@@ -3,6 +3,7 @@ package secrets
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
@@ -12,34 +13,107 @@ import (
 // MachineIDResult holds the machine ID and metadata about how it was obtained
 type MachineIDResult struct {
 	ID           string
-	UsedFallback bool // true if hostname+user fallback was used (weaker security)
+	UsedFallback bool   // true if hostname+user fallback was used (weaker security)
+	Source       string // where the ID came from, for diagnostics
+	LowEntropy   bool   // true if the raw ID looks too short or repetitive to be unique
 }
 
 // GetMachineID returns a unique identifier for this machine
 // Used to derive encryption key when no passphrase is set
 func GetMachineID() (*MachineIDResult, error) {
-	var id string
+	var id, source string
 	var usedFallback bool
 
 	switch runtime.GOOS {
 	case "darwin":
 		id, usedFallback = getMacOSMachineID()
+		source = "macOS hardware UUID"
 	case "linux":
-		id, usedFallback = getLinuxMachineID()
+		id, source, usedFallback = getLinuxMachineID()
 	default:
 		// Fallback: use hostname + user
 		id = getFallbackID()
 		usedFallback = true
 	}
+	if usedFallback {
+		source = "hostname + username"
+	}
 
-	// Hash the ID to normalize length and add some obscurity
-	hash := sha256.Sum256([]byte(id + "alex-salt-v1"))
 	return &MachineIDResult{
-		ID:           hex.EncodeToString(hash[:]),
+		ID:           hashMachineID(id),
 		UsedFallback: usedFallback,
+		Source:       source,
+		LowEntropy:   !usedFallback && isLowEntropyID(id),
 	}, nil
 }
 
+// MachineIDFromFile reads a raw machine ID from a file, such as a copy of
+// an old /etc/machine-id, to unlock stores keyed before the ID changed
+func MachineIDFromFile(path string) (*MachineIDResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return nil, fmt.Errorf("no machine ID in %s", path)
+	}
+	return &MachineIDResult{ID: hashMachineID(id), Source: path, LowEntropy: isLowEntropyID(id)}, nil
+}
+
+// hashMachineID normalizes the length of a raw ID and adds some obscurity
+func hashMachineID(id string) string {
+	hash := sha256.Sum256([]byte(id + "alex-salt-v1"))
+	return hex.EncodeToString(hash[:])
+}
+
+// isLowEntropyID reports whether a raw machine ID is too short or repetitive
+// to tell machines apart (e.g. an all-zero /etc/machine-id in a container image)
+func isLowEntropyID(id string) bool {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) < 16 {
+		return true
+	}
+	distinct := make(map[rune]bool)
+	for _, r := range id {
+		distinct[r] = true
+	}
+	return len(distinct) < 4
+}
+
+// DetectContainer returns the container runtime alex is running under
+// ("docker", "podman", "kubernetes", "lxc", ...), or "" outside a container.
+// Containers built from the same image usually share /etc/machine-id.
+func DetectContainer() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if name := os.Getenv("container"); name != "" {
+		return name
+	}
+	if data, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		cgroup := string(data)
+		for _, name := range []string{"docker", "kubepods", "containerd", "lxc"} {
+			if strings.Contains(cgroup, name) {
+				if name == "kubepods" {
+					return "kubernetes"
+				}
+				return name
+			}
+		}
+	}
+	return ""
+}
+
 // getMacOSMachineID gets the hardware UUID on macOS
 // Returns (id, usedFallback)
 func getMacOSMachineID() (string, bool) {
@@ -64,17 +138,19 @@ func getMacOSMachineID() (string, bool) {
 }
 
 // getLinuxMachineID reads /etc/machine-id
-// Returns (id, usedFallback)
-func getLinuxMachineID() (string, bool) {
-	data, err := os.ReadFile("/etc/machine-id")
+// Returns (id, source, usedFallback)
+func getLinuxMachineID() (string, string, bool) {
+	source := "/etc/machine-id"
+	data, err := os.ReadFile(source)
 	if err != nil {
 		// Try /var/lib/dbus/machine-id as fallback
-		data, err = os.ReadFile("/var/lib/dbus/machine-id")
+		source = "/var/lib/dbus/machine-id"
+		data, err = os.ReadFile(source)
 		if err != nil {
-			return getFallbackID(), true
+			return getFallbackID(), "", true
 		}
 	}
-	return strings.TrimSpace(string(data)), false
+	return strings.TrimSpace(string(data)), source, false
 }
 
 // getFallbackID creates an ID from hostname and username
@@ -103,7 +179,8 @@ type PassphraseResult struct {
 }
 
 // DerivePassphrase returns the passphrase to use
-// If passphrase is empty, derives from machine ID
+// If passphrase is empty, derives from machine ID alone. Stores opened
+// through an Unlocker combine the machine ID with the install secret.
 func DerivePassphrase(passphrase string) (*PassphraseResult, error) {
 	if passphrase != "" {
 		return &PassphraseResult{Passphrase: passphrase, UsedFallback: false}, nil
@@ -0,0 +1,294 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const masterFileVersion = 1
+
+// masterFile is ~/.alex/master-<mode>.json. It holds an X25519 identity
+// encrypted with the scrypt key of machine or passphrase stores. Those
+// stores wrap their data key to the identity instead of to the scrypt key
+// itself, so unlocking any number of them costs one scrypt run.
+type masterFile struct {
+	Version int     `json:"version"`
+	Mode    KeyMode `json:"mode"`
+	// Recipient is the public key; headers of stores keyed with the master
+	// name it in their Master field
+	Recipient string `json:"recipient"`
+	// Identity is the private key, encrypted with the scrypt key
+	Identity []byte `json:"identity"`
+}
+
+// errMasterInUse indicates the master of a mode is encrypted with another
+// key than the one given, and stores still use it
+var errMasterInUse = errors.New("master key is encrypted with another key")
+
+func masterPath(mode KeyMode) (string, error) {
+	dir, err := GetGlobalDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "master-"+string(mode)+".json"), nil
+}
+
+// readMasterFile reads the master of mode, or nil if it has none
+func readMasterFile(mode KeyMode) (*masterFile, error) {
+	path, err := masterPath(mode)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f masterFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w (invalid master key %s): %v", ErrCorruptedStore, path, err)
+	}
+	return &f, nil
+}
+
+// masterKey returns the key of a master identity. info is the header of
+// stores keyed with it, based on the header of the scrypt key.
+func masterKey(mode KeyMode, identity *age.X25519Identity, base *KeyInfo) *Key {
+	info := *base
+	info.Master = identity.Recipient().String()
+	info.Name, info.Slots = "", nil
+	return &Key{mode: mode, recipient: identity.Recipient(), identity: identity, info: &info}
+}
+
+// decrypt unwraps the master identity with the scrypt key
+func (f *masterFile) decrypt(base *Key) (*age.X25519Identity, error) {
+	plain, err := decryptWith(f.Identity, base.identity)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(string(plain)))
+	if err != nil {
+		return nil, fmt.Errorf("%w (invalid master key): %v", ErrCorruptedStore, err)
+	}
+	if identity.Recipient().String() != f.Recipient {
+		return nil, fmt.Errorf("%w (master key doesn't match its public key)", ErrCorruptedStore)
+	}
+	return identity, nil
+}
+
+// cachedMaster returns the unlocked master key with the public key
+// recipient, if the Unlocker has one
+func (u *Unlocker) cachedMaster(recipient string) *Key {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.masters[recipient]
+}
+
+func (u *Unlocker) rememberMaster(key *Key) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.masters == nil {
+		u.masters = make(map[string]*Key)
+	}
+	u.masters[key.info.Master] = key
+}
+
+// unlockKey returns the key that unwraps the primary key of a store keyed
+// with base: the master key named in the store's header, unlocked with
+// base, or base itself for stores without one
+func (u *Unlocker) unlockKey(base *Key, baseInfo, recorded *KeyInfo, slot string) (*Key, error) {
+	if slot != "" || recorded == nil || recorded.Master == "" {
+		return base, nil
+	}
+	if key := u.cachedMaster(recorded.Master); key != nil {
+		return key, nil
+	}
+	f, err := readMasterFile(recorded.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil || f.Recipient != recorded.Master {
+		path, _ := masterPath(recorded.Mode)
+		return nil, fmt.Errorf("%w (master key %s is missing or was replaced)", ErrCorruptedStore, path)
+	}
+	identity, err := f.decrypt(base)
+	if err != nil {
+		return nil, err
+	}
+	key := masterKey(recorded.Mode, identity, baseInfo)
+	u.rememberMaster(key)
+	return key, nil
+}
+
+// withMaster returns the master key of mode for stores keyed with base,
+// creating it if there is none. It returns base itself if the master of
+// mode belongs to another key that stores still use, or if it cannot be
+// created, so callers can always fall back to keying stores directly.
+func (u *Unlocker) withMaster(mode KeyMode, base *Key, baseInfo *KeyInfo) *Key {
+	key, err := u.masterFor(mode, base, baseInfo)
+	if err != nil {
+		return base
+	}
+	return key
+}
+
+func (u *Unlocker) masterFor(mode KeyMode, base *Key, baseInfo *KeyInfo) (*Key, error) {
+	f, err := readMasterFile(mode)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		if key := u.cachedMaster(f.Recipient); key != nil {
+			return key, nil
+		}
+		identity, err := f.decrypt(base)
+		if err == nil {
+			key := masterKey(mode, identity, baseInfo)
+			u.rememberMaster(key)
+			return key, nil
+		}
+		if !errors.Is(err, ErrWrongPassphrase) {
+			return nil, err
+		}
+		// The old master is replaced once no store needs it any more,
+		// e.g. after alex rekey moved every store to a new passphrase
+		if inUse, err := masterInUse(mode, f.Recipient); err != nil || inUse {
+			return nil, errMasterInUse
+		}
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := encryptTo([]byte(identity.String()), base.recipient)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(masterFile{
+		Version:   masterFileVersion,
+		Mode:      mode,
+		Recipient: identity.Recipient().String(),
+		Identity:  wrapped,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path, err := masterPath(mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if f == nil {
+		// Another alex may be creating one too: only one may win
+		err = writeFileExclusive(path, append(data, '\n'))
+		if errors.Is(err, os.ErrExist) {
+			return u.masterFor(mode, base, baseInfo)
+		}
+	} else {
+		err = writeFileAtomic(path, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := masterKey(mode, identity, baseInfo)
+	u.rememberMaster(key)
+	return key, nil
+}
+
+// upgradeToMaster rewraps the data key of a store keyed directly with
+// base to the master key of its mode, so later commands unlock it without
+// another scrypt run. It is best effort: if anything fails the store stays
+// keyed with base.
+func (u *Unlocker) upgradeToMaster(store *Store, base *Key, baseInfo *KeyInfo) {
+	info := store.keyInfo
+	if store.slot != "" || info == nil || info.Master != "" || !StoreExists(store.path) {
+		return
+	}
+	master := u.withMaster(info.Mode, base, baseInfo)
+	if master == base {
+		return
+	}
+
+	upgraded := *info
+	upgraded.Master = master.info.Master
+	if err := store.RekeyWith(master); err != nil {
+		return
+	}
+	if err := WriteKeyInfo(store.path, &upgraded); err != nil {
+		store.RekeyWith(base)
+		return
+	}
+	store.keyInfo = &upgraded
+}
+
+// masterInUse reports whether the primary key of any store is the master
+// key with public key recipient
+func masterInUse(mode KeyMode, recipient string) (bool, error) {
+	globalDir, err := GetGlobalDir()
+	if err != nil {
+		return false, err
+	}
+	projectsDir, err := GetProjectsDir()
+	if err != nil {
+		return false, err
+	}
+	ids, err := ListProjectIDs()
+	if err != nil {
+		return false, err
+	}
+	dirs := []string{globalDir}
+	for _, id := range ids {
+		dirs = append(dirs, filepath.Join(projectsDir, id))
+	}
+	groups, err := ListGroups()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range groups {
+		dir, _ := GroupDir(name)
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		info, err := ReadKeyInfo(dir)
+		if err != nil {
+			return false, err
+		}
+		if info != nil && info.Mode == mode && info.Master == recipient {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// writeFileExclusive writes a new file at path, failing with os.ErrExist
+// if there already is one. The file appears complete or not at all.
+func writeFileExclusive(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), path)
+}
@@ -0,0 +1,250 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// projectDir returns the directory of a project store in the isolated home
+func projectDir(t testing.TB, id string) string {
+	t.Helper()
+	dir, err := GetProjectsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, id)
+}
+
+func TestNewStoresShareMasterKey(t *testing.T) {
+	isolateHome(t)
+
+	u := &Unlocker{}
+	for _, id := range []string{"aaaaaaaaaaaa", "bbbbbbbbbbbb"} {
+		store, err := u.Open(projectDir(t, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set("KEY", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, _ := ReadKeyInfo(projectDir(t, "aaaaaaaaaaaa"))
+	b, _ := ReadKeyInfo(projectDir(t, "bbbbbbbbbbbb"))
+	if a == nil || a.Master == "" || a.Master != b.Master {
+		t.Fatalf("headers = %+v, %+v, want the same master key", a, b)
+	}
+	if a.Mode != KeyModeMachine || a.MachineCheck == "" {
+		t.Errorf("header = %+v, want a machine header", a)
+	}
+
+	// A new process unlocks the master once and reuses it
+	fresh := &Unlocker{}
+	for _, id := range []string{"aaaaaaaaaaaa", "bbbbbbbbbbbb"} {
+		store, err := fresh.Open(projectDir(t, id))
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", id, err)
+		}
+		if value, _, _ := store.Get("KEY"); value != id {
+			t.Errorf("Get() = %q, want %q", value, id)
+		}
+	}
+	if len(fresh.masters) != 1 {
+		t.Errorf("unlocked %d master keys, want 1", len(fresh.masters))
+	}
+}
+
+func TestDirectStoreMovesToMasterKey(t *testing.T) {
+	isolateHome(t)
+	dir := projectDir(t, "aaaaaaaaaaaa")
+
+	legacy, err := NewStoreAt("hunter2", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyInfo(dir, &KeyInfo{Version: keyInfoVersion, Mode: KeyModePassphrase}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&Unlocker{Passphrase: "hunter2"}).Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := ReadKeyInfo(dir)
+	if info == nil || info.Master == "" {
+		t.Fatalf("header = %+v, want a master key", info)
+	}
+	if _, err := NewStoreAt("hunter2", dir); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("opening with the passphrase directly error = %v, want ErrWrongPassphrase", err)
+	}
+
+	reopened, err := (&Unlocker{Passphrase: "hunter2"}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() after the move error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	wrong := func(string) (string, error) { return "wrong", nil }
+	if _, err := (&Unlocker{Prompt: wrong}).Open(dir); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Open() with a wrong passphrase error = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestMasterKeyOfOtherPassphrase(t *testing.T) {
+	isolateHome(t)
+	first, second := projectDir(t, "aaaaaaaaaaaa"), projectDir(t, "bbbbbbbbbbbb")
+
+	store, err := (&Unlocker{PreferPassphrase: true, Passphrase: "one"}).Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "1"); err != nil {
+		t.Fatal(err)
+	}
+	store, err = (&Unlocker{PreferPassphrase: true, Passphrase: "two"}).Open(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The master belongs to "one", which the first store still uses
+	if info, _ := ReadKeyInfo(second); info == nil || info.Master != "" {
+		t.Errorf("second header = %+v, want no master key", info)
+	}
+	if _, err := (&Unlocker{Passphrase: "two"}).Open(second); err != nil {
+		t.Errorf("Open() of the second store error = %v", err)
+	}
+
+	// Once nothing uses it, the master is replaced by one for "two"
+	store, err = (&Unlocker{Passphrase: "one"}).Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := PassphraseKey("two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey(store, key); err != nil {
+		t.Fatal(err)
+	}
+	u := &Unlocker{Passphrase: "two"}
+	for _, dir := range []string{first, second} {
+		if _, err := u.Open(dir); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+	}
+	a, _ := ReadKeyInfo(first)
+	b, _ := ReadKeyInfo(second)
+	if a.Master == "" || a.Master != b.Master {
+		t.Errorf("headers = %+v, %+v, want both on the new master key", a, b)
+	}
+}
+
+func TestMissingMasterKey(t *testing.T) {
+	isolateHome(t)
+	dir := projectDir(t, "aaaaaaaaaaaa")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := masterPath(KeyModeMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrCorruptedStore) {
+		t.Errorf("Open() without the master key error = %v, want ErrCorruptedStore", err)
+	}
+}
+
+// BenchmarkRunUnlock measures what alex run pays to unlock its stores: a
+// new Unlocker opening the global store and one project store, with 1 and
+// with 50 project stores on disk.
+func BenchmarkRunUnlock(b *testing.B) {
+	for _, projects := range []int{1, 50} {
+		b.Run(fmt.Sprintf("projects=%d", projects), func(b *testing.B) {
+			isolateHome(b)
+			setup := &Unlocker{}
+			global, err := setup.OpenGlobal()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := global.Set("GLOBAL", "value"); err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < projects; i++ {
+				store, err := setup.Open(projectDir(b, fmt.Sprintf("%012x", i)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := store.Set("KEY", "value"); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				u := &Unlocker{}
+				global, err := u.OpenGlobal()
+				if err != nil {
+					b.Fatal(err)
+				}
+				project, err := u.Open(projectDir(b, fmt.Sprintf("%012x", projects-1)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				globalValues, err := global.GetAll()
+				if err != nil {
+					b.Fatal(err)
+				}
+				projectValues, err := project.GetAll()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(globalValues)+len(projectValues) != 2 {
+					b.Fatal("missing secrets")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkOpenAllStores measures commands that unlock every store, such
+// as doctor and backup, with 50 project stores
+func BenchmarkOpenAllStores(b *testing.B) {
+	isolateHome(b)
+	setup := &Unlocker{}
+	for i := 0; i < 50; i++ {
+		store, err := setup.Open(projectDir(b, fmt.Sprintf("%012x", i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := store.Set("KEY", "value"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u := &Unlocker{}
+		for j := 0; j < 50; j++ {
+			if _, err := u.Open(projectDir(b, fmt.Sprintf("%012x", j))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
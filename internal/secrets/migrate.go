@@ -0,0 +1,249 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+)
+
+// migration upgrades a store file from one format version to the next
+type migration struct {
+	from        int
+	description string
+	apply       func(data []byte, key *Key) ([]byte, error)
+}
+
+// migrations are applied in order to bring a store file to CurrentFormat.
+// Each one takes version from to from+1.
+var migrations = []migration{
+	{
+		from:        formatV1,
+		description: "split secrets into an index and a values section under a per-store data key",
+		apply:       migrateV1ToV2,
+	},
+}
+
+// MigrationStatus describes the format of a store file
+type MigrationStatus struct {
+	Dir     string
+	Version int
+	// Pending describes the migrations the next unlock will apply
+	Pending []string
+	// Backups are the pre-migration copies kept next to the store
+	Backups []string
+}
+
+// CheckMigration reports the format of the store in dir without decrypting it
+func CheckMigration(dir string) (*MigrationStatus, error) {
+	data, err := os.ReadFile(filepath.Join(dir, secretsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Dir: dir, Version: FormatVersion(data)}
+	for _, m := range migrations {
+		if m.from >= status.Version {
+			status.Pending = append(status.Pending, fmt.Sprintf("v%d → v%d: %s", m.from, m.from+1, m.description))
+		}
+	}
+	status.Backups, err = migrationBackups(dir)
+	return status, err
+}
+
+// RollbackMigration restores the oldest pre-migration backup of the store
+// in dir and removes the other backups. Secrets changed since the migration
+// are lost. The key header describes the migrated file, not the backup, so
+// it is removed too: the restored store opens like one from before key
+// headers existed, and is migrated again when this version opens it. A
+// machine-keyed store whose backup was moved to the install secret key
+// keeps a header saying so, without the slots and master key of the
+// migrated file. Returns the format version that was restored.
+func RollbackMigration(dir string) (int, error) {
+	backups, err := migrationBackups(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(backups) == 0 {
+		return 0, fmt.Errorf("no migration backup in %s", dir)
+	}
+
+	oldest := backups[0]
+	data, err := os.ReadFile(oldest)
+	if err != nil {
+		return 0, err
+	}
+	info, err := ReadKeyInfo(dir)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(oldest, filepath.Join(dir, secretsFile)); err != nil {
+		return 0, err
+	}
+	if info != nil && info.Mode == KeyModeMachine && info.MachineKeyVersion >= machineKeyV2 {
+		restored := *info
+		restored.Slots, restored.Master = nil, ""
+		if err := WriteKeyInfo(dir, &restored); err != nil {
+			return 0, fmt.Errorf("writing key header: %w", err)
+		}
+	} else if err := os.Remove(filepath.Join(dir, keyInfoFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("removing key header: %w", err)
+	}
+	for _, backup := range backups[1:] {
+		os.Remove(backup)
+	}
+	return FormatVersion(data), nil
+}
+
+// migrateStoreFile upgrades store file data to CurrentFormat, keeping a
+// backup of the original, and returns the migrated data
+func migrateStoreFile(dir string, data []byte, key *Key) ([]byte, error) {
+	version := FormatVersion(data)
+	original := version
+
+	migrated := data
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		next, err := m.apply(migrated, key)
+		if err != nil {
+			return nil, err
+		}
+		migrated, version = next, m.from+1
+	}
+	if version != CurrentFormat {
+		return nil, fmt.Errorf("no migration from store format v%d", version)
+	}
+
+	backup := migrationBackupPath(dir, original)
+	if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			return nil, fmt.Errorf("writing migration backup: %w", err)
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(dir, secretsFile), migrated, 0600); err != nil {
+		return nil, fmt.Errorf("writing migrated store: %w", err)
+	}
+	return migrated, nil
+}
+
+func migrateV1ToV2(data []byte, key *Key) ([]byte, error) {
+	// v1 files are encrypted directly with the store key
+	plain, err := decryptWith(data, key.identity)
+	if err != nil {
+		return nil, err
+	}
+	var old map[string]Secret
+	if err := json.Unmarshal(plain, &old); err != nil {
+		return nil, fmt.Errorf("%w (invalid JSON): %v", ErrCorruptedStore, err)
+	}
+
+	values := make(map[string]string, len(old))
+	for name, secret := range old {
+		values[name] = secret.Value
+	}
+
+	dataKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	keySection, err := wrapDataKey(dataKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return sealStore(keySection, nil, dataKey, old, values)
+}
+
+// migrationBackupPath is where the pre-migration copy of a version is kept
+func migrationBackupPath(dir string, version int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.v%d.bak", secretsFile, version))
+}
+
+// WeakBackups returns the migration backups of the store in dir that a
+// key derived from the machine ID alone still unlocks, which anyone who
+// can read /etc/machine-id can derive: those of a machine-keyed store that
+// has since moved to a key with the install secret, where moving the
+// backup along failed
+func WeakBackups(dir string) ([]string, error) {
+	info, err := ReadKeyInfo(dir)
+	if err != nil || info == nil || info.Mode != KeyModeMachine || info.MachineKeyVersion < machineKeyV2 {
+		return nil, err
+	}
+	backups, err := migrationBackups(dir)
+	if err != nil || len(backups) == 0 {
+		return nil, err
+	}
+	machine, err := GetMachineID()
+	if err != nil {
+		return nil, err
+	}
+	legacyKey, err := PassphraseKey(machine.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var weak []string
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := legacyKey.Decrypt(data); err == nil {
+			weak = append(weak, backup)
+		}
+	}
+	return weak, nil
+}
+
+// rekeyMigrationBackups re-encrypts the migration backups of the store in
+// dir from key from to key to, so that rolling back restores a store the
+// current key opens. A backup that cannot be re-encrypted is removed
+// instead of being left keyed with from; failures are left for alex doctor
+// to report.
+func rekeyMigrationBackups(dir string, from, to *Key) {
+	backups, _ := migrationBackups(dir)
+	for _, backup := range backups {
+		if err := rekeyMigrationBackup(backup, from, to); err != nil {
+			os.Remove(backup)
+		}
+	}
+}
+
+func rekeyMigrationBackup(path string, from, to *Key) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// v1 files are encrypted directly with the store key; no other format
+	// has backups yet
+	if version := FormatVersion(data); version != formatV1 {
+		return fmt.Errorf("cannot rekey a store format v%d backup", version)
+	}
+	plain, err := from.Decrypt(data)
+	if err != nil {
+		return err
+	}
+	sealed, err := to.Encrypt(plain)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, sealed, 0600)
+}
+
+// migrationBackups returns the backups in dir, oldest format first
+func migrationBackups(dir string) ([]string, error) {
+	var backups []string
+	for version := formatV1; version < CurrentFormat; version++ {
+		path := migrationBackupPath(dir, version)
+		if _, err := os.Stat(path); err == nil {
+			backups = append(backups, path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return backups, nil
+}
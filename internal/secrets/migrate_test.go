@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeV1Store writes a store file in the original bare-age format
+func writeV1Store(t *testing.T, dir, passphrase string, values map[string]string) {
+	t.Helper()
+	old := make(map[string]Secret)
+	for name, value := range values {
+		old[name] = Secret{Value: value, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	}
+	plain, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypt(plain, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, secretsFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateV1Store(t *testing.T) {
+	dir := t.TempDir()
+	writeV1Store(t, dir, "pass", map[string]string{"API_KEY": "secret-value"})
+
+	status, err := CheckMigration(dir)
+	if err != nil {
+		t.Fatalf("CheckMigration() error = %v", err)
+	}
+	if status.Version != formatV1 || len(status.Pending) != 1 {
+		t.Fatalf("CheckMigration() = %+v, want v1 with one pending migration", status)
+	}
+
+	if _, err := NewStoreAt("wrong", dir); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("opening with wrong passphrase: %v", err)
+	}
+	if status, _ := CheckMigration(dir); status.Version != formatV1 || len(status.Backups) != 0 {
+		t.Fatalf("failed unlock touched the store: %+v", status)
+	}
+
+	store, err := NewStoreAt("pass", dir)
+	if err != nil {
+		t.Fatalf("NewStoreAt() error = %v", err)
+	}
+	if value, ok, err := store.Get("API_KEY"); err != nil || !ok || value != "secret-value" {
+		t.Errorf("Get() after migration = %q, %v, %v", value, ok, err)
+	}
+
+	status, _ = CheckMigration(dir)
+	if status.Version != CurrentFormat || len(status.Pending) != 0 || len(status.Backups) != 1 {
+		t.Fatalf("after migration = %+v, want current format with a backup", status)
+	}
+
+	// A header written for the migrated file, e.g. by a later rekey
+	if err := WriteKeyInfo(dir, &KeyInfo{Version: keyInfoVersion, Mode: KeyModePassphrase}); err != nil {
+		t.Fatal(err)
+	}
+	version, err := RollbackMigration(dir)
+	if err != nil || version != formatV1 {
+		t.Fatalf("RollbackMigration() = %d, %v", version, err)
+	}
+	if info, err := ReadKeyInfo(dir); info != nil || err != nil {
+		t.Errorf("key header after rollback = %+v, %v, want none", info, err)
+	}
+	if status, _ := CheckMigration(dir); status.Version != formatV1 || len(status.Backups) != 0 {
+		t.Errorf("after rollback = %+v, want v1 without backups", status)
+	}
+	if _, err := NewStoreAt("pass", dir); err != nil {
+		t.Errorf("migrating the restored store again error = %v", err)
+	}
+}
+
+func TestStoreListSkipsValues(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreAt("pass", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("API_KEY", "secret-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the values section: the index must still be readable
+	data, _ := os.ReadFile(filepath.Join(dir, secretsFile))
+	c, err := parseContainer(data)
+	if err != nil {
+		t.Fatalf("parseContainer() error = %v", err)
+	}
+	c.set(sectionValues, []byte("garbage"))
+	if err := os.WriteFile(filepath.Join(dir, secretsFile), c.encode(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewStoreAt("pass", dir)
+	if err != nil {
+		t.Fatalf("NewStoreAt() error = %v", err)
+	}
+	if _, ok := reopened.List()["API_KEY"]; !ok {
+		t.Error("List() lost the secret")
+	}
+	if err := reopened.Verify(); err == nil {
+		t.Error("Verify() accepted a corrupted values section")
+	}
+	if _, _, err := reopened.Get("API_KEY"); err == nil {
+		t.Error("Get() hid a corrupted values section")
+	}
+	if values, err := reopened.GetAll(); err == nil {
+		t.Errorf("GetAll() = %v, want an error for a corrupted values section", values)
+	}
+}
+
+func TestParseContainerRejectsTruncation(t *testing.T) {
+	c := &container{version: CurrentFormat}
+	c.set(sectionKey, []byte("key data"))
+	c.set(sectionIndex, []byte("index\ndata"))
+	data := c.encode()
+
+	parsed, err := parseContainer(data)
+	if err != nil {
+		t.Fatalf("parseContainer() error = %v", err)
+	}
+	if got, _ := parsed.get(sectionIndex); string(got) != "index\ndata" {
+		t.Errorf("index section = %q", got)
+	}
+
+	if _, err := parseContainer(data[:len(data)-3]); !errors.Is(err, ErrCorruptedStore) {
+		t.Errorf("truncated container error = %v, want ErrCorruptedStore", err)
+	}
+	if FormatVersion([]byte("age-encryption.org/v1\n")) != formatV1 {
+		t.Error("bare age file not detected as v1")
+	}
+}
+
+func TestParseContainerRejectsOversizedLengths(t *testing.T) {
+	header := fmt.Sprintf("%s%d\n", formatMagic, CurrentFormat)
+	for _, section := range []string{
+		"key 9\nkey data\n",
+		"key 1000000000000\nkey data\n",
+		"key 9223372036854775807\nkey data\n",
+		"key 99999999999999999999999\nkey data\n",
+		"key -1\n\n",
+	} {
+		if _, err := parseContainer([]byte(header + section)); !errors.Is(err, ErrCorruptedStore) {
+			t.Errorf("parseContainer(%q) error = %v, want ErrCorruptedStore", section, err)
+		}
+	}
+}
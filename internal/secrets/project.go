@@ -0,0 +1,410 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	projectFile = "project.json"
+	// pinFile in a project's root names the project explicitly
+	pinFile = ".alex-project"
+	// rootMarkerFile marks the root of a project outside git. Like
+	// pinFile, it may name the project, e.g. with a UUID.
+	rootMarkerFile = ".alexroot"
+	// gitMarker is the Marker of git projects
+	gitMarker = ".git"
+)
+
+// workspaceMarkers mark the root of a project outside git, in order of
+// preference when a directory has several
+var workspaceMarkers = []string{rootMarkerFile, pinFile, ".hg", ".jj", ".fslckout", "_FOSSIL_"}
+
+// ProjectInfo records which project a project store belongs to. Project
+// directories are named by a hash of the identifier, so this is the only
+// way back from a store to its project.
+type ProjectInfo struct {
+	// Remote is the URL of the git remote the project is identified by,
+	// if it has one
+	Remote string `json:"remote,omitempty"`
+	// Root is the git root, the marked root outside git, or the directory
+	// alex ran in
+	Root string `json:"root,omitempty"`
+	// Pin is the name in the project's .alex-project or .alexroot file,
+	// if it has one
+	Pin string `json:"pin,omitempty"`
+	// Dir is the subdirectory of the root the store holds secrets for,
+	// slash-separated, or empty for the project's own store
+	Dir string `json:"dir,omitempty"`
+}
+
+// Identifier returns what the project ID is derived from: the pinned
+// name, the canonical remote URL, or the root path if there is neither,
+// followed by "//" and Dir for the store of a subdirectory
+func (p ProjectInfo) Identifier() string {
+	identifier := p.Root
+	if p.Pin != "" {
+		identifier = CanonicalRemote(p.Pin)
+	} else if p.Remote != "" {
+		identifier = CanonicalRemote(p.Remote)
+	}
+	if p.Dir != "" {
+		identifier += "//" + p.Dir
+	}
+	return identifier
+}
+
+// ID returns the project ID of the project, as GetProjectID does
+func (p ProjectInfo) ID() string {
+	return hashIdentifier(p.Identifier())
+}
+
+// hashIdentifier returns the first 12 hex characters of the SHA-256 of
+// identifier, like git short hashes
+func hashIdentifier(identifier string) string {
+	hash := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// ProjectMethod says what a project ID is derived from
+type ProjectMethod string
+
+const (
+	// ProjectByPin derives the ID from the name in .alex-project
+	ProjectByPin ProjectMethod = "pin"
+	// ProjectByRemote derives the ID from the git remote URL, which
+	// survives moving the checkout
+	ProjectByRemote ProjectMethod = "remote"
+	// ProjectByGitRoot derives the ID from the path of the git root
+	ProjectByGitRoot ProjectMethod = "git-root"
+	// ProjectByWorkspaceRoot derives the ID from the path of a root
+	// outside git: a directory with .alexroot, .alex-project or the
+	// checkout of another VCS (Mercurial, Jujutsu, Fossil)
+	ProjectByWorkspaceRoot ProjectMethod = "workspace-root"
+	// ProjectByDirectory derives the ID from the current directory,
+	// outside any repository or marked root
+	ProjectByDirectory ProjectMethod = "directory"
+)
+
+// Project is the resolved identity of a project
+type Project struct {
+	// ID names the project's store directory
+	ID     string        `json:"id"`
+	Method ProjectMethod `json:"method"`
+	// Root is the git root, the marked root outside git, or the directory
+	// alex ran in
+	Root string `json:"root"`
+	// Marker is what marks Root: .git, .alexroot, .alex-project, or
+	// the directory of another VCS such as .hg. It is empty when Root is
+	// just the current directory.
+	Marker string `json:"marker,omitempty"`
+	// Remote is the URL of the git remote the project is identified by,
+	// if it has one
+	Remote string `json:"remote,omitempty"`
+	// Pin is the name in the project's .alex-project or .alexroot file,
+	// if it has one
+	Pin string `json:"pin,omitempty"`
+	// PinFile is the file Pin was read from
+	PinFile string `json:"pin_file,omitempty"`
+	// Dir is the directory alex runs in, relative to Root and
+	// slash-separated, or empty at the root
+	Dir string `json:"dir,omitempty"`
+	// Parent is the project a git submodule is checked out in. Its Dir
+	// is the submodule's path.
+	Parent *Project `json:"parent,omitempty"`
+
+	// legacyIDs are IDs the project's store may have been created under:
+	// by earlier versions, from the raw URL of origin or from the current
+	// directory, or before the project was pinned
+	legacyIDs []string
+}
+
+// InGit reports whether the project is a git repository
+func (p Project) InGit() bool {
+	return p.Marker == gitMarker
+}
+
+// Info returns what is recorded about the project in its own store, the
+// one at its root
+func (p Project) Info() ProjectInfo {
+	return ProjectInfo{Remote: p.Remote, Root: p.Root, Pin: p.Pin}
+}
+
+// newProject resolves the identity of a project from its location and
+// the pin in its root, if any
+func newProject(root, marker, remote string) Project {
+	p := Project{Root: root, Marker: marker, Remote: remote}
+	p.Pin, p.PinFile = readPin(root)
+	switch {
+	case p.Pin != "":
+		p.Method = ProjectByPin
+	case remote != "":
+		p.Method = ProjectByRemote
+	case marker == gitMarker:
+		p.Method = ProjectByGitRoot
+	case marker != "":
+		p.Method = ProjectByWorkspaceRoot
+	default:
+		p.Method = ProjectByDirectory
+	}
+	p.ID = p.Info().ID()
+	return p
+}
+
+// CurrentProject describes the project alex is running in, as
+// ResolveProject finds it
+func CurrentProject() ProjectInfo {
+	return ResolveProject().Info()
+}
+
+// ProjectAt describes the project in dir, e.g. a checkout a restored
+// store should be linked to
+func ProjectAt(dir string) (ProjectInfo, error) {
+	project, err := ResolveProjectAt(dir)
+	if err != nil {
+		return ProjectInfo{}, err
+	}
+	return project.Info(), nil
+}
+
+// ResolveProjectAt returns the project in dir as ResolveProject does for
+// the current directory, with Dir relative to its root, but neither
+// caches it nor moves a legacy store
+func ResolveProjectAt(dir string) (Project, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Project{}, err
+	}
+	if info, err := os.Stat(abs); err != nil {
+		return Project{}, err
+	} else if !info.IsDir() {
+		return Project{}, fmt.Errorf("%s is not a directory", dir)
+	}
+	project, _ := resolveProject(abs)
+	return project, nil
+}
+
+// resolveProject runs git to find the project in dir. It also returns
+// the git directories whose files the result depends on, which are empty
+// outside git.
+func resolveProject(dir string) (Project, gitDirs) {
+	output, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel",
+		"--absolute-git-dir", "--git-common-dir", "--show-superproject-working-tree").Output()
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if err != nil || len(lines) < 3 {
+		return resolveWorkspace(dir), gitDirs{}
+	}
+
+	dirs := gitDirs{git: lines[1], common: lines[2]}
+	if !filepath.IsAbs(dirs.common) {
+		dirs.common = filepath.Join(dir, dirs.common)
+	}
+	root := lines[0]
+	remotes := gitRemotes(dir)
+	p := newProject(root, gitMarker, preferredRemote(remotes, LoadConfig().ProjectRemotes))
+	p.Dir = relativeDir(root, dir)
+	if origin := remotes[defaultRemote]; origin != "" {
+		p.legacyIDs = append(p.legacyIDs, hashIdentifier(origin))
+	}
+	p.addUnpinnedID()
+
+	// A submodule inherits the secrets of the project it is checked out in
+	if len(lines) == 4 {
+		parent, _ := resolveProject(lines[3])
+		parent.Dir = relativeDir(parent.Root, root)
+		p.Parent = &parent
+	}
+	return p, dirs
+}
+
+// resolveWorkspace finds the project in dir outside git: the closest
+// directory at or above dir with one of workspaceMarkers, or dir itself
+func resolveWorkspace(dir string) Project {
+	root, marker := findWorkspace(dir)
+	if root == "" {
+		root = dir
+	}
+	p := newProject(root, marker, "")
+	p.Dir = relativeDir(root, dir)
+	// Earlier versions identified every directory on its own
+	p.legacyIDs = append(p.legacyIDs, hashIdentifier(dir))
+	p.addUnpinnedID()
+	return p
+}
+
+// findWorkspace returns the closest directory at or above dir with one of
+// workspaceMarkers, and the marker. Returns empty strings if there is none.
+func findWorkspace(dir string) (root, marker string) {
+	for {
+		for _, name := range workspaceMarkers {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, name
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// addUnpinnedID adds the ID the project has without its pin to
+// legacyIDs, so pinning a project keeps its store
+func (p *Project) addUnpinnedID() {
+	if p.Pin == "" {
+		return
+	}
+	unpinned := p.Info()
+	unpinned.Pin = ""
+	p.legacyIDs = append(p.legacyIDs, unpinned.ID())
+}
+
+// relativeDir returns dir relative to root, slash-separated, or an empty
+// string if dir is root or not inside it
+func relativeDir(root, dir string) string {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// readPin returns the project name in root/.alex-project, or else in
+// root/.alexroot: the first line that is neither blank nor a # comment,
+// and the name of the file. Returns empty strings if neither file names
+// the project.
+func readPin(root string) (pin, file string) {
+	for _, name := range []string{pinFile, rootMarkerFile} {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				return line, name
+			}
+		}
+	}
+	return "", ""
+}
+
+// adoptLegacyStore moves the store a project has under one of its
+// legacyIDs to its current ID, e.g. after remote URLs became canonical or
+// the project was pinned. Nothing moves if the project already has a
+// store. It is best effort: on failure the project starts with an empty
+// store, as it did before.
+func adoptLegacyStore(p Project) {
+	dir, err := GetProjectsDir()
+	if err != nil || StoreExists(filepath.Join(dir, p.ID)) {
+		return
+	}
+	for _, id := range p.legacyIDs {
+		if id == p.ID || !StoreExists(filepath.Join(dir, id)) {
+			continue
+		}
+		moveProjectStore(filepath.Join(dir, id), p.Info())
+		return
+	}
+}
+
+// ProjectRecord is what project.json in a project store's directory
+// holds: the project and how the store is used
+type ProjectRecord struct {
+	ProjectInfo
+	// Name is a label given with 'alex projects rename'
+	Name string `json:"name,omitempty"`
+	// LastUsed is when the store was last opened for its project. It is
+	// updated at most once per lastUsedResolution.
+	LastUsed time.Time `json:"last_used"`
+}
+
+// ReadProjectRecord reads project.json of the store in dir. Returns nil
+// without an error for stores that never recorded it.
+func ReadProjectRecord(dir string) (*ProjectRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, projectFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record ProjectRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", projectFile, err)
+	}
+	return &record, nil
+}
+
+// WriteProjectRecord writes project.json of the store in dir
+func WriteProjectRecord(dir string, record ProjectRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, projectFile), append(data, '\n'), 0600)
+}
+
+// ReadProjectInfo reads which project the store in dir belongs to.
+// Returns nil without an error for stores that never recorded it.
+func ReadProjectInfo(dir string) (*ProjectInfo, error) {
+	record, err := ReadProjectRecord(dir)
+	if record == nil {
+		return nil, err
+	}
+	return &record.ProjectInfo, nil
+}
+
+// WriteProjectInfo records which project the store in dir belongs to,
+// keeping its name, and marks the store used
+func WriteProjectInfo(dir string, info ProjectInfo) error {
+	record, err := ReadProjectRecord(dir)
+	if err != nil || record == nil {
+		record = &ProjectRecord{}
+	}
+	record.ProjectInfo = info
+	record.LastUsed = time.Now().UTC()
+	return WriteProjectRecord(dir, *record)
+}
+
+// lastUsedResolution is how often opening a store updates its LastUsed,
+// so commands don't rewrite project.json every time
+const lastUsedResolution = time.Hour
+
+// recordProject writes the store's project to its directory once the
+// store has a file, unless it is already recorded, and marks the store
+// used. It is best effort: reading and saving secrets never fail because
+// of it.
+func (s *Store) recordProject() {
+	if s.project == nil {
+		return
+	}
+	if _, err := os.Stat(s.secretsFilePath()); err != nil {
+		// Written with the first secret
+		return
+	}
+	recorded, err := ReadProjectRecord(s.path)
+	if err == nil && (recorded == nil || recorded.ProjectInfo != *s.project ||
+		time.Since(recorded.LastUsed) >= lastUsedResolution) {
+		err = WriteProjectInfo(s.path, *s.project)
+	}
+	if err == nil {
+		s.project = nil
+	}
+}
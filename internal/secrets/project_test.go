@@ -0,0 +1,293 @@
+package secrets
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// git runs a git command in dir
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+}
+
+func TestProjectInfoID(t *testing.T) {
+	remote := ProjectInfo{Remote: "git@github.com:acme/api.git", Root: "/src/api"}
+	moved := ProjectInfo{Remote: "git@github.com:acme/api.git", Root: "/elsewhere/api"}
+	if remote.ID() != moved.ID() {
+		t.Errorf("ID() depends on the root when there is a remote: %s != %s", remote.ID(), moved.ID())
+	}
+	if got := (ProjectInfo{Root: "/src/api"}).ID(); got == remote.ID() || len(got) != 12 {
+		t.Errorf("ID() without a remote = %q", got)
+	}
+}
+
+func TestOpenProjectRecordsProject(t *testing.T) {
+	isolateHome(t)
+	chdir(t, t.TempDir())
+
+	u := &Unlocker{}
+	store, err := u.OpenProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := ReadProjectInfo(store.Dir()); info != nil {
+		t.Errorf("project recorded before the store has secrets: %+v", info)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadProjectInfo(store.Dir())
+	if err != nil || info == nil {
+		t.Fatalf("ReadProjectInfo() = %v, %v", info, err)
+	}
+	if info.ID() != filepath.Base(store.Dir()) {
+		t.Errorf("recorded project %+v has ID %s, store is %s", info, info.ID(), filepath.Base(store.Dir()))
+	}
+}
+
+func TestResolveProjectFollowsGitChanges(t *testing.T) {
+	isolateHome(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+
+	if p := ResolveProject(); p.Method != ProjectByDirectory || p.Root != dir || p.InGit() {
+		t.Fatalf("ResolveProject() outside git = %+v", p)
+	}
+
+	git(t, dir, "init", "-q")
+	byRoot := ResolveProject()
+	if byRoot.Method != ProjectByGitRoot || byRoot.Root != dir {
+		t.Fatalf("ResolveProject() after git init = %+v", byRoot)
+	}
+	if again := ResolveProject(); !reflect.DeepEqual(again, byRoot) {
+		t.Errorf("ResolveProject() again = %+v, want %+v", again, byRoot)
+	}
+
+	git(t, dir, "remote", "add", "origin", "git@github.com:acme/api.git")
+	byRemote := ResolveProject()
+	if byRemote.Method != ProjectByRemote || byRemote.Remote != "git@github.com:acme/api.git" {
+		t.Fatalf("ResolveProject() after adding a remote = %+v", byRemote)
+	}
+	if byRemote.ID == byRoot.ID || byRemote.ID != byRemote.Info().ID() {
+		t.Errorf("ID = %s, want the remote's ID", byRemote.ID)
+	}
+	if GetProjectID() != byRemote.ID || GetProjectRoot() != dir || GetProjectRemote() != byRemote.Remote {
+		t.Errorf("GetProject*() disagree with ResolveProject() = %+v", byRemote)
+	}
+}
+
+func TestProjectEntryStamps(t *testing.T) {
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	project, dirs := resolveProject(dir)
+	entry := newProjectEntry(project, dirs)
+	if entry.config.info == nil || entry.head.info == nil {
+		t.Fatalf("entry = %+v, want config and HEAD stamped", entry)
+	}
+	if !entry.valid(dir) {
+		t.Error("entry invalid before any change")
+	}
+	git(t, dir, "symbolic-ref", "HEAD", "refs/heads/other")
+	if entry.valid(dir) {
+		t.Error("entry still valid after HEAD changed")
+	}
+
+	// git replaces HEAD through a lock file: an edit to a name of the same
+	// length within one mtime tick only changes the inode
+	entry = newProjectEntry(project, dirs)
+	head := filepath.Join(dirs.git, "HEAD")
+	lock := head + ".lock"
+	if err := os.WriteFile(lock, []byte("ref: refs/heads/again\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := entry.head.info.ModTime()
+	if err := os.Chtimes(lock, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(lock, head); err != nil {
+		t.Fatal(err)
+	}
+	if entry.valid(dir) {
+		t.Error("entry still valid after HEAD was replaced")
+	}
+}
+
+func TestResolveProjectForksAndPins(t *testing.T) {
+	isolateHome(t)
+	upstream, fork := t.TempDir(), t.TempDir()
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "remote", "add", "origin", "https://github.com/Acme/API")
+	git(t, fork, "init", "-q")
+	git(t, fork, "remote", "add", "origin", "git@github.com:someone/api.git")
+	git(t, fork, "remote", "add", "upstream", "git@github.com:acme/api.git")
+
+	chdir(t, upstream)
+	want := ResolveProject().ID
+	chdir(t, fork)
+	if ResolveProject().ID == want {
+		t.Fatal("fork shares the store without a remote preference")
+	}
+
+	writeConfig(t, Config{ProjectRemotes: []string{"upstream"}})
+	project, _ := resolveProject(fork)
+	if project.ID != want || project.Method != ProjectByRemote {
+		t.Errorf("fork preferring upstream = %+v, want ID %s", project, want)
+	}
+
+	pin := "# shared with the api repo\ngithub.com/acme/api\n"
+	if err := os.WriteFile(filepath.Join(fork, pinFile), []byte(pin), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, Config{})
+	project = ResolveProject()
+	if project.ID != want || project.Method != ProjectByPin || project.Pin != "github.com/acme/api" {
+		t.Errorf("pinned fork = %+v, want ID %s", project, want)
+	}
+}
+
+func TestResolveProjectAdoptsLegacyStore(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	remote := "git@github.com:acme/api.git"
+	git(t, dir, "init", "-q")
+	git(t, dir, "remote", "add", "origin", remote)
+
+	// Earlier versions hashed the raw URL
+	legacy := projectDir(t, hashIdentifier(remote))
+	store, err := (&Unlocker{}).Open(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	chdir(t, dir)
+	project := ResolveProject()
+	if project.ID == filepath.Base(legacy) {
+		t.Fatal("ID of a canonical remote equals the legacy ID")
+	}
+	if StoreExists(legacy) {
+		t.Error("legacy store still in place")
+	}
+	store, err = (&Unlocker{}).OpenProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	if info, _ := ReadProjectInfo(store.Dir()); info == nil || info.ID() != project.ID {
+		t.Errorf("recorded project = %+v, want ID %s", info, project.ID)
+	}
+}
+
+func TestResolveProjectOutsideGit(t *testing.T) {
+	isolateHome(t)
+	for _, marker := range []string{".hg", ".jj", ".fslckout", "_FOSSIL_", rootMarkerFile} {
+		t.Run(marker, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(root, "src"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if marker == ".hg" || marker == ".jj" {
+				err = os.Mkdir(filepath.Join(root, marker), 0755)
+			} else {
+				err = os.WriteFile(filepath.Join(root, marker), nil, 0644)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			project, _ := resolveProject(filepath.Join(root, "src"))
+			if project.Method != ProjectByWorkspaceRoot || project.Root != root || project.Marker != marker || project.Dir != "src" {
+				t.Errorf("resolveProject() = %+v, want root %s marked by %s", project, root, marker)
+			}
+			if project.InGit() {
+				t.Error("InGit() = true outside git")
+			}
+		})
+	}
+}
+
+func TestResolveProjectRootMarkerSurvivesMoves(t *testing.T) {
+	isolateHome(t)
+	marker := "# generated by alex projects pin\n6f1c0d2e-8b7a-4c3d-9e5f-0a1b2c3d4e5f\n"
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.WriteFile(filepath.Join(dir, rootMarkerFile), []byte(marker), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := resolveProject(first)
+	b, _ := resolveProject(second)
+	if a.Method != ProjectByPin || a.Pin != "6f1c0d2e-8b7a-4c3d-9e5f-0a1b2c3d4e5f" || a.ID != b.ID {
+		t.Errorf("resolveProject() = %+v and %+v, want the same pinned ID", a, b)
+	}
+}
+
+func TestResolveProjectKeepsStoreWhenPinned(t *testing.T) {
+	isolateHome(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".hg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+
+	store, err := (&Unlocker{}).OpenProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	unpinned := ResolveProject().ID
+
+	if err := os.WriteFile(filepath.Join(dir, pinFile), []byte("acme-api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	project := ResolveProject()
+	if project.ID == unpinned || project.Method != ProjectByPin {
+		t.Fatalf("ResolveProject() after pinning = %+v", project)
+	}
+	store, err = (&Unlocker{}).OpenProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get() after pinning = %q, want value", value)
+	}
+}
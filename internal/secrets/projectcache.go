@@ -0,0 +1,137 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// gitDirs are the git directory of a checkout and the common directory
+// it shares with other worktrees, which holds config
+type gitDirs struct {
+	git    string
+	common string
+}
+
+// fileStamp identifies a version of a file without reading it. git
+// replaces config and HEAD through a lock file, so an edit changes the
+// inode even when the mtime is within the same tick; the size catches
+// edits in place.
+type fileStamp struct {
+	// info is nil if the file does not exist
+	info os.FileInfo
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info: info}
+}
+
+// same reports whether s and other stamp the same version of a file
+func (s fileStamp) same(other fileStamp) bool {
+	if s.info == nil || other.info == nil {
+		return s.info == nil && other.info == nil
+	}
+	return os.SameFile(s.info, other.info) &&
+		s.info.ModTime().Equal(other.info.ModTime()) &&
+		s.info.Size() == other.info.Size()
+}
+
+// projectEntry is a resolved project and the state of the files it was
+// resolved from
+type projectEntry struct {
+	project Project
+	dirs    gitDirs
+	config  fileStamp
+	head    fileStamp
+	pin     fileStamp
+	marker  fileStamp
+}
+
+func newProjectEntry(project Project, dirs gitDirs) projectEntry {
+	entry := projectEntry{
+		project: project,
+		dirs:    dirs,
+		pin:     stampFile(filepath.Join(project.Root, pinFile)),
+		marker:  stampFile(filepath.Join(project.Root, rootMarkerFile)),
+	}
+	if project.InGit() {
+		entry.config = stampFile(filepath.Join(dirs.common, "config"))
+		entry.head = stampFile(filepath.Join(dirs.git, "HEAD"))
+	}
+	return entry
+}
+
+// valid reports whether resolving the project in dir again would give
+// the same result
+func (e projectEntry) valid(dir string) bool {
+	if !stampFile(filepath.Join(e.project.Root, pinFile)).same(e.pin) ||
+		!stampFile(filepath.Join(e.project.Root, rootMarkerFile)).same(e.marker) {
+		return false
+	}
+	if !e.project.InGit() {
+		// Valid until a repository or another marker appears around dir
+		if findGitEntry(dir) != "" {
+			return false
+		}
+		root, _ := findWorkspace(dir)
+		if root == "" {
+			root = dir
+		}
+		return root == e.project.Root
+	}
+	return stampFile(filepath.Join(e.dirs.common, "config")).same(e.config) &&
+		stampFile(filepath.Join(e.dirs.git, "HEAD")).same(e.head)
+}
+
+// findGitEntry returns the .git directory or file of the repository
+// containing dir, or an empty string if there is none
+func findGitEntry(dir string) string {
+	for {
+		path := filepath.Join(dir, ".git")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// projectCache holds resolved projects by working directory, so a
+// command runs git once however often it asks for the project
+var projectCache = struct {
+	sync.Mutex
+	entries map[string]projectEntry
+}{entries: make(map[string]projectEntry)}
+
+// ResolveProject returns the project alex is running in. Prefers the name
+// in .alex-project or .alexroot, then the canonical git remote URL
+// (survives moves), then the git root path, then the root outside git
+// marked by .alexroot, .alex-project or another VCS, and then the current
+// directory. The result is cached for the working directory until the
+// repository's config or HEAD, the .alex-project or .alexroot file, or
+// the marked root changes. A store kept under an ID the project had
+// before, e.g. in earlier versions or before it was pinned, is moved to
+// its current ID.
+func ResolveProject() Project {
+	cwd, err := os.Getwd()
+	if err != nil {
+		cwd = "."
+	}
+
+	projectCache.Lock()
+	defer projectCache.Unlock()
+	if entry, ok := projectCache.entries[cwd]; ok && entry.valid(cwd) {
+		return entry.project
+	}
+	project, dirs := resolveProject(cwd)
+	adoptLegacyStore(project)
+	projectCache.entries[cwd] = newProjectEntry(project, dirs)
+	return project
+}
@@ -0,0 +1,283 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoProjectStore indicates no project store matches an ID, name or path
+var ErrNoProjectStore = errors.New("no such project store")
+
+// minIDPrefix is the shortest prefix of a project ID that names its store
+const minIDPrefix = 4
+
+// ProjectStore is a project store on disk and what it records about its
+// project
+type ProjectStore struct {
+	ID  string
+	Dir string
+	// Record is nil for stores that never recorded their project
+	Record *ProjectRecord
+}
+
+// Name returns the name the store was given, if any
+func (p ProjectStore) Name() string {
+	if p.Record == nil {
+		return ""
+	}
+	return p.Record.Name
+}
+
+// LastUsed returns when the store was last opened for its project. For
+// stores without a record it is when the secrets file last changed.
+func (p ProjectStore) LastUsed() time.Time {
+	if p.Record != nil && !p.Record.LastUsed.IsZero() {
+		return p.Record.LastUsed
+	}
+	if info, err := os.Stat(filepath.Join(p.Dir, secretsFile)); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// RepoGone reports whether the directory the project was last seen in no
+// longer exists. Stores without a record are never considered gone.
+func (p ProjectStore) RepoGone() bool {
+	if p.Record == nil || p.Record.Root == "" {
+		return false
+	}
+	_, err := os.Stat(p.Record.Root)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// Project resolves the project of the store in the checkout it last
+// recorded, so the project's groups and parent apply as they do there.
+// Reports false for the store of a subdirectory, or if the checkout is
+// gone or no longer resolves to the store, e.g. after its remote changed.
+func (p ProjectStore) Project() (Project, bool) {
+	if p.Record == nil || p.Record.Root == "" || p.Record.Dir != "" {
+		return Project{}, false
+	}
+	project, err := ResolveProjectAt(p.Record.Root)
+	if err != nil || project.ID != p.ID {
+		return Project{}, false
+	}
+	return project, true
+}
+
+// ListProjectStores returns every project store that contains secrets,
+// sorted by ID
+func ListProjectStores() ([]ProjectStore, error) {
+	ids, err := ListProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := GetProjectsDir()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	stores := make([]ProjectStore, 0, len(ids))
+	for _, id := range ids {
+		store := ProjectStore{ID: id, Dir: filepath.Join(dir, id)}
+		if store.Record, err = ReadProjectRecord(store.Dir); err != nil {
+			return nil, fmt.Errorf("project store %s: %w", id, err)
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
+// FindProjectStore returns the project store ref names: a project ID, a
+// unique prefix of one, a name given with RenameProjectStore, or the path
+// of a checkout of the project
+func FindProjectStore(ref string) (ProjectStore, error) {
+	stores, err := ListProjectStores()
+	if err != nil {
+		return ProjectStore{}, err
+	}
+	var prefixed []ProjectStore
+	for _, store := range stores {
+		if store.ID == ref || (ref != "" && store.Name() == ref) {
+			return store, nil
+		}
+		if len(ref) >= minIDPrefix && strings.HasPrefix(store.ID, ref) {
+			prefixed = append(prefixed, store)
+		}
+	}
+	if len(prefixed) == 1 {
+		return prefixed[0], nil
+	}
+	if len(prefixed) > 1 {
+		return ProjectStore{}, fmt.Errorf("%q matches %d project stores: give more of the ID", ref, len(prefixed))
+	}
+
+	if info, err := os.Stat(ref); err == nil && info.IsDir() {
+		project, err := ProjectAt(ref)
+		if err != nil {
+			return ProjectStore{}, err
+		}
+		for _, store := range stores {
+			if store.ID == project.ID() {
+				return store, nil
+			}
+		}
+		return ProjectStore{}, fmt.Errorf("%w for %s (project %s)", ErrNoProjectStore, ref, project.ID())
+	}
+	return ProjectStore{}, fmt.Errorf("%w: %s", ErrNoProjectStore, ref)
+}
+
+// LinkProjectStore moves the store with ID id to project, e.g. after the
+// repository moved or its remote changed, so the project finds it again.
+// The stores of the old project's subdirectories move along. It fails if
+// project already has a store with secrets at any of the new IDs.
+func LinkProjectStore(id string, project Project) error {
+	stores, err := ListProjectStores()
+	if err != nil {
+		return err
+	}
+	var linked *ProjectStore
+	for i := range stores {
+		if stores[i].ID == id {
+			linked = &stores[i]
+		}
+	}
+	if linked == nil {
+		return fmt.Errorf("%w: %s", ErrNoProjectStore, id)
+	}
+
+	type move struct {
+		from ProjectStore
+		to   ProjectInfo
+	}
+	moves := []move{{from: *linked, to: project.Info()}}
+	if linked.Record != nil {
+		root := linked.Record.ProjectInfo
+		root.Dir = ""
+		moves[0].to.Dir = linked.Record.Dir
+		for _, store := range stores {
+			if store.ID == id || store.Record == nil || store.Record.Dir == "" {
+				continue
+			}
+			other := store.Record.ProjectInfo
+			other.Dir = ""
+			if other.Identifier() == root.Identifier() {
+				moves = append(moves, move{from: store, to: project.Scope(store.Record.Dir)})
+			}
+		}
+	}
+
+	for _, m := range moves {
+		if m.from.ID != m.to.ID() && StoreExists(filepath.Join(filepath.Dir(m.from.Dir), m.to.ID())) {
+			return fmt.Errorf("this project already has a store (%s): remove its secrets or back it up first", m.to.ID())
+		}
+	}
+	for _, m := range moves {
+		if err := moveProjectStore(m.from.Dir, m.to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveProjectStore moves the store in from to the ID of project and
+// records project there. A store at that ID must not have secrets.
+func moveProjectStore(from string, project ProjectInfo) error {
+	to := filepath.Join(filepath.Dir(from), project.ID())
+	if to != from {
+		// A store without secrets only has its key header and record
+		for _, name := range []string{keyInfoFile, projectFile} {
+			if err := os.Remove(filepath.Join(to, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot replace %s: %w", to, err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return WriteProjectInfo(to, project)
+}
+
+// RenameProjectStore names the store with ID id, so commands can refer to
+// it by name. An empty name removes the name.
+func RenameProjectStore(id, name string) error {
+	if strings.ContainsAny(name, `/\`) || strings.TrimSpace(name) != name {
+		return fmt.Errorf("invalid name %q: no slashes or surrounding spaces", name)
+	}
+	stores, err := ListProjectStores()
+	if err != nil {
+		return err
+	}
+	var target *ProjectStore
+	for i, store := range stores {
+		if store.ID == id {
+			target = &stores[i]
+			continue
+		}
+		if name != "" && (store.Name() == name || store.ID == name) {
+			return fmt.Errorf("%q already names project store %s", name, store.ID)
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrNoProjectStore, id)
+	}
+
+	record := ProjectRecord{}
+	if target.Record != nil {
+		record = *target.Record
+	}
+	record.Name = name
+	return WriteProjectRecord(target.Dir, record)
+}
+
+// RemoveProjectStore deletes the store with ID id and everything in its
+// directory
+func RemoveProjectStore(id string) error {
+	dir, err := GetProjectsDir()
+	if err != nil {
+		return err
+	}
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("%w: %s", ErrNoProjectStore, id)
+	}
+	return os.RemoveAll(filepath.Join(dir, id))
+}
+
+// StoreFiles sorts the entries of the store directory dir into the files
+// of an empty store, which are safe to delete once it holds no secrets,
+// and everything else: backups left by a migration or rekey, a key header
+// with extra key slots, and any file alex did not write
+func StoreFiles(dir string) (store, other []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+			other = append(other, name+"/")
+		case name == keyInfoFile:
+			info, err := ReadKeyInfo(dir)
+			if err != nil || len(info.Slots) > 0 {
+				other = append(other, name)
+				continue
+			}
+			store = append(store, name)
+		case name == secretsFile || name == projectFile:
+			store = append(store, name)
+		default:
+			other = append(other, name)
+		}
+	}
+	return store, other, nil
+}
@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newProjectStore creates the store of project with one secret
+func newProjectStore(t *testing.T, project ProjectInfo) string {
+	t.Helper()
+	dir := projectDir(t, project.ID())
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", project.Identifier()); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteProjectInfo(dir, project); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFindProjectStore(t *testing.T) {
+	isolateHome(t)
+	checkout := t.TempDir()
+	api := ProjectInfo{Remote: "git@github.com:acme/api.git", Root: "/src/api"}
+	web := ProjectInfo{Root: checkout}
+	newProjectStore(t, api)
+	newProjectStore(t, web)
+	if err := RenameProjectStore(api.ID(), "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{api.ID(), api.ID()[:6], "api"} {
+		if store, err := FindProjectStore(ref); err != nil || store.ID != api.ID() {
+			t.Errorf("FindProjectStore(%q) = %s, %v, want %s", ref, store.ID, err, api.ID())
+		}
+	}
+	if store, err := FindProjectStore(checkout); err != nil || store.ID != web.ID() {
+		t.Errorf("FindProjectStore(path) = %s, %v, want %s", store.ID, err, web.ID())
+	}
+	if _, err := FindProjectStore("nope"); !errors.Is(err, ErrNoProjectStore) {
+		t.Errorf("FindProjectStore(nope) error = %v, want ErrNoProjectStore", err)
+	}
+	if _, err := FindProjectStore(api.ID()[:3]); !errors.Is(err, ErrNoProjectStore) {
+		t.Errorf("FindProjectStore() with a short prefix error = %v, want ErrNoProjectStore", err)
+	}
+
+	if err := RenameProjectStore(web.ID(), "api"); err == nil {
+		t.Error("RenameProjectStore() reused a name")
+	}
+	stores, err := ListProjectStores()
+	if err != nil || len(stores) != 2 {
+		t.Fatalf("ListProjectStores() = %v, %v", stores, err)
+	}
+	for _, store := range stores {
+		if store.ID == api.ID() && (store.Name() != "api" || store.Record.Remote != api.Remote || store.LastUsed().IsZero()) {
+			t.Errorf("api store = %+v", store)
+		}
+		// Only web's checkout is on this machine
+		project, ok := store.Project()
+		if want := store.ID == web.ID(); ok != want || (ok && project.Root != checkout) {
+			t.Errorf("Project() of %s = %+v, %v; want %v", store.ID, project, ok, want)
+		}
+	}
+}
+
+func TestLinkProjectStore(t *testing.T) {
+	isolateHome(t)
+	old := ProjectInfo{Root: "/src/gone"}
+	oldDir := newProjectStore(t, old)
+	if err := RenameProjectStore(old.ID(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	stores, _ := ListProjectStores()
+	if len(stores) != 1 || !stores[0].RepoGone() {
+		t.Fatalf("stores = %+v, want one whose repo is gone", stores)
+	}
+
+	sub := old
+	sub.Dir = "services/api"
+	newProjectStore(t, sub)
+
+	moved := newProject(t.TempDir(), gitMarker, "")
+	// Opening the new project left a key header behind
+	if _, err := (&Unlocker{}).Open(projectDir(t, moved.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkProjectStore(old.ID(), moved); err != nil {
+		t.Fatalf("LinkProjectStore() error = %v", err)
+	}
+	if _, err := os.Stat(oldDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old store directory still exists: %v", err)
+	}
+	store, err := FindProjectStore("app")
+	if err != nil || store.ID != moved.ID || store.Record.Root != moved.Root || store.RepoGone() {
+		t.Errorf("linked store = %+v, %v", store, err)
+	}
+
+	if !StoreExists(projectDir(t, moved.Scope("services/api").ID())) {
+		t.Error("store of a subdirectory did not move with its project")
+	}
+
+	other := newProjectStore(t, ProjectInfo{Root: "/src/other"})
+	if err := LinkProjectStore(filepath.Base(other), moved); err == nil {
+		t.Error("LinkProjectStore() replaced a store with secrets")
+	}
+
+	if err := RemoveProjectStore(filepath.Base(other)); err != nil {
+		t.Fatal(err)
+	}
+	if StoreExists(other) {
+		t.Error("store still exists after RemoveProjectStore()")
+	}
+}
+
+func TestStoreFiles(t *testing.T) {
+	isolateHome(t)
+	dir := newProjectStore(t, ProjectInfo{Root: "/src/api"})
+
+	files, other, err := StoreFiles(dir)
+	if err != nil || len(other) != 0 || len(files) == 0 {
+		t.Fatalf("StoreFiles() = %v, %v, %v, want only store files", files, other, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, secretsFile+".v1.bak"), []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, other, _ := StoreFiles(dir); len(other) != 1 || other[0] != secretsFile+".v1.bak" {
+		t.Errorf("StoreFiles() other = %v, want the backup", other)
+	}
+}
@@ -0,0 +1,391 @@
+package secrets
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/plugin"
+	"golang.org/x/crypto/ssh"
+)
+
+// Key wraps and unwraps the data key of a store
+type Key struct {
+	mode      KeyMode
+	recipient age.Recipient
+	identity  age.Identity
+	// info is the header of stores keyed with k, if it is not derived
+	// from the fields below
+	info *KeyInfo
+
+	// Identity is the file the key was read from, if any
+	Identity string
+	// Recipient is the public key the data key is wrapped to, if the key
+	// has one that can be written down
+	Recipient string
+}
+
+// Mode returns the key mode of stores keyed with k
+func (k *Key) Mode() KeyMode {
+	return k.mode
+}
+
+// RecipientKey returns a key that can only encrypt, to an age1...
+// recipient or an SSH public key
+func RecipientKey(recipient string) (*Key, error) {
+	recipient = strings.TrimSpace(recipient)
+	if strings.HasPrefix(recipient, "ssh-") {
+		r, err := agessh.ParseRecipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH recipient: %w", err)
+		}
+		return &Key{mode: KeyModeSSH, recipient: r, Recipient: recipient}, nil
+	}
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient: %w", err)
+	}
+	return &Key{mode: KeyModeIdentity, recipient: r, Recipient: recipient}, nil
+}
+
+// Encrypt encrypts data to the key, e.g. for an archive that must not
+// depend on a store
+func (k *Key) Encrypt(data []byte) ([]byte, error) {
+	if k.recipient == nil {
+		return nil, errors.New("key cannot encrypt")
+	}
+	return encryptTo(data, k.recipient)
+}
+
+// Decrypt decrypts data that was encrypted to the key
+func (k *Key) Decrypt(data []byte) ([]byte, error) {
+	if k.identity == nil {
+		return nil, errors.New("key has no identity to decrypt with")
+	}
+	return decryptWith(data, k.identity)
+}
+
+// PassphraseKey returns the key for a passphrase
+func PassphraseKey(passphrase string) (*Key, error) {
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passphrase format: %w", err)
+	}
+	return &Key{mode: KeyModePassphrase, recipient: recipient, identity: identity}, nil
+}
+
+// KeyProvider supplies the key of stores with one key mode. The machine
+// key is not a provider; the Unlocker derives it itself.
+type KeyProvider interface {
+	// Mode is recorded in the key header of stores the provider keys
+	Mode() KeyMode
+	// Key returns the key. label names the store in prompts.
+	Key(label string) (*Key, error)
+}
+
+// ProviderConfig chooses and configures key providers in config.json
+type ProviderConfig struct {
+	// KeyProvider keys new stores: machine, passphrase, identity, ssh or
+	// plugin
+	KeyProvider KeyMode `json:"key_provider,omitempty"`
+	// IdentityFile is an age identity file, as written by age-keygen
+	IdentityFile string `json:"identity_file,omitempty"`
+	// SSHKey is an ssh-ed25519 or ssh-rsa private key
+	SSHKey string `json:"ssh_key,omitempty"`
+	// PluginIdentity is a file holding an AGE-PLUGIN-... identity
+	PluginIdentity string `json:"plugin_identity,omitempty"`
+	// PassphraseCommand prints the passphrase of passphrase-keyed stores
+	PassphraseCommand string `json:"passphrase_command,omitempty"`
+}
+
+// identityPath returns the configured key file for mode
+func (c ProviderConfig) identityPath(mode KeyMode) string {
+	switch mode {
+	case KeyModeIdentity:
+		return c.IdentityFile
+	case KeyModeSSH:
+		return c.SSHKey
+	case KeyModePlugin:
+		return c.PluginIdentity
+	default:
+		return ""
+	}
+}
+
+// passphraseProvider keys passphrase stores with a passphrase from read
+type passphraseProvider struct {
+	read func(label string) (string, error)
+}
+
+func (p *passphraseProvider) Mode() KeyMode {
+	return KeyModePassphrase
+}
+
+func (p *passphraseProvider) Key(label string) (*Key, error) {
+	passphrase, err := p.read(label)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+	return PassphraseKey(passphrase)
+}
+
+// PromptPassphrase asks for the passphrase of each store with prompt
+func PromptPassphrase(prompt func(string) (string, error)) KeyProvider {
+	return &passphraseProvider{read: func(label string) (string, error) {
+		return prompt(fmt.Sprintf("Enter passphrase for %s: ", label))
+	}}
+}
+
+// PassphraseCommand runs command with sh and reads the passphrase from
+// the first line of its output, e.g. from a password manager. The command
+// inherits stdin and stderr so it can prompt itself, and gets the store in
+// $ALEX_STORE.
+func PassphraseCommand(command string) KeyProvider {
+	return &passphraseProvider{read: func(label string) (string, error) {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), "ALEX_STORE="+label)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("passphrase_command failed: %w", err)
+		}
+		return firstLine(string(out)), nil
+	}}
+}
+
+// PassphraseFD reads the passphrase from the first line of file
+// descriptor fd. The descriptor is read once; every store that needs a
+// passphrase gets the same one.
+func PassphraseFD(fd int) KeyProvider {
+	var (
+		once       sync.Once
+		passphrase string
+		readErr    error
+	)
+	return &passphraseProvider{read: func(string) (string, error) {
+		once.Do(func() {
+			f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+			if f == nil {
+				readErr = fmt.Errorf("invalid passphrase file descriptor %d", fd)
+				return
+			}
+			defer f.Close()
+			// NewFile accepts any descriptor number; only using it tells
+			// whether it is open
+			if _, err := f.Stat(); err != nil {
+				readErr = fmt.Errorf("invalid passphrase file descriptor %d: %w", fd, err)
+				return
+			}
+			line, err := bufio.NewReader(f).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				readErr = fmt.Errorf("reading passphrase from fd %d: %w", fd, err)
+				return
+			}
+			passphrase = firstLine(line)
+		})
+		return passphrase, readErr
+	}}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+// fileProvider reads a key from a file once and reuses it
+type fileProvider struct {
+	mode KeyMode
+	path string
+	load func(path string) (*Key, error)
+
+	once sync.Once
+	key  *Key
+	err  error
+}
+
+func (p *fileProvider) Mode() KeyMode {
+	return p.mode
+}
+
+func (p *fileProvider) Key(string) (*Key, error) {
+	p.once.Do(func() {
+		p.key, p.err = p.load(p.path)
+		if p.key != nil {
+			p.key.mode = p.mode
+			p.key.Identity = p.path
+		}
+	})
+	return p.key, p.err
+}
+
+// IdentityFile keys stores with the first X25519 identity in an age
+// identity file, as written by age-keygen
+func IdentityFile(path string) KeyProvider {
+	return &fileProvider{mode: KeyModeIdentity, path: path, load: loadIdentityFile}
+}
+
+func loadIdentityFile(path string) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading identity file: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading identity file %s: %w", path, err)
+	}
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			recipient := x25519.Recipient()
+			return &Key{recipient: recipient, identity: x25519, Recipient: recipient.String()}, nil
+		}
+	}
+	return nil, fmt.Errorf("no age X25519 identity in %s", path)
+}
+
+// SSHKey keys stores with an ssh-ed25519 or ssh-rsa private key, as age
+// does. The passphrase of an encrypted key is asked for with prompt when
+// a store is first unlocked.
+func SSHKey(path string, prompt func(string) (string, error)) KeyProvider {
+	return &fileProvider{mode: KeyModeSSH, path: path, load: func(path string) (*Key, error) {
+		return loadSSHKey(path, prompt)
+	}}
+}
+
+func loadSSHKey(path string, prompt func(string) (string, error)) (*Key, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key: %w", err)
+	}
+
+	var (
+		identity  age.Identity
+		publicKey ssh.PublicKey
+	)
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		publicKey = missing.PublicKey
+		if publicKey == nil {
+			if publicKey, err = readSSHPublicKey(path + ".pub"); err != nil {
+				return nil, fmt.Errorf("SSH key %s is encrypted and its public key is needed: %w", path, err)
+			}
+		}
+		identity, err = agessh.NewEncryptedSSHIdentity(publicKey, pemBytes, func() ([]byte, error) {
+			if prompt == nil {
+				return nil, fmt.Errorf("%w (SSH key %s is encrypted)", ErrPassphraseRequired, path)
+			}
+			passphrase, err := prompt(fmt.Sprintf("Enter passphrase for SSH key %s: ", path))
+			return []byte(passphrase), err
+		})
+	case err != nil:
+		return nil, fmt.Errorf("reading SSH key %s: %w", path, err)
+	default:
+		publicKey = signer.PublicKey()
+		identity, err = agessh.ParseIdentity(pemBytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SSH key %s: %w", path, err)
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+	recipient, err := agessh.ParseRecipient(authorizedKey)
+	if err != nil {
+		return nil, fmt.Errorf("SSH key %s: %w", path, err)
+	}
+	return &Key{recipient: recipient, identity: identity, Recipient: authorizedKey}, nil
+}
+
+func readSSHPublicKey(path string) (ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	return publicKey, err
+}
+
+// AgePlugin keys stores with the AGE-PLUGIN-... identity in a file, such
+// as one written by age-plugin-yubikey. The plugin binary (age-plugin-NAME)
+// must be on PATH; ui handles its prompts and messages. If the file names
+// a recipient in a "# Recipient: age1..." comment, data keys are wrapped
+// to it, otherwise to the identity itself.
+func AgePlugin(path string, ui *plugin.ClientUI) KeyProvider {
+	if ui == nil {
+		ui = &plugin.ClientUI{}
+	}
+	return &fileProvider{mode: KeyModePlugin, path: path, load: func(path string) (*Key, error) {
+		return loadPluginIdentity(path, ui)
+	}}
+}
+
+func loadPluginIdentity(path string, ui *plugin.ClientUI) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin identity: %w", err)
+	}
+
+	var identityLine, recipientLine string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			if label, value, ok := strings.Cut(comment, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "recipient") {
+				recipientLine = strings.TrimSpace(value)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "AGE-PLUGIN-") && identityLine == "" {
+			identityLine = line
+		}
+	}
+	if identityLine == "" {
+		return nil, fmt.Errorf("no AGE-PLUGIN identity in %s", path)
+	}
+
+	identity, err := plugin.NewIdentity(identityLine, ui)
+	if err != nil {
+		return nil, fmt.Errorf("plugin identity in %s: %w", path, err)
+	}
+	key := &Key{recipient: identity.Recipient(), identity: identity}
+	if recipientLine != "" {
+		recipient, err := plugin.NewRecipient(recipientLine, ui)
+		if err != nil {
+			return nil, fmt.Errorf("plugin recipient in %s: %w", path, err)
+		}
+		key.recipient, key.Recipient = recipient, recipientLine
+	}
+	return key, nil
+}
+
+// defaultSSHKey returns the first of the usual SSH keys that exists
+func defaultSSHKey() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range []string{"id_ed25519", "id_rsa"} {
+		path := filepath.Join(homeDir, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
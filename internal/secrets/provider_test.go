@@ -0,0 +1,171 @@
+package secrets
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeConfig writes ~/.alex/config.json
+func writeConfig(t *testing.T, config Config) {
+	t.Helper()
+	dir, err := GetGlobalDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, configFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// roundTrip saves a secret through one Unlocker and reads it through another
+func roundTrip(t *testing.T, dir string, first, second *Unlocker) *Store {
+	t.Helper()
+	store, err := first.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() new store error = %v", err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	reopened, err := second.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	return reopened
+}
+
+func TestIdentityFileProvider(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(path, []byte("# created: today\n"+identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, Config{ProviderConfig: ProviderConfig{KeyProvider: KeyModeIdentity, IdentityFile: path}})
+
+	store := roundTrip(t, dir, &Unlocker{}, &Unlocker{})
+	if store.KeyMode() != KeyModeIdentity {
+		t.Errorf("KeyMode() = %q, want identity", store.KeyMode())
+	}
+	info, _ := ReadKeyInfo(dir)
+	if info.Identity != path || info.Recipient != identity.Recipient().String() {
+		t.Errorf("header = %+v, want identity %s and its recipient", info, path)
+	}
+
+	// A per-store entry in config.json overrides the recorded identity
+	other, _ := age.GenerateX25519Identity()
+	otherPath := filepath.Join(t.TempDir(), "other.txt")
+	os.WriteFile(otherPath, []byte(other.String()+"\n"), 0600)
+	writeConfig(t, Config{Stores: map[string]ProviderConfig{storeName(dir): {IdentityFile: otherPath}}})
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Open() with another identity error = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestSSHKeyProvider(t *testing.T) {
+	isolateHome(t)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte("key-passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := t.TempDir()
+	plainPath := filepath.Join(keyDir, "id_plain")
+	encryptedPath := filepath.Join(keyDir, "id_encrypted")
+	os.WriteFile(plainPath, pem.EncodeToMemory(plain), 0600)
+	os.WriteFile(encryptedPath, pem.EncodeToMemory(encrypted), 0600)
+
+	dir := filepath.Join(t.TempDir(), "store")
+	writeConfig(t, Config{Stores: map[string]ProviderConfig{storeName(dir): {KeyProvider: KeyModeSSH, SSHKey: plainPath}}})
+	store := roundTrip(t, dir, &Unlocker{}, &Unlocker{})
+	if store.KeyMode() != KeyModeSSH {
+		t.Errorf("KeyMode() = %q, want ssh", store.KeyMode())
+	}
+
+	// The same key, encrypted, asks for its passphrase once
+	writeConfig(t, Config{Stores: map[string]ProviderConfig{storeName(dir): {SSHKey: encryptedPath}}})
+	var calls int
+	u := &Unlocker{Prompt: countingPrompt("key-passphrase", &calls)}
+	for i := 0; i < 2; i++ {
+		reopened, err := u.Open(dir)
+		if err != nil {
+			t.Fatalf("Open() with encrypted key error = %v", err)
+		}
+		if value, _, _ := reopened.Get("KEY"); value != "value" {
+			t.Errorf("Get() = %q, want value", value)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("asked for the SSH key passphrase %d times, want 1", calls)
+	}
+	if _, err := (&Unlocker{}).Open(dir); err == nil {
+		t.Error("Open() with an encrypted key and no prompt succeeded")
+	}
+}
+
+func TestPassphraseCommandAndFD(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	writeConfig(t, Config{ProviderConfig: ProviderConfig{KeyProvider: KeyModePassphrase, PassphraseCommand: "printf 'hunter2\\nignored\\n'"}})
+	roundTrip(t, dir, &Unlocker{}, &Unlocker{})
+
+	// The same passphrase from a file descriptor, with no command configured
+	writeConfig(t, Config{})
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("hunter2\n")
+	w.Close()
+	store, err := (&Unlocker{PassphraseProvider: PassphraseFD(int(r.Fd()))}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with --passphrase-fd error = %v", err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	_, err = (&Unlocker{PassphraseProvider: PassphraseFD(1 << 20)}).Open(dir)
+	if err == nil || !strings.Contains(err.Error(), "invalid passphrase file descriptor") {
+		t.Errorf("Open() with a closed descriptor error = %v, want an invalid descriptor", err)
+	}
+
+	writeConfig(t, Config{ProviderConfig: ProviderConfig{PassphraseCommand: "exit 1"}})
+	if _, err := (&Unlocker{NoCommands: true}).Open(dir); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Open() with NoCommands error = %v, want ErrPassphraseRequired", err)
+	}
+}
@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	// RecoverySlot names the key slot alex recovery create adds
+	RecoverySlot = "recovery"
+
+	recoverySecretBytes = 32
+)
+
+// NewRecoveryKey generates a recovery key: a random age X25519 identity
+// that is never written to disk. It returns the key and its 32-byte
+// secret, which is split into shares to hand out.
+func NewRecoveryKey() (*Key, []byte, error) {
+	secret := make([]byte, recoverySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, nil, err
+	}
+	key, err := ParseRecoveryKey(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, secret, nil
+}
+
+// ParseRecoveryKey returns the recovery key of a secret from NewRecoveryKey
+func ParseRecoveryKey(secret []byte) (*Key, error) {
+	if len(secret) != recoverySecretBytes {
+		return nil, fmt.Errorf("recovery secret must be %d bytes, got %d", recoverySecretBytes, len(secret))
+	}
+	identity, err := age.ParseX25519Identity(bech32Encode("age-secret-key-", secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery secret: %w", err)
+	}
+	recipient := identity.Recipient()
+	return &Key{mode: KeyModeRecovery, recipient: recipient, identity: identity, Recipient: recipient.String()}, nil
+}
+
+// bech32Charset is the bech32 alphabet age keys are written in
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encode encodes data the way age writes X25519 identities, in
+// upper case
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup 8-bit bytes into 5-bit values
+	var values []byte
+	var acc, bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits))&31)
+	}
+
+	var expanded []byte
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	mod := bech32Polymod(append(append(expanded, values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+	b.WriteString(hrp + "1")
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(mod>>uint(5*(5-i)))&31])
+	}
+	return strings.ToUpper(b.String())
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoveryKeyRestoresStoreOnNewMachine(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	key, secret, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatalf("NewRecoveryKey() error = %v", err)
+	}
+	if err := store.AddSlot(RecoverySlot, key); err != nil {
+		t.Fatalf("AddSlot() error = %v", err)
+	}
+
+	// A new machine: another machine ID and no install secret
+	path, _ := InstallSecretPath()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	newMachine := &MachineIDResult{ID: hashMachineID("new-machine"), Source: "test"}
+	if _, err := (&Unlocker{MachineID: newMachine}).Open(dir); err == nil {
+		t.Fatal("Open() without the recovery key succeeded")
+	}
+
+	recovered, err := ParseRecoveryKey(secret)
+	if err != nil {
+		t.Fatalf("ParseRecoveryKey() error = %v", err)
+	}
+	if recovered.Recipient != key.Recipient {
+		t.Errorf("ParseRecoveryKey() recipient = %s, want %s", recovered.Recipient, key.Recipient)
+	}
+	u := &Unlocker{Recovery: recovered}
+	store, err = u.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the recovery key error = %v", err)
+	}
+	if store.Slot() != RecoverySlot {
+		t.Errorf("Slot() = %q, want recovery", store.Slot())
+	}
+
+	machineKey, err := u.TargetKey(RekeyTarget{Mode: KeyModeMachine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey(store, machineKey); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	reopened, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() after restore error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	if slots, _ := ListSlots(dir); len(slots) != 2 || slots[1].Name != RecoverySlot {
+		t.Errorf("ListSlots() after restore = %+v, want the recovery slot kept", slots)
+	}
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rekeyBackupSuffix is appended to the store file and key header to keep
+// their state while a rekey is under way. They open with the old key, so
+// they are removed once the new header is written.
+const rekeyBackupSuffix = ".rekey.bak"
+
+// RekeyTarget describes a new key for Rekey or Store.AddSlot
+type RekeyTarget struct {
+	Mode KeyMode
+	// KeyFile is the identity file, SSH key or plugin identity of the new
+	// key. If empty, the default from config.json is used.
+	KeyFile string
+	// Passphrase supplies the new passphrase of a passphrase key
+	Passphrase KeyProvider
+}
+
+// TargetKey returns the key described by target. A machine key always
+// uses this machine's own ID, even if MachineID replaces it for unlocking.
+func (u *Unlocker) TargetKey(target RekeyTarget) (*Key, error) {
+	switch target.Mode {
+	case KeyModeMachine:
+		machine, err := GetMachineID()
+		if err != nil {
+			return nil, err
+		}
+		if machine.UsedFallback && u.OnWeakMachineID != nil {
+			u.OnWeakMachineID()
+		}
+		installSecret, err := loadInstallSecret(true)
+		if err != nil {
+			return nil, err
+		}
+		key, err := PassphraseKey(machineKey(machine.ID, installSecret))
+		if err != nil {
+			return nil, err
+		}
+		key.mode = KeyModeMachine
+		key.info = machineKeyInfo(machine, installSecret)
+		return key, nil
+
+	case KeyModePassphrase:
+		if target.Passphrase == nil {
+			return nil, fmt.Errorf("%w: no new passphrase", ErrPassphraseRequired)
+		}
+		return target.Passphrase.Key("the new key")
+
+	case KeyModeIdentity, KeyModeSSH, KeyModePlugin:
+		path := target.KeyFile
+		if path == "" {
+			path = LoadConfig().identityPath(target.Mode)
+		}
+		if path == "" && target.Mode == KeyModeSSH {
+			path = defaultSSHKey()
+		}
+		if path == "" {
+			return nil, fmt.Errorf("key provider %q needs a key file: pass one or set %s in ~/.alex/config.json", target.Mode, providerSetting(target.Mode))
+		}
+		provider, err := u.keyFileProvider(target.Mode, path)
+		if err != nil {
+			return nil, err
+		}
+		return provider.Key("the new key")
+
+	default:
+		return nil, fmt.Errorf("unknown key provider %q (want machine, passphrase, identity, ssh or plugin)", target.Mode)
+	}
+}
+
+// Rekey re-encrypts an unlocked store under a new data key wrapped with
+// key, replacing its primary key, and records the new key mode in its
+// header. A new data key means the old key opens neither the store nor
+// copies of it made from now on. Extra key slots are wrapped again from
+// the public key they record; slots without one (passphrase and machine
+// slots) must be removed first.
+//
+// The store file and header are backed up with a .rekey.bak suffix while
+// the rekey is under way, and the store file is restored if the header
+// cannot be written, so the store always opens with one of the two keys.
+// The backups are removed afterwards; RekeyBackups reports any that could
+// not be.
+func Rekey(store *Store, key *Key) error {
+	header := keyHeader(key)
+	slotKeys := make(map[string]*Key)
+	if previous, _ := ReadKeyInfo(store.path); previous != nil {
+		header.Slots = previous.Slots
+		for _, slot := range previous.Slots {
+			slotKey, err := RecipientKey(slot.Recipient)
+			if slot.Recipient == "" || err != nil {
+				return fmt.Errorf("key slot %q (%s) can't be wrapped to a new data key without unlocking it: remove it with 'alex keys remove %s', rekey, then add it again",
+					slot.Name, slot.Mode, slot.Name)
+			}
+			slotKeys[slot.Name] = slotKey
+		}
+	}
+
+	if err := backupForRekey(store.path); err != nil {
+		return fmt.Errorf("backing up store: %w", err)
+	}
+	if err := store.rotateDataKey(key, slotKeys); err != nil {
+		return err
+	}
+	if err := WriteKeyInfo(store.path, header); err != nil {
+		if restoreErr := restoreRekeyBackup(store.path, secretsFile); restoreErr != nil {
+			return fmt.Errorf("writing key header: %w (restoring the store also failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("writing key header: %w", err)
+	}
+	store.keyInfo = header
+	store.keyInfoPending = false
+	for _, backup := range RekeyBackups(store.path) {
+		os.Remove(backup)
+	}
+	return nil
+}
+
+// RekeyBackups returns the backups a rekey of the store in dir left, which
+// still open with the key from before it
+func RekeyBackups(dir string) []string {
+	var backups []string
+	for _, name := range []string{secretsFile, keyInfoFile} {
+		path := filepath.Join(dir, name+rekeyBackupSuffix)
+		if _, err := os.Stat(path); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	return backups
+}
+
+// backupForRekey copies the store file and key header in dir, if they
+// exist, to their .rekey.bak names
+func backupForRekey(dir string) error {
+	for _, name := range []string{secretsFile, keyInfoFile} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name+rekeyBackupSuffix), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreRekeyBackup(dir, name string) error {
+	data, err := os.ReadFile(filepath.Join(dir, name+rekeyBackupSuffix))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, name), data, 0600)
+}
@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRekeyMachineToPassphrase(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Unlocker{}
+	key, err := u.TargetKey(RekeyTarget{Mode: KeyModePassphrase, Passphrase: PromptPassphrase(func(string) (string, error) {
+		return "hunter2", nil
+	})})
+	if err != nil {
+		t.Fatalf("TargetKey() error = %v", err)
+	}
+	oldDataKey := store.dataKey.Recipient().String()
+	oldDerived, err := store.DerivedKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey(store, key); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if backups := RekeyBackups(dir); len(backups) != 0 {
+		t.Errorf("RekeyBackups() = %v, want none left", backups)
+	}
+	// A copy wrapped with the old key must not open the new file
+	if store.dataKey.Recipient().String() == oldDataKey {
+		t.Error("Rekey() kept the data key")
+	}
+
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Open() without a passphrase error = %v, want ErrPassphraseRequired", err)
+	}
+	reopened, err := (&Unlocker{Passphrase: "hunter2"}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the new passphrase error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	if reopened.KeyMode() != KeyModePassphrase {
+		t.Errorf("KeyMode() = %q, want passphrase", reopened.KeyMode())
+	}
+	derived, err := reopened.DerivedKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(derived, oldDerived) {
+		t.Error("DerivedKey() did not change with the data key")
+	}
+	if other, _ := reopened.DerivedKey("other"); bytes.Equal(other, derived) {
+		t.Error("DerivedKey() is the same for another purpose")
+	}
+}
+
+func TestDerivedKeySavesNewStore(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := store.DerivedKey("test")
+	if err != nil {
+		t.Fatalf("DerivedKey() error = %v", err)
+	}
+	reopened, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := reopened.DerivedKey("test"); err != nil || !bytes.Equal(again, key) {
+		t.Errorf("DerivedKey() after reopening = %x, %v; want %x", again, err, key)
+	}
+}
+
+func TestRekeyAfterMachineIDChanged(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	// A store keyed on this machine before its machine ID was regenerated
+	oldIDFile := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(oldIDFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldID, err := MachineIDFromFile(oldIDFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := (&Unlocker{MachineID: oldID}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrMachineIDChanged) {
+		t.Fatalf("Open() error = %v, want ErrMachineIDChanged", err)
+	}
+
+	u := &Unlocker{MachineID: oldID}
+	store, err = u.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the old machine ID error = %v", err)
+	}
+	key, err := u.TargetKey(RekeyTarget{Mode: KeyModeMachine})
+	if err != nil {
+		t.Fatalf("TargetKey() error = %v", err)
+	}
+	if err := Rekey(store, key); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+
+	reopened, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() after rekey error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+}
+
+func TestRekeyKeepsRecipientSlots(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	recovery, secret, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddSlot(RecoverySlot, recovery); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := PassphraseKey("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddSlot("backup", backup); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := PassphraseKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A passphrase slot can't be wrapped to a new data key
+	if err := Rekey(store, key); err == nil {
+		t.Fatal("Rekey() with a passphrase slot succeeded")
+	}
+	if err := RemoveSlot(dir, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = (&Unlocker{}).Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey(store, key); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+
+	recovered, err := ParseRecoveryKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := (&Unlocker{Recovery: recovered}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the recovery key after Rekey() error = %v", err)
+	}
+	if value, _, _ := reopened.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+}
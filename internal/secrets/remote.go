@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRemote identifies projects unless config.json prefers others
+const defaultRemote = "origin"
+
+// defaultPorts are dropped from remote URLs, since leaving them out
+// means the same server
+var defaultPorts = map[string]bool{"22": true, "80": true, "443": true, "9418": true}
+
+// CanonicalRemote reduces a git remote URL to host/path, so every way of
+// writing the same repository gives the same project ID:
+// git@github.com:org/app.git, https://github.com/org/app and
+// ssh://git@github.com/org/app.git all become github.com/org/app. The
+// scheme, user, default port, .git suffix and trailing slashes are
+// dropped and the result is lowercased. Local paths are only cleaned.
+func CanonicalRemote(remote string) string {
+	s := strings.TrimSpace(remote)
+	var host, path string
+	if i := strings.Index(s, "://"); i >= 0 {
+		if strings.EqualFold(s[:i], "file") {
+			return canonicalPath(s[i+3:])
+		}
+		rest := s[i+3:]
+		host, path = rest, ""
+		if slash := strings.IndexByte(rest, '/'); slash >= 0 {
+			host, path = rest[:slash], rest[slash:]
+		}
+		host = stripUser(host)
+		if colon := strings.LastIndexByte(host, ':'); colon >= 0 && defaultPorts[host[colon+1:]] {
+			host = host[:colon]
+		}
+	} else if colon := strings.IndexByte(s, ':'); colon > 0 && !strings.Contains(s[:colon], "/") {
+		// scp-like syntax: [user@]host:path
+		host, path = stripUser(s[:colon]), s[colon+1:]
+	} else {
+		return canonicalPath(s)
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(strings.TrimRight(host+"/"+path, "/"))
+}
+
+// stripUser drops the user and password in front of a host
+func stripUser(host string) string {
+	if at := strings.LastIndexByte(host, '@'); at >= 0 {
+		return host[at+1:]
+	}
+	return host
+}
+
+// canonicalPath cleans a remote that is a local path. Paths keep their
+// case, since file systems may not ignore it.
+func canonicalPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Clean(path), ".git")
+}
+
+// gitRemotes returns the fetch URL of every remote of the repository in
+// dir, by name
+func gitRemotes(dir string) map[string]string {
+	remotes := make(map[string]string)
+	output, err := exec.Command("git", "-C", dir, "remote", "-v").Output()
+	if err != nil {
+		return remotes
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[2] == "(fetch)" {
+			remotes[fields[0]] = fields[1]
+		}
+	}
+	return remotes
+}
+
+// preferredRemote picks the remote a project is identified by: the first
+// of the names in project_remotes in config.json that the repository
+// has, then origin
+func preferredRemote(remotes map[string]string, preference []string) string {
+	for _, name := range preference {
+		if url := remotes[name]; url != "" {
+			return url
+		}
+	}
+	return remotes[defaultRemote]
+}
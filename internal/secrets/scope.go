@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CleanScopeDir normalizes a subdirectory of a project root given to
+// scope a store to it: slash-separated, without "." or trailing slashes,
+// and empty for the root itself. It fails for paths outside the root.
+func CleanScopeDir(dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		return "", fmt.Errorf("directory %q must be relative to the project root", dir)
+	}
+	clean := path.Clean(filepath.ToSlash(dir))
+	if clean == "." {
+		return "", nil
+	}
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("directory %q is outside the project root", dir)
+	}
+	return clean, nil
+}
+
+// Scope returns what is recorded about the store of the subdirectory dir
+// of the project. dir must be clean, see CleanScopeDir.
+func (p Project) Scope(dir string) ProjectInfo {
+	info := p.Info()
+	info.Dir = dir
+	return info
+}
+
+// Layers returns the stores whose secrets apply in the project's Dir,
+// from the least to the most specific: those of the parent project of a
+// submodule, then the project's own store, then the store of each
+// directory from the root down to Dir.
+func (p Project) Layers() []ProjectInfo {
+	var layers []ProjectInfo
+	if p.Parent != nil {
+		layers = p.Parent.Layers()
+	}
+	layers = append(layers, p.Info())
+	if p.Dir == "" {
+		return layers
+	}
+	parts := strings.Split(p.Dir, "/")
+	for i := range parts {
+		layers = append(layers, p.Scope(strings.Join(parts[:i+1], "/")))
+	}
+	return layers
+}
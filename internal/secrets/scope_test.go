@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/portdeveloper/alex/internal/manifest"
+)
+
+func TestCleanScopeDir(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		".":                   "",
+		"services/billing/":   "services/billing",
+		"./services//billing": "services/billing",
+		"services/../web":     "web",
+	}
+	for dir, want := range tests {
+		if got, err := CleanScopeDir(dir); err != nil || got != want {
+			t.Errorf("CleanScopeDir(%q) = %q, %v, want %q", dir, got, err, want)
+		}
+	}
+	for _, dir := range []string{"..", "../other", "/abs/path"} {
+		if _, err := CleanScopeDir(dir); err == nil {
+			t.Errorf("CleanScopeDir(%q) succeeded", dir)
+		}
+	}
+}
+
+func TestProjectLayers(t *testing.T) {
+	parent := newProject("/src/mono", gitMarker, "git@github.com:acme/mono.git")
+	parent.Dir = "libs/lib"
+	project := newProject("/src/mono/libs/lib", gitMarker, "git@github.com:acme/lib.git")
+	project.Dir = "pkg/api"
+	project.Parent = &parent
+
+	var got []string
+	ids := make(map[string]bool)
+	for _, layer := range project.Layers() {
+		got = append(got, CanonicalRemote(layer.Remote)+"//"+layer.Dir)
+		ids[layer.ID()] = true
+	}
+	want := []string{
+		"github.com/acme/mono//", "github.com/acme/mono//libs", "github.com/acme/mono//libs/lib",
+		"github.com/acme/lib//", "github.com/acme/lib//pkg", "github.com/acme/lib//pkg/api",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Layers() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("layer %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+	if len(ids) != len(want) {
+		t.Errorf("layers share IDs: %v", ids)
+	}
+	if project.Layers()[3].ID() != project.ID {
+		t.Error("the project's own layer has another ID than the project")
+	}
+}
+
+func TestResolveProjectInSubdirectoryAndSubmodule(t *testing.T) {
+	isolateHome(t)
+	sub, mono := t.TempDir(), t.TempDir()
+	git(t, sub, "init", "-q")
+	git(t, sub, "-c", "user.name=a", "-c", "user.email=a@a", "commit", "-q", "--allow-empty", "-m", "init")
+	git(t, mono, "init", "-q")
+	git(t, mono, "remote", "add", "origin", "git@github.com:acme/mono.git")
+	git(t, mono, "-c", "protocol.file.allow=always", "submodule", "add", "-q", sub, "libs/lib")
+
+	services := filepath.Join(mono, "services", "billing")
+	if err := os.MkdirAll(services, 0755); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, services)
+	project := ResolveProject()
+	if project.Dir != "services/billing" || project.Parent != nil || project.Remote != "git@github.com:acme/mono.git" {
+		t.Errorf("ResolveProject() in a subdirectory = %+v", project)
+	}
+
+	chdir(t, filepath.Join(mono, "libs", "lib"))
+	project = ResolveProject()
+	// The submodule's origin is where it was cloned from
+	if project.Remote != sub || project.Dir != "" {
+		t.Fatalf("ResolveProject() in a submodule = %+v", project)
+	}
+	if project.Parent == nil || project.Parent.Remote != "git@github.com:acme/mono.git" || project.Parent.Dir != "libs/lib" {
+		t.Errorf("parent of the submodule = %+v", project.Parent)
+	}
+}
+
+func TestOpenLayers(t *testing.T) {
+	isolateHome(t)
+	checkout := t.TempDir()
+	if err := os.WriteFile(filepath.Join(checkout, rootMarkerFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &manifest.Manifest{Groups: []string{"staging", "missing", "Not Valid"}}
+	if err := m.Save(checkout); err != nil {
+		t.Fatal(err)
+	}
+	workdir := filepath.Join(checkout, "services", "api")
+	if err := os.MkdirAll(workdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Unlocker{}
+	if err := CreateGroup("staging"); err != nil {
+		t.Fatal(err)
+	}
+	group, err := u.OpenGroup("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Set("REGISTRY_TOKEN", "token"); err != nil {
+		t.Fatal(err)
+	}
+	project, err := ResolveProjectAt(workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProjectStore(t, project.Info())
+	newProjectStore(t, project.Scope("services"))
+
+	layers, warnings, err := u.OpenLayers(project)
+	if err != nil {
+		t.Fatalf("OpenLayers() error = %v", err)
+	}
+	var scopes []string
+	for _, layer := range layers {
+		scopes = append(scopes, layer.Scope)
+	}
+	// No store for services/api itself
+	want := []string{"global", "group:staging", "project", "project:services"}
+	if !reflect.DeepEqual(scopes, want) {
+		t.Errorf("OpenLayers() scopes = %v, want %v", scopes, want)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("OpenLayers() warnings = %q, want the missing group and the invalid name", warnings)
+	}
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// PrimarySlot names the primary key of a store, which new stores are
+// created with and alex rekey replaces. It cannot be removed.
+const PrimarySlot = "primary"
+
+// validSlotName matches names of extra key slots
+var validSlotName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Slot returns the name of the key slot that unlocked the store
+func (s *Store) Slot() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.slot == "" {
+		return PrimarySlot
+	}
+	return s.slot
+}
+
+// ListSlots returns the primary key and extra key slots recorded in the
+// header of the store in dir, each with its Name set. Nothing is decrypted.
+func ListSlots(dir string) ([]KeyInfo, error) {
+	info, err := ReadKeyInfo(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, nil
+	}
+	primary := *info
+	primary.Name, primary.Slots = PrimarySlot, nil
+	return append([]KeyInfo{primary}, info.Slots...), nil
+}
+
+// AddSlot wraps the store's data key with key under a new slot name, so
+// key unlocks the store on its own. Secrets are not re-encrypted.
+func (s *Store) AddSlot(name string, key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validSlotName.MatchString(name) || name == PrimarySlot {
+		return fmt.Errorf("invalid key slot name %q (use lowercase letters, digits, - and _)", name)
+	}
+	if s.dataKey == nil {
+		return fmt.Errorf("store has no secrets yet: save one before adding key slots")
+	}
+	info, err := ReadKeyInfo(s.path)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("store has no key header yet: unlock it with alex first")
+	}
+	for _, slot := range info.Slots {
+		if slot.Name == name {
+			return fmt.Errorf("key slot %q already exists", name)
+		}
+	}
+
+	wrapped, err := wrapDataKey(s.dataKey, key)
+	if err != nil {
+		return err
+	}
+	slot := keyHeader(key)
+	slot.Name = name
+	info.Slots = append(info.Slots, *slot)
+
+	c, err := updateSlots(s.path, info, func(c *container) {
+		c.set(slotSection(name), wrapped)
+	})
+	if err != nil {
+		return err
+	}
+	// Later saves must keep the new slot
+	s.slots = c.slotSections()
+	s.keyInfo = info
+	return nil
+}
+
+// RemoveSlot removes an extra key slot from the store in dir. The store
+// doesn't need to be unlocked. The primary key can only be replaced, with
+// alex rekey.
+func RemoveSlot(dir, name string) error {
+	if name == PrimarySlot {
+		return fmt.Errorf("the primary key can't be removed: replace it with 'alex rekey'")
+	}
+	info, err := ReadKeyInfo(dir)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("no key slot %q", name)
+	}
+	remaining := info.Slots[:0:0]
+	for _, slot := range info.Slots {
+		if slot.Name != name {
+			remaining = append(remaining, slot)
+		}
+	}
+	if len(remaining) == len(info.Slots) {
+		return fmt.Errorf("no key slot %q", name)
+	}
+	info.Slots = remaining
+
+	_, err = updateSlots(dir, info, func(c *container) {
+		c.remove(slotSection(name))
+	})
+	return err
+}
+
+// updateSlots applies change to the store file in dir and writes info as
+// the new header. The store file is put back if the header cannot be
+// written. Returns the changed container.
+func updateSlots(dir string, info *KeyInfo, change func(c *container)) (*container, error) {
+	path := filepath.Join(dir, secretsFile)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseContainer(original)
+	if err != nil {
+		return nil, err
+	}
+	change(c)
+	if err := writeFileAtomic(path, c.encode(), 0600); err != nil {
+		return nil, err
+	}
+	if err := WriteKeyInfo(dir, info); err != nil {
+		writeFileAtomic(path, original, 0600)
+		return nil, fmt.Errorf("writing key header: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeySlots(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := PassphraseKey("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddSlot("backup", backup); err != nil {
+		t.Fatalf("AddSlot() error = %v", err)
+	}
+	if err := store.AddSlot("backup", backup); err == nil {
+		t.Error("AddSlot() with a duplicate name succeeded")
+	}
+	// Saving keeps the slot
+	if err := store.Set("OTHER", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	slots, err := ListSlots(dir)
+	if err != nil || len(slots) != 2 || slots[0].Name != PrimarySlot || slots[1].Name != "backup" || slots[1].Mode != KeyModePassphrase {
+		t.Fatalf("ListSlots() = %+v, %v; want primary and backup", slots, err)
+	}
+
+	// With another machine ID, the primary key fails and the slot is used
+	otherMachine := &MachineIDResult{ID: hashMachineID("another-machine-id"), Source: "test"}
+	reopened, err := (&Unlocker{MachineID: otherMachine, Passphrase: "hunter2"}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the backup slot error = %v", err)
+	}
+	if reopened.Slot() != "backup" {
+		t.Errorf("Slot() = %q, want backup", reopened.Slot())
+	}
+	if value, _, _ := reopened.Get("OTHER"); value != "value" {
+		t.Errorf("Get() = %q, want value", value)
+	}
+	if reopened.KeyMode() != KeyModeMachine {
+		t.Errorf("KeyMode() = %q, want the primary machine mode", reopened.KeyMode())
+	}
+
+	if err := RemoveSlot(dir, PrimarySlot); err == nil {
+		t.Error("RemoveSlot(primary) succeeded")
+	}
+	if err := RemoveSlot(dir, "backup"); err != nil {
+		t.Fatalf("RemoveSlot() error = %v", err)
+	}
+	if _, err := (&Unlocker{MachineID: otherMachine, Passphrase: "hunter2"}).Open(dir); !errors.Is(err, ErrMachineIDChanged) {
+		t.Errorf("Open() after removing the slot error = %v, want ErrMachineIDChanged", err)
+	}
+	primary, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() with the primary key error = %v", err)
+	}
+	if primary.Slot() != PrimarySlot {
+		t.Errorf("Slot() = %q, want primary", primary.Slot())
+	}
+}
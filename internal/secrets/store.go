@@ -1,16 +1,18 @@
 package secrets
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"filippo.io/age"
 )
 
 const (
@@ -27,16 +29,56 @@ type Secret struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// Store manages encrypted secret storage
+// Store manages encrypted secret storage. It is safe for concurrent use.
+//
+// Opening a store decrypts only the names and timestamps; values are
+// decrypted the first time one is needed.
 type Store struct {
-	path       string
-	passphrase string
-	secrets    map[string]Secret
+	mu      sync.RWMutex
+	path    string
+	key     *Key
+	secrets map[string]Secret // metadata only, Value is unset
+
+	// dataKey encrypts the index and values; keySection is dataKey wrapped
+	// with the primary key and slots holds it wrapped with extra key slots
+	dataKey    *age.X25519Identity
+	keySection []byte
+	slots      []section
+	// slot is the extra key slot key belongs to, or "" for the primary key
+	slot string
+
+	// values is filled from encryptedValues by loadValues
+	values          map[string]string
+	encryptedValues []byte
+	valuesOnce      sync.Once
+	valuesErr       error
+
+	// keyInfo is the key header of the store when it was opened through an
+	// Unlocker; keyInfoPending means it is written with the first save
+	keyInfo        *KeyInfo
+	keyInfoPending bool
+	// newKey supplies the key of a new store when its first secret is saved
+	newKey func() (*Key, error)
+	// project is recorded in the store's directory once it has a file
+	project *ProjectInfo
 }
 
 // Config holds alex configuration
 type Config struct {
 	UsePassphrase bool `json:"use_passphrase"`
+	// InstallSecretFile overrides where the install secret is kept
+	InstallSecretFile string `json:"install_secret_file,omitempty"`
+
+	// ProviderConfig chooses the key provider of every store...
+	ProviderConfig
+	// Stores overrides it for single stores, keyed by "global",
+	// "group:NAME" or project ID
+	Stores map[string]ProviderConfig `json:"stores,omitempty"`
+
+	// ProjectRemotes lists the git remotes projects are identified by, in
+	// order of preference, e.g. ["upstream"] so forks share a store with
+	// the repository they were forked from. origin is always tried last.
+	ProjectRemotes []string `json:"project_remotes,omitempty"`
 }
 
 // NewStore creates a new global secret store (backwards compatible)
@@ -66,16 +108,73 @@ func NewProjectStore(passphrase string) (*Store, error) {
 	return NewStoreAt(passphrase, projectPath)
 }
 
+// NewProjectStoreByID opens the store for a project ID (as returned by GetProjectID)
+func NewProjectStoreByID(passphrase string, projectID string) (*Store, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreAt(passphrase, filepath.Join(homeDir, alexDir, projectsDir, projectID))
+}
+
+// ListProjectIDs returns the IDs of all project stores that contain secrets
+func ListProjectIDs() ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(homeDir, alexDir, projectsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		secretsPath := filepath.Join(homeDir, alexDir, projectsDir, entry.Name(), secretsFile)
+		if _, err := os.Stat(secretsPath); err == nil {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
 // NewStoreAt creates a store at a specific path
 func NewStoreAt(passphrase string, basePath string) (*Store, error) {
+	var key *Key
+	if passphrase != "" {
+		var err error
+		if key, err = PassphraseKey(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return NewStoreWithKey(key, basePath)
+}
+
+// NewStoreWithKey creates a store at a specific path that is unlocked with
+// key, as returned by a KeyProvider
+func NewStoreWithKey(key *Key, basePath string) (*Store, error) {
+	return openStore(key, basePath, "")
+}
+
+// openStore opens the store at basePath with the key of a key slot; ""
+// is the primary key
+func openStore(key *Key, basePath, slot string) (*Store, error) {
 	if err := os.MkdirAll(basePath, 0700); err != nil {
 		return nil, err
 	}
 
 	store := &Store{
-		path:       basePath,
-		passphrase: passphrase,
-		secrets:    make(map[string]Secret),
+		path:    basePath,
+		key:     key,
+		slot:    slot,
+		secrets: make(map[string]Secret),
 	}
 
 	// Load existing secrets if they exist
@@ -97,6 +196,12 @@ func GlobalStoreExists() bool {
 	return err == nil
 }
 
+// StoreExists reports whether the store in dir has a secrets file
+func StoreExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, secretsFile))
+	return err == nil
+}
+
 // ProjectStoreExists checks if project secrets exist for the current directory.
 // Returns (exists, error) where error indicates a problem checking (not just missing file).
 func ProjectStoreExists() (bool, error) {
@@ -120,49 +225,22 @@ func ProjectStoreExists() (bool, error) {
 // Uses git remote URL if available (stable across moves), otherwise uses path.
 // Returns a short hash to use as directory name.
 func GetProjectID() string {
-	identifier := getProjectIdentifier()
-	hash := sha256.Sum256([]byte(identifier))
-	// Use first 12 chars of hex hash (like git short hashes)
-	return hex.EncodeToString(hash[:])[:12]
+	return ResolveProject().ID
 }
 
-// GetProjectRoot returns the root directory of the current project
+// GetProjectRoot returns the root directory of the current project, or
+// an empty string outside a git repository
 func GetProjectRoot() string {
-	return getGitRoot()
-}
-
-// getProjectIdentifier returns a stable identifier for the project.
-// Prefers git remote URL (survives moves), falls back to git root path.
-func getProjectIdentifier() string {
-	// Try git remote origin URL first (most stable - survives moves)
-	cmd := exec.Command("git", "remote", "get-url", "origin")
-	output, err := cmd.Output()
-	if err == nil {
-		return strings.TrimSpace(string(output))
+	if project := ResolveProject(); project.InGit() {
+		return project.Root
 	}
-
-	// Fall back to git root path
-	root := getGitRoot()
-	if root != "" {
-		return root
-	}
-
-	// Last resort: current directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return "."
-	}
-	return cwd
+	return ""
 }
 
-// getGitRoot returns the git repository root, or empty string if not in a git repo
-func getGitRoot() string {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
-	if err == nil {
-		return strings.TrimSpace(string(output))
-	}
-	return ""
+// GetProjectRemote returns the git remote URL of the current project,
+// or an empty string if it has none
+func GetProjectRemote() string {
+	return ResolveProject().Remote
 }
 
 // GetGlobalDir returns the path to the global alex directory
@@ -174,6 +252,15 @@ func GetGlobalDir() (string, error) {
 	return filepath.Join(homeDir, alexDir), nil
 }
 
+// GetProjectsDir returns the directory holding all project stores
+func GetProjectsDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, alexDir, projectsDir), nil
+}
+
 // GetAlexDir is an alias for GetGlobalDir (backwards compatible)
 func GetAlexDir() (string, error) {
 	return GetGlobalDir()
@@ -185,46 +272,201 @@ func (s *Store) secretsFilePath() string {
 	return filepath.Join(s.path, secretsFile)
 }
 
-// load reads and decrypts secrets from disk
+// indexEntry is the metadata of one secret in the index section
+type indexEntry struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// load reads the store file, migrating it to the current format first if
+// needed, and decrypts the index
 func (s *Store) load() error {
 	data, err := os.ReadFile(s.secretsFilePath())
 	if err != nil {
 		return err
 	}
 
-	decrypted, err := decrypt(data, s.passphrase)
+	switch version := FormatVersion(data); {
+	case version > CurrentFormat:
+		return fmt.Errorf("store format v%d is newer than this version of alex supports (v%d) - upgrade alex", version, CurrentFormat)
+	case version < CurrentFormat:
+		if s.key == nil {
+			return ErrPassphraseRequired
+		}
+		if data, err = migrateStoreFile(s.path, data, s.key); err != nil {
+			return err
+		}
+	}
+
+	c, err := parseContainer(data)
 	if err != nil {
 		return err
 	}
+	keySection, ok := c.get(sectionKey)
+	if !ok {
+		return fmt.Errorf("%w (missing key section)", ErrCorruptedStore)
+	}
+	wrapped, ok := c.get(slotSection(s.slot))
+	if !ok {
+		return fmt.Errorf("%w (missing %s section)", ErrCorruptedStore, slotSection(s.slot))
+	}
+	if s.key == nil {
+		return ErrPassphraseRequired
+	}
+	dataKey, err := unwrapDataKey(wrapped, s.key)
+	if err != nil {
+		return err
+	}
+
+	index, err := decryptSection(c, sectionIndex, dataKey)
+	if err != nil {
+		return err
+	}
+	var entries map[string]indexEntry
+	if err := json.Unmarshal(index, &entries); err != nil {
+		return fmt.Errorf("%w (invalid index JSON): %v", ErrCorruptedStore, err)
+	}
 
-	if err := json.Unmarshal(decrypted, &s.secrets); err != nil {
-		return fmt.Errorf("corrupted secrets data (invalid JSON): %w", err)
+	values, ok := c.get(sectionValues)
+	if !ok {
+		return fmt.Errorf("%w (missing values section)", ErrCorruptedStore)
 	}
+
+	for name, entry := range entries {
+		s.secrets[name] = Secret{CreatedAt: entry.CreatedAt, UpdatedAt: entry.UpdatedAt}
+	}
+	s.dataKey = dataKey
+	s.keySection = keySection
+	s.slots = c.slotSections()
+	s.encryptedValues = values
 	return nil
 }
 
+// loadValues decrypts the values section once
+func (s *Store) loadValues() error {
+	s.valuesOnce.Do(func() {
+		s.values = make(map[string]string)
+		if s.encryptedValues == nil {
+			return
+		}
+		plain, err := decryptWith(s.encryptedValues, s.dataKey)
+		if err != nil {
+			s.valuesErr = err
+			return
+		}
+		if err := json.Unmarshal(plain, &s.values); err != nil {
+			s.valuesErr = fmt.Errorf("%w (invalid values JSON): %v", ErrCorruptedStore, err)
+		}
+	})
+	return s.valuesErr
+}
+
 // save encrypts and writes secrets to disk
 func (s *Store) save() error {
-	data, err := json.Marshal(s.secrets)
-	if err != nil {
+	if err := s.loadValues(); err != nil {
 		return err
 	}
 
-	encrypted, err := encrypt(data, s.passphrase)
+	if s.dataKey == nil {
+		if s.key == nil && s.newKey != nil {
+			key, err := s.newKey()
+			if err != nil {
+				return err
+			}
+			s.key = key
+		}
+		if s.key == nil {
+			return ErrPassphraseRequired
+		}
+
+		dataKey, err := age.GenerateX25519Identity()
+		if err != nil {
+			return err
+		}
+		keySection, err := wrapDataKey(dataKey, s.key)
+		if err != nil {
+			return err
+		}
+		s.dataKey, s.keySection = dataKey, keySection
+	}
+
+	data, err := sealStore(s.keySection, s.slots, s.dataKey, s.secrets, s.values)
 	if err != nil {
 		return err
 	}
+	if err := writeFileAtomic(s.secretsFilePath(), data, 0600); err != nil {
+		return err
+	}
 
-	return os.WriteFile(s.secretsFilePath(), encrypted, 0600)
+	if s.keyInfoPending {
+		if err := WriteKeyInfo(s.path, s.keyInfo); err != nil {
+			return fmt.Errorf("writing key header: %w", err)
+		}
+		s.keyInfoPending = false
+	}
+	s.recordProject()
+	return nil
+}
+
+// sealStore encodes a store file in the current format
+func sealStore(keySection []byte, slots []section, dataKey *age.X25519Identity, meta map[string]Secret, values map[string]string) ([]byte, error) {
+	entries := make(map[string]indexEntry, len(meta))
+	for name, secret := range meta {
+		entries[name] = indexEntry{CreatedAt: secret.CreatedAt, UpdatedAt: secret.UpdatedAt}
+	}
+	index, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[string]string)
+	}
+	valueData, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedIndex, err := encryptTo(index, dataKey.Recipient())
+	if err != nil {
+		return nil, err
+	}
+	encryptedValues, err := encryptTo(valueData, dataKey.Recipient())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &container{version: CurrentFormat}
+	c.set(sectionKey, keySection)
+	for _, slot := range slots {
+		c.set(slot.name, slot.data)
+	}
+	c.set(sectionIndex, encryptedIndex)
+	c.set(sectionValues, encryptedValues)
+	return c.encode(), nil
+}
+
+// decryptSection decrypts a section encrypted to the data key
+func decryptSection(c *container, name string, dataKey *age.X25519Identity) ([]byte, error) {
+	data, ok := c.get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w (missing %s section)", ErrCorruptedStore, name)
+	}
+	return decryptWith(data, dataKey)
 }
 
 // Set stores a secret
 func (s *Store) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadValues(); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	existing, exists := s.secrets[key]
 
 	secret := Secret{
-		Value:     value,
 		UpdatedAt: now,
 	}
 
@@ -235,50 +477,241 @@ func (s *Store) Set(key, value string) error {
 	}
 
 	s.secrets[key] = secret
+	s.values[key] = value
 	return s.save()
 }
 
-// Get retrieves a secret value
-func (s *Store) Get(key string) (string, bool) {
-	secret, exists := s.secrets[key]
-	if !exists {
-		return "", false
+// Get retrieves a secret value. It returns an error if the values section
+// cannot be decrypted, which is never reported as a missing secret.
+func (s *Store) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.loadValues(); err != nil {
+		return "", false, err
 	}
-	return secret.Value, true
+	value, exists := s.values[key]
+	return value, exists, nil
 }
 
 // Delete removes a secret
 func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if _, exists := s.secrets[key]; !exists {
 		return errors.New("secret not found")
 	}
+	if err := s.loadValues(); err != nil {
+		return err
+	}
 	delete(s.secrets, key)
+	delete(s.values, key)
 	return s.save()
 }
 
 // List returns all secret names with metadata (not values)
 func (s *Store) List() map[string]Secret {
-	// Return a copy without values
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	result := make(map[string]Secret)
 	for k, v := range s.secrets {
-		result[k] = Secret{
-			CreatedAt: v.CreatedAt,
-			UpdatedAt: v.UpdatedAt,
-		}
+		result[k] = v
 	}
 	return result
 }
 
-// GetAll returns all secrets with values (for injection)
-func (s *Store) GetAll() map[string]string {
-	result := make(map[string]string)
+// GetAll returns all secrets with values (for injection). It returns an
+// error if the values section cannot be decrypted, never an empty map.
+func (s *Store) GetAll() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.loadValues(); err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// Export returns every secret with its value and timestamps
+func (s *Store) Export() (map[string]Secret, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.loadValues(); err != nil {
+		return nil, err
+	}
+	result := make(map[string]Secret, len(s.secrets))
 	for k, v := range s.secrets {
-		result[k] = v.Value
+		v.Value = s.values[k]
+		result[k] = v
 	}
-	return result
+	return result, nil
+}
+
+// Import saves several secrets at once, keeping their timestamps. Secrets
+// already in the store under the same names are replaced.
+func (s *Store) Import(secrets map[string]Secret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadValues(); err != nil {
+		return err
+	}
+	for k, v := range secrets {
+		s.values[k] = v.Value
+		v.Value = ""
+		s.secrets[k] = v
+	}
+	return s.save()
+}
+
+// Rekey wraps the store's data key with a new passphrase. Only the key
+// section is rewritten; names and values stay encrypted as they are.
+func (s *Store) Rekey(passphrase string) error {
+	key, err := PassphraseKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return s.RekeyWith(key)
+}
+
+// RekeyWith wraps the store's data key with a new key, like Rekey
+func (s *Store) RekeyWith(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dataKey == nil {
+		// Nothing saved yet: the new key is used on first save
+		s.key = key
+		s.newKey = nil
+		return nil
+	}
+
+	keySection, err := wrapDataKey(s.dataKey, key)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.secretsFilePath())
+	if err != nil {
+		return err
+	}
+	c, err := parseContainer(data)
+	if err != nil {
+		return err
+	}
+	c.set(sectionKey, keySection)
+	if err := writeFileAtomic(s.secretsFilePath(), c.encode(), 0600); err != nil {
+		return err
+	}
+
+	s.key = key
+	s.slot = ""
+	s.keySection = keySection
+	return nil
+}
+
+// rotateDataKey re-encrypts the store under a new data key wrapped with
+// key, and with slotKeys for its extra key slots by name, so nothing
+// wrapped with an earlier key opens what is saved from now on
+func (s *Store) rotateDataKey(key *Key, slotKeys map[string]*Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dataKey == nil {
+		// Nothing saved yet: the new key is used on first save
+		s.key = key
+		s.newKey = nil
+		return nil
+	}
+	if err := s.loadValues(); err != nil {
+		return err
+	}
+
+	dataKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	keySection, err := wrapDataKey(dataKey, key)
+	if err != nil {
+		return err
+	}
+	slots := make([]section, 0, len(s.slots))
+	for _, slot := range s.slots {
+		name := strings.TrimPrefix(slot.name, sectionSlotPrefix)
+		slotKey, ok := slotKeys[name]
+		if !ok {
+			return fmt.Errorf("no key to wrap key slot %q with", name)
+		}
+		wrapped, err := wrapDataKey(dataKey, slotKey)
+		if err != nil {
+			return fmt.Errorf("key slot %q: %w", name, err)
+		}
+		slots = append(slots, section{name: slot.name, data: wrapped})
+	}
+
+	data, err := sealStore(keySection, slots, dataKey, s.secrets, s.values)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.secretsFilePath(), data, 0600); err != nil {
+		return err
+	}
+	s.key = key
+	s.slot = ""
+	s.dataKey, s.keySection, s.slots = dataKey, keySection, slots
+	return nil
+}
+
+// Verify decrypts the values section, which opening the store skips
+func (s *Store) Verify() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.loadValues()
+}
+
+// DerivedKey returns a key for purpose derived from the store's data key,
+// e.g. to key hashes of secrets that must not be testable without
+// unlocking the store. A store that was never saved is saved first so it
+// has a data key. The key changes when the store is rekeyed.
+func (s *Store) DerivedKey(purpose string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dataKey == nil {
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(s.dataKey.String()))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// KeyMode returns how the store is keyed, or an empty mode if it was not
+// opened through an Unlocker
+func (s *Store) KeyMode() KeyMode {
+	if s.keyInfo == nil {
+		return ""
+	}
+	return s.keyInfo.Mode
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.path
 }
 
 // Count returns the number of stored secrets
 func (s *Store) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return len(s.secrets)
 }
@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStoreConcurrentAccess(t *testing.T) {
+	store, err := NewStoreAt("test-pass", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStoreAt() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Set(fmt.Sprintf("KEY_%d", i), "value"); err != nil {
+				t.Errorf("Set() error = %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.Get("KEY_0")
+				store.List()
+				store.GetAll()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if store.Count() != 4 {
+		t.Errorf("Count() = %d, want 4", store.Count())
+	}
+
+	reopened, err := NewStoreAt("test-pass", store.path)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	if reopened.Count() != 4 {
+		t.Errorf("reopened Count() = %d, want 4", reopened.Count())
+	}
+}
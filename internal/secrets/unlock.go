@@ -0,0 +1,618 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"filippo.io/age/plugin"
+)
+
+// ErrMachineIDChanged indicates a machine-keyed store was encrypted with a
+// different machine ID than the one this machine reports now
+var ErrMachineIDChanged = errors.New("machine ID has changed since the store was encrypted")
+
+// ErrPassphraseRequired indicates a store needs a passphrase but the
+// Unlocker is not allowed to prompt for one
+var ErrPassphraseRequired = errors.New("store is protected by a passphrase")
+
+// Unlocker opens stores with the key their header names, so the global
+// and project stores can be keyed differently. It only asks for a key when
+// a store needs one, and reuses passphrases that worked for other stores.
+// It is safe for concurrent use.
+type Unlocker struct {
+	// Prompt asks for a passphrase. Nil means never prompt.
+	Prompt func(prompt string) (string, error)
+	// Passphrase is tried on passphrase-keyed stores before prompting
+	Passphrase string
+	// PassphraseProvider supplies passphrases instead of Prompt, e.g.
+	// PassphraseFD. If nil, passphrase_command in ~/.alex/config.json is
+	// used when set.
+	PassphraseProvider KeyProvider
+	// PreferPassphrase keys new stores, and tries stores without a header,
+	// with a passphrase instead of the machine ID. It is also implied by
+	// use_passphrase in ~/.alex/config.json.
+	PreferPassphrase bool
+	// NoCommands skips passphrase_command and age plugins, which may
+	// prompt on their own
+	NoCommands bool
+	// PluginUI handles prompts and messages from age plugins
+	PluginUI *plugin.ClientUI
+	// Recovery unlocks stores through their recovery key slot
+	Recovery *Key
+	// MachineID replaces the machine ID of this machine, e.g. one read
+	// with MachineIDFromFile after /etc/machine-id was regenerated
+	MachineID *MachineIDResult
+	// OnWeakMachineID is called once if the machine key comes from the
+	// hostname+username fallback
+	OnWeakMachineID func()
+
+	mu          sync.Mutex
+	machine     *MachineIDResult
+	passphrases []*Key
+	providers   map[string]KeyProvider
+	// masters are unlocked master keys by public key
+	masters map[string]*Key
+}
+
+// OpenGlobal opens the global store
+func (u *Unlocker) OpenGlobal() (*Store, error) {
+	dir, err := GetGlobalDir()
+	if err != nil {
+		return nil, err
+	}
+	return u.Open(dir)
+}
+
+// OpenProject opens the store of the current project and records which
+// project it belongs to
+func (u *Unlocker) OpenProject() (*Store, error) {
+	return u.OpenProjectScope(ResolveProject().Info())
+}
+
+// OpenProjectScope opens the store of project, e.g. a layer returned by
+// Project.Layers, and records which project it belongs to
+func (u *Unlocker) OpenProjectScope(project ProjectInfo) (*Store, error) {
+	store, err := u.OpenProjectByID(project.ID())
+	if err != nil {
+		return nil, err
+	}
+	store.project = &project
+	store.recordProject()
+	return store, nil
+}
+
+// OpenProjectByID opens the store of a project ID (as returned by GetProjectID)
+func (u *Unlocker) OpenProjectByID(projectID string) (*Store, error) {
+	dir, err := GetProjectsDir()
+	if err != nil {
+		return nil, err
+	}
+	return u.Open(filepath.Join(dir, projectID))
+}
+
+// Open opens the store in dir
+func (u *Unlocker) Open(dir string) (*Store, error) {
+	info, err := ReadKeyInfo(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		return u.openKeyed(dir, info)
+	}
+
+	mode := u.newStoreMode(dir)
+
+	if _, err := os.Stat(filepath.Join(dir, secretsFile)); errors.Is(err, os.ErrNotExist) {
+		// New store: the header is written along with the first secret
+		if mode == KeyModeMachine {
+			return u.openMachine(dir, nil, "", true)
+		}
+		provider, err := u.provider(dir, mode, nil)
+		if err != nil {
+			return nil, err
+		}
+		return u.openWithProvider(dir, nil, provider, "", true)
+	}
+
+	// Store from before key headers existed: try the likely key and record
+	// whichever one works
+	passphrase := u.passphraseProvider(dir)
+	if mode != KeyModePassphrase {
+		store, err := u.openMachine(dir, nil, "", false)
+		if !errors.Is(err, ErrWrongPassphrase) || passphrase == nil {
+			return store, err
+		}
+	}
+	return u.openWithProvider(dir, nil, passphrase, "", false)
+}
+
+// openKeyed opens a store with its primary key or, if that fails, with
+// the first of its extra key slots that works
+func (u *Unlocker) openKeyed(dir string, info *KeyInfo) (*Store, error) {
+	store, err := u.openSlot(dir, info, "")
+	if err == nil {
+		return store, nil
+	}
+	for i := range info.Slots {
+		slot := &info.Slots[i]
+		if store, slotErr := u.openSlot(dir, slot, slot.Name); slotErr == nil {
+			store.keyInfo = info
+			return store, nil
+		}
+	}
+	return nil, err
+}
+
+// openSlot opens a store with the key recorded in info, which is the
+// store's header for the primary key or one of its slots
+func (u *Unlocker) openSlot(dir string, info *KeyInfo, slot string) (*Store, error) {
+	if slot == "" && info.Master != "" {
+		// Another store already unlocked the master key
+		if master := u.cachedMaster(info.Master); master != nil {
+			store, err := openStore(master, dir, "")
+			if err != nil {
+				return nil, err
+			}
+			store.keyInfo = info
+			return store, nil
+		}
+	}
+
+	switch info.Mode {
+	case KeyModeMachine:
+		return u.openMachine(dir, info, slot, false)
+	case KeyModePassphrase, KeyModeIdentity, KeyModeSSH, KeyModePlugin:
+		provider, err := u.provider(dir, info.Mode, info)
+		if err != nil {
+			return nil, err
+		}
+		return u.openWithProvider(dir, info, provider, slot, false)
+	case KeyModeRecovery:
+		if u.Recovery == nil {
+			return nil, fmt.Errorf("%w: %s needs the recovery key", ErrPassphraseRequired, storeLabel(dir))
+		}
+		store, err := openStore(u.Recovery, dir, slot)
+		if err != nil {
+			return nil, err
+		}
+		store.keyInfo = info
+		return store, nil
+	default:
+		return nil, fmt.Errorf("store %s uses key mode %q, which this version of alex doesn't support", dir, info.Mode)
+	}
+}
+
+// newStoreMode returns the key mode new stores in dir get
+func (u *Unlocker) newStoreMode(dir string) KeyMode {
+	if u.PreferPassphrase {
+		return KeyModePassphrase
+	}
+	config := LoadConfig()
+	if mode := config.Stores[storeName(dir)].KeyProvider; mode != "" {
+		return mode
+	}
+	if config.KeyProvider != "" {
+		return config.KeyProvider
+	}
+	if config.UsePassphrase {
+		return KeyModePassphrase
+	}
+	return KeyModeMachine
+}
+
+// provider returns the key provider for a store in dir keyed with mode.
+// Key files come from the store's entry in config.json, then the store's
+// header, then the default settings in config.json. Key slots use the
+// key file they were added with first.
+func (u *Unlocker) provider(dir string, mode KeyMode, recorded *KeyInfo) (KeyProvider, error) {
+	if mode == KeyModePassphrase {
+		return u.passphraseProvider(dir), nil
+	}
+
+	config := LoadConfig()
+	var path string
+	if recorded != nil && recorded.Name != "" {
+		path = recorded.Identity
+	}
+	if path == "" {
+		path = config.Stores[storeName(dir)].identityPath(mode)
+	}
+	if path == "" && recorded != nil {
+		path = recorded.Identity
+	}
+	if path == "" {
+		path = config.identityPath(mode)
+	}
+	if path == "" && mode == KeyModeSSH {
+		path = defaultSSHKey()
+	}
+
+	switch mode {
+	case KeyModeIdentity, KeyModeSSH, KeyModePlugin:
+		if path == "" {
+			return nil, fmt.Errorf("key provider %q for %s needs a key file: set %s in ~/.alex/config.json", mode, storeLabel(dir), providerSetting(mode))
+		}
+	default:
+		return nil, fmt.Errorf("unknown key provider %q for %s (want machine, passphrase, identity, ssh or plugin)", mode, storeLabel(dir))
+	}
+	if mode == KeyModePlugin && u.NoCommands {
+		return nil, fmt.Errorf("%w: %s needs an age plugin", ErrPassphraseRequired, storeLabel(dir))
+	}
+	return u.keyFileProvider(mode, path)
+}
+
+// keyFileProvider returns the provider of an identity, ssh or plugin key
+// file. Providers load their key once, so they are shared between stores.
+func (u *Unlocker) keyFileProvider(mode KeyMode, path string) (KeyProvider, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	id := string(mode) + ":" + path
+	if provider, ok := u.providers[id]; ok {
+		return provider, nil
+	}
+	var provider KeyProvider
+	switch mode {
+	case KeyModeIdentity:
+		provider = IdentityFile(path)
+	case KeyModeSSH:
+		provider = SSHKey(path, u.Prompt)
+	case KeyModePlugin:
+		provider = AgePlugin(path, u.PluginUI)
+	default:
+		return nil, fmt.Errorf("key provider %q has no key file", mode)
+	}
+	if u.providers == nil {
+		u.providers = make(map[string]KeyProvider)
+	}
+	u.providers[id] = provider
+	return provider, nil
+}
+
+// passphraseProvider returns where passphrases for the store in dir come
+// from, or nil if there is no way to get one
+func (u *Unlocker) passphraseProvider(dir string) KeyProvider {
+	if u.PassphraseProvider != nil {
+		return u.PassphraseProvider
+	}
+	if !u.NoCommands {
+		config := LoadConfig()
+		command := config.Stores[storeName(dir)].PassphraseCommand
+		if command == "" {
+			command = config.PassphraseCommand
+		}
+		if command != "" {
+			return PassphraseCommand(command)
+		}
+	}
+	if u.Prompt != nil {
+		return PromptPassphrase(u.Prompt)
+	}
+	return nil
+}
+
+// providerSetting names the config.json setting with the key file for mode
+func providerSetting(mode KeyMode) string {
+	switch mode {
+	case KeyModeIdentity:
+		return "identity_file"
+	case KeyModeSSH:
+		return "ssh_key"
+	default:
+		return "plugin_identity"
+	}
+}
+
+// openMachine opens a store with the machine key. info is the recorded
+// header or key slot, if any; newStore defers writing the header to the
+// first save. Stores keyed with the machine ID alone are moved to the
+// machine ID plus install secret key the first time they are opened, and
+// so are their migration backups, keyed like they were.
+func (u *Unlocker) openMachine(dir string, info *KeyInfo, slot string, newStore bool) (*Store, error) {
+	machine, err := u.machineID()
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := slot == "" && !newStore && (info == nil || info.MachineKeyVersion < machineKeyV2)
+	installSecret, err := loadInstallSecret(newStore || legacy)
+	if err != nil {
+		return nil, err
+	}
+	key := machineKey(machine.ID, installSecret)
+	current := machineKeyInfo(machine, installSecret)
+
+	if !legacy {
+		storeKey, err := PassphraseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		storeKey.mode = KeyModeMachine
+		if newStore {
+			store, err := NewStoreWithKey(nil, dir)
+			if err != nil {
+				return nil, err
+			}
+			store.newKey = func() (*Key, error) {
+				if master := u.withMaster(KeyModeMachine, storeKey, current); master != storeKey {
+					store.keyInfo = keyHeader(master)
+					return master, nil
+				}
+				return storeKey, nil
+			}
+			return u.recordKeyInfo(store, info, current, true)
+		}
+
+		unlockKey, err := u.unlockKey(storeKey, current, info, slot)
+		if err == nil {
+			var store *Store
+			if store, err = openStore(unlockKey, dir, slot); err == nil {
+				if store, err = u.recordKeyInfo(store, info, current, false); err == nil {
+					u.upgradeToMaster(store, storeKey, current)
+					return store, nil
+				}
+			}
+		}
+		if errors.Is(err, ErrWrongPassphrase) && info != nil {
+			err = explainMachineKeyMismatch(info, current)
+		}
+		return nil, err
+	}
+
+	store, err := NewStoreAt(machine.ID, dir)
+	if errors.Is(err, ErrWrongPassphrase) && info != nil {
+		err = explainMachineKeyMismatch(info, current)
+	}
+	if err != nil {
+		return nil, err
+	}
+	storeKey, err := PassphraseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	storeKey.mode = KeyModeMachine
+	legacyKey := store.key
+	if err := store.RekeyWith(storeKey); err != nil {
+		return nil, fmt.Errorf("moving store to the install secret key: %w", err)
+	}
+	if info != nil {
+		current.Slots = info.Slots
+	}
+	if err := WriteKeyInfo(dir, current); err != nil {
+		return nil, fmt.Errorf("writing key header: %w", err)
+	}
+	rekeyMigrationBackups(dir, legacyKey, storeKey)
+	store.keyInfo = current
+	u.upgradeToMaster(store, storeKey, current)
+	return store, nil
+}
+
+// explainMachineKeyMismatch turns a failed machine-key unlock into a
+// precise error when the header shows which input changed
+func explainMachineKeyMismatch(recorded, current *KeyInfo) error {
+	switch {
+	case recorded.MachineCheck != "" && recorded.MachineCheck != current.MachineCheck:
+		return fmt.Errorf("%w (store was keyed from %s, this machine reads %s): restore the original machine ID or recover the store from a backup",
+			ErrMachineIDChanged, sourceOrUnknown(recorded.MachineSource), sourceOrUnknown(current.MachineSource))
+	case recorded.MachineKeyVersion >= machineKeyV2 && recorded.InstallCheck != "" && recorded.InstallCheck != current.InstallCheck:
+		path, _ := InstallSecretPath()
+		return fmt.Errorf("%w (%s): restore the original install secret or recover the store from a backup", ErrInstallSecretChanged, path)
+	default:
+		return ErrWrongPassphrase
+	}
+}
+
+// openWithProvider opens a store with a key from provider. recorded is
+// the store's header or key slot, if any; newStore defers getting the key
+// to the first save, so read-only commands never ask for one.
+func (u *Unlocker) openWithProvider(dir string, recorded *KeyInfo, provider KeyProvider, slot string, newStore bool) (*Store, error) {
+	if newStore {
+		store, err := NewStoreWithKey(nil, dir)
+		if err != nil {
+			return nil, err
+		}
+		store.newKey = func() (*Key, error) {
+			key, err := u.newStoreKey(dir, provider)
+			if err != nil {
+				return nil, err
+			}
+			store.keyInfo = providerKeyInfo(key)
+			if key.mode == KeyModePassphrase {
+				if master := u.withMaster(KeyModePassphrase, key, store.keyInfo); master != key {
+					store.keyInfo = keyHeader(master)
+					return master, nil
+				}
+			}
+			return key, nil
+		}
+		// Without a provider only known passphrases can key the store
+		mode := KeyModePassphrase
+		if provider != nil {
+			mode = provider.Mode()
+		}
+		return u.recordKeyInfo(store, nil, &KeyInfo{Version: keyInfoVersion, Mode: mode}, true)
+	}
+
+	if provider == nil || provider.Mode() == KeyModePassphrase {
+		for _, key := range u.knownPassphrases() {
+			store, err := u.openWithKey(dir, recorded, key, slot)
+			if !errors.Is(err, ErrWrongPassphrase) {
+				return store, err
+			}
+		}
+	}
+
+	key, err := u.providerKey(dir, provider)
+	if err != nil {
+		return nil, err
+	}
+	store, err := u.openWithKey(dir, recorded, key, slot)
+	if err != nil {
+		return nil, err
+	}
+	if key.mode == KeyModePassphrase {
+		u.rememberPassphrase(key)
+	}
+	return store, nil
+}
+
+// openWithKey opens a store with a key from a provider, through the
+// passphrase's master key if the store has one
+func (u *Unlocker) openWithKey(dir string, recorded *KeyInfo, key *Key, slot string) (*Store, error) {
+	current := providerKeyInfo(key)
+	unlockKey, err := u.unlockKey(key, current, recorded, slot)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openStore(unlockKey, dir, slot)
+	if err != nil {
+		return nil, err
+	}
+	if store, err = u.recordKeyInfo(store, recorded, current, false); err != nil {
+		return nil, err
+	}
+	if key.mode == KeyModePassphrase {
+		u.upgradeToMaster(store, key, current)
+	}
+	return store, nil
+}
+
+// newStoreKey returns the key for a new store: a passphrase that already
+// worked for another store, or a key from provider
+func (u *Unlocker) newStoreKey(dir string, provider KeyProvider) (*Key, error) {
+	if provider == nil || provider.Mode() == KeyModePassphrase {
+		if known := u.knownPassphrases(); len(known) > 0 {
+			return known[0], nil
+		}
+	}
+	key, err := u.providerKey(dir, provider)
+	if err == nil && key.mode == KeyModePassphrase {
+		u.rememberPassphrase(key)
+	}
+	return key, err
+}
+
+func (u *Unlocker) providerKey(dir string, provider KeyProvider) (*Key, error) {
+	if provider == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPassphraseRequired, storeLabel(dir))
+	}
+	return provider.Key(storeLabel(dir))
+}
+
+// recordKeyInfo makes sure the store's header matches the key that opened it
+func (u *Unlocker) recordKeyInfo(store *Store, recorded, current *KeyInfo, newStore bool) (*Store, error) {
+	if store.slot != "" {
+		// Key slots are recorded in the header of the primary key
+		return store, nil
+	}
+	store.keyInfo = current
+	if recorded != nil && recorded.Mode == current.Mode {
+		store.keyInfo = recorded
+		return store, nil
+	}
+	if newStore {
+		store.keyInfoPending = true
+		return store, nil
+	}
+	if recorded != nil {
+		current.Slots = recorded.Slots
+	}
+	if err := WriteKeyInfo(store.path, current); err != nil {
+		return nil, fmt.Errorf("writing key header: %w", err)
+	}
+	return store, nil
+}
+
+func (u *Unlocker) machineID() (*MachineIDResult, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.machine == nil && u.MachineID != nil {
+		u.machine = u.MachineID
+	}
+	if u.machine == nil {
+		machine, err := GetMachineID()
+		if err != nil {
+			return nil, err
+		}
+		u.machine = machine
+		if machine.UsedFallback && u.OnWeakMachineID != nil {
+			u.OnWeakMachineID()
+		}
+	}
+	return u.machine, nil
+}
+
+func (u *Unlocker) knownPassphrases() []*Key {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	known := append([]*Key(nil), u.passphrases...)
+	if u.Passphrase != "" {
+		if key, err := PassphraseKey(u.Passphrase); err == nil {
+			known = append(known, key)
+		}
+	}
+	return known
+}
+
+func (u *Unlocker) rememberPassphrase(key *Key) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.passphrases = append(u.passphrases, key)
+}
+
+// storeLabel names the store in dir for prompts and errors
+func storeLabel(dir string) string {
+	if globalDir, err := GetGlobalDir(); err == nil && filepath.Clean(dir) == globalDir {
+		return "global store"
+	}
+	if name := groupName(dir); name != "" {
+		return "group store " + name
+	}
+	return "project store " + filepath.Base(dir)
+}
+
+// storeName is the key of the store in dir in the stores section of
+// config.json: "global", "group:NAME" or the project ID
+func storeName(dir string) string {
+	if globalDir, err := GetGlobalDir(); err == nil && filepath.Clean(dir) == globalDir {
+		return "global"
+	}
+	if name := groupName(dir); name != "" {
+		return "group:" + name
+	}
+	return filepath.Base(dir)
+}
+
+func sourceOrUnknown(source string) string {
+	if source == "" {
+		return "an unknown source"
+	}
+	return source
+}
+
+// LoadConfig reads ~/.alex/config.json. A missing or unreadable file
+// yields the defaults.
+func LoadConfig() Config {
+	var config Config
+	dir, err := GetGlobalDir()
+	if err != nil {
+		return config
+	}
+	data, err := os.ReadFile(filepath.Join(dir, configFile))
+	if err != nil {
+		return config
+	}
+	json.Unmarshal(data, &config)
+	return config
+}
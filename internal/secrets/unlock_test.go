@@ -0,0 +1,268 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// isolateHome points HOME and the install secret at temporary locations
+func isolateHome(t testing.TB) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(installSecretEnv, filepath.Join(home, "state", installSecretFile))
+}
+
+// countingPrompt returns a prompt that answers with passphrase and counts calls
+func countingPrompt(passphrase string, calls *int) func(string) (string, error) {
+	return func(string) (string, error) {
+		*calls++
+		return passphrase, nil
+	}
+}
+
+func TestUnlockerNewMachineStoreWritesHeader(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if info, _ := ReadKeyInfo(dir); info != nil {
+		t.Fatal("header written before the first secret was saved")
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	info, err := ReadKeyInfo(dir)
+	if err != nil || info == nil || info.Mode != KeyModeMachine || info.MachineCheck == "" {
+		t.Fatalf("ReadKeyInfo() = %+v, %v; want machine header", info, err)
+	}
+}
+
+func TestUnlockerPassphraseStores(t *testing.T) {
+	isolateHome(t)
+	first := filepath.Join(t.TempDir(), "first")
+	second := filepath.Join(t.TempDir(), "second")
+
+	var calls int
+	u := &Unlocker{Prompt: countingPrompt("hunter2", &calls), PreferPassphrase: true}
+	for _, dir := range []string{first, second} {
+		store, err := u.Open(dir)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", dir, err)
+		}
+		if err := store.Set("KEY", "value"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("prompted %d times for two new stores, want 1", calls)
+	}
+
+	// A fresh unlocker finds passphrase mode in the header without the flag
+	calls = 0
+	u = &Unlocker{Prompt: countingPrompt("hunter2", &calls)}
+	for _, dir := range []string{first, second} {
+		store, err := u.Open(dir)
+		if err != nil {
+			t.Fatalf("reopening %s: %v", dir, err)
+		}
+		if store.KeyMode() != KeyModePassphrase {
+			t.Errorf("KeyMode() = %q, want passphrase", store.KeyMode())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("prompted %d times to reopen two stores, want 1", calls)
+	}
+
+	if _, err := (&Unlocker{}).Open(first); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Open() without prompt error = %v, want ErrPassphraseRequired", err)
+	}
+}
+
+func TestUnlockerLegacyPassphraseStore(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "legacy")
+
+	legacy, err := NewStoreAt("old-passphrase", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	store, err := (&Unlocker{Prompt: countingPrompt("old-passphrase", &calls)}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" || calls != 1 {
+		t.Errorf("Get() = %q after %d prompt(s), want value after 1", value, calls)
+	}
+
+	info, _ := ReadKeyInfo(dir)
+	if info == nil || info.Mode != KeyModePassphrase {
+		t.Errorf("header after first unlock = %+v, want passphrase mode", info)
+	}
+}
+
+func TestUnlockerMachineIDChanged(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "moved")
+
+	// Simulate a store encrypted on a machine with another ID
+	store, err := NewStoreAt("key-from-another-machine", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	info := &KeyInfo{Version: keyInfoVersion, Mode: KeyModeMachine, MachineCheck: keyCheck("machine", "key-from-another-machine"), MachineSource: "/etc/machine-id"}
+	if err := WriteKeyInfo(dir, info); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&Unlocker{}).Open(dir)
+	if !errors.Is(err, ErrMachineIDChanged) {
+		t.Errorf("Open() error = %v, want ErrMachineIDChanged", err)
+	}
+}
+
+func TestUnlockerUpgradesLegacyMachineKey(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "legacy-machine")
+
+	machine, err := GetMachineID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A store keyed with the machine ID alone, as before install secrets
+	legacy, err := NewStoreAt(machine.ID, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Set("KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyInfo(dir, &KeyInfo{Version: keyInfoVersion, Mode: KeyModeMachine, MachineCheck: keyCheck("machine", machine.ID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q after upgrade", value)
+	}
+	if info, _ := ReadKeyInfo(dir); info.MachineKeyVersion != machineKeyV2 || info.InstallCheck == "" {
+		t.Errorf("header after upgrade = %+v, want machine key v2", info)
+	}
+	if _, err := NewStoreAt(machine.ID, dir); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("machine ID alone still opens the store (err = %v)", err)
+	}
+
+	// Replacing the install secret is reported precisely
+	path, _ := InstallSecretPath()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrInstallSecretMissing) {
+		t.Errorf("Open() without install secret error = %v, want ErrInstallSecretMissing", err)
+	}
+	if _, err := loadInstallSecret(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Unlocker{}).Open(dir); !errors.Is(err, ErrInstallSecretChanged) {
+		t.Errorf("Open() with new install secret error = %v, want ErrInstallSecretChanged", err)
+	}
+}
+
+func TestUnlockerUpgradeRekeysBackups(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "legacy-v1")
+
+	machine, err := GetMachineID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A v1 store keyed with the machine ID alone: migrating it keeps a
+	// backup that only the machine ID protects
+	writeV1Store(t, dir, machine.ID, map[string]string{"KEY": "value"})
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get() = %q after upgrade", value)
+	}
+	if backups, _ := migrationBackups(dir); len(backups) != 1 {
+		t.Errorf("backups after moving to the install secret = %v, want the v1 backup", backups)
+	}
+	if weak, err := WeakBackups(dir); err != nil || len(weak) != 0 {
+		t.Errorf("WeakBackups() = %v, %v, want none after moving it along", weak, err)
+	}
+
+	// One left behind keyed the old way, e.g. because rekeying it failed
+	old := filepath.Join(t.TempDir(), "old")
+	writeV1Store(t, old, machine.ID, map[string]string{"KEY": "value"})
+	data, err := os.ReadFile(filepath.Join(old, secretsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(migrationBackupPath(dir, formatV1), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if weak, err := WeakBackups(dir); err != nil || len(weak) != 1 {
+		t.Errorf("WeakBackups() = %v, %v, want the v1 backup", weak, err)
+	}
+}
+
+func TestUnlockerUpgradeThenRollback(t *testing.T) {
+	isolateHome(t)
+	dir := filepath.Join(t.TempDir(), "legacy-v1")
+
+	machine, err := GetMachineID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeV1Store(t, dir, machine.ID, map[string]string{"KEY": "value"})
+
+	store, err := (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := store.Set("LATER", "lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := RollbackMigration(dir)
+	if err != nil {
+		t.Fatalf("RollbackMigration() error = %v", err)
+	}
+	if version != formatV1 {
+		t.Errorf("RollbackMigration() = v%d, want v%d", version, formatV1)
+	}
+
+	store, err = (&Unlocker{}).Open(dir)
+	if err != nil {
+		t.Fatalf("Open() after rollback error = %v", err)
+	}
+	if value, _, _ := store.Get("KEY"); value != "value" {
+		t.Errorf("Get(KEY) = %q after rollback", value)
+	}
+	if _, ok, _ := store.Get("LATER"); ok {
+		t.Error("secret set after the migration survived the rollback")
+	}
+	if info, _ := ReadKeyInfo(dir); info == nil || info.MachineKeyVersion != machineKeyV2 {
+		t.Errorf("key header after rollback = %+v, want a v2 machine key", info)
+	}
+}
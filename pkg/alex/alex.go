@@ -0,0 +1,238 @@
+// Package alex gives Go programs access to alex-managed secrets under the
+// same rules as the alex CLI: the global store, the project's groups and
+// its stores are layered as alex run layers them, more specific layers
+// taking precedence, and commands are checked against the suspicious
+// command policy before secrets are injected into them.
+//
+//	s, err := alex.Open(alex.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	dsn, err := s.Get("DATABASE_URL")
+//
+// Tests can use alextest.NewStore with New instead of the stores on disk.
+package alex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/portdeveloper/alex/internal/secrets"
+)
+
+// Scope identifies where a secret is stored. Besides ScopeGlobal and
+// ScopeProject, Open gives scopes such as "group:NAME" for the groups the
+// project's manifest lists, "project:DIR" for the store of a subdirectory
+// and "parent" for the project a submodule is checked out in, as 'alex
+// list' shows them.
+type Scope string
+
+const (
+	// ScopeGlobal secrets are shared by every project (~/.alex/)
+	ScopeGlobal Scope = "global"
+	// ScopeProject secrets belong to the current project and override
+	// global and group secrets with the same name
+	ScopeProject Scope = "project"
+)
+
+// ErrNotFound is returned when a secret is in no scope
+var ErrNotFound = errors.New("secret not found")
+
+// ErrUnreadable is returned when a store lists a secret but cannot return
+// its value, e.g. because the store file is damaged
+var ErrUnreadable = errors.New("secret cannot be read")
+
+// Timestamps records when a secret was created and last updated
+type Timestamps struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Store is a single scope of secrets. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Get returns the value of a secret
+	Get(name string) (value string, ok bool)
+	// List returns the names of all secrets with their timestamps
+	List() map[string]Timestamps
+}
+
+// Secret describes a stored secret. It never carries the value.
+type Secret struct {
+	Name  string
+	Scope Scope
+	Timestamps
+	// Shadowed is set on secrets overridden by a more specific scope
+	Shadowed bool
+}
+
+// Options configures Open
+type Options struct {
+	// Passphrase unlocks stores keyed with a passphrase. Stores keyed
+	// with the machine ID don't need it. Open never prompts and never
+	// runs passphrase_command or age plugins: stores that need them, or
+	// a passphrase other than this one, fail to open.
+	Passphrase string
+}
+
+// Secrets is the layered view of the scopes that apply in a project. It
+// is safe for concurrent use.
+type Secrets struct {
+	// layers go from the least to the most specific
+	layers []layer
+}
+
+type layer struct {
+	scope Scope
+	store Store
+}
+
+// Open opens the stores 'alex run' injects in the working directory: the
+// global store, the groups the project's manifest lists, and the project's
+// stores down to the working directory. Groups that are not on this
+// machine are skipped. It fails if any store cannot be decrypted in full.
+func Open(opts Options) (*Secrets, error) {
+	unlocker := &secrets.Unlocker{Passphrase: opts.Passphrase, NoCommands: true}
+
+	opened, _, err := unlocker.OpenLayers(secrets.ResolveProject())
+	if err != nil {
+		return nil, err
+	}
+	s := &Secrets{}
+	for _, l := range opened {
+		if err := l.Store.Verify(); err != nil {
+			return nil, fmt.Errorf("opening %s store: %w", l.Scope, err)
+		}
+		s.layers = append(s.layers, layer{scope: Scope(l.Scope), store: diskStore{l.Store}})
+	}
+	return s, nil
+}
+
+// New returns a view over the given stores, with project secrets
+// overriding global ones. Either may be nil.
+func New(global, project Store) *Secrets {
+	s := &Secrets{}
+	if global != nil {
+		s.layers = append(s.layers, layer{scope: ScopeGlobal, store: global})
+	}
+	if project != nil {
+		s.layers = append(s.layers, layer{scope: ScopeProject, store: project})
+	}
+	return s
+}
+
+// Names returns the sorted names of all secrets, each listed once
+func (s *Secrets) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, l := range s.layers {
+		for name := range l.store.List() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns every stored secret, from the least specific scope to the
+// most, starting with global. Secrets overridden by a more specific scope
+// are included with Shadowed set.
+func (s *Secrets) List() []Secret {
+	lists := make([]map[string]Timestamps, len(s.layers))
+	for i, l := range s.layers {
+		lists[i] = l.store.List()
+	}
+
+	var list []Secret
+	for i, l := range s.layers {
+		for _, name := range sortedNames(lists[i]) {
+			shadowed := false
+			for _, later := range lists[i+1:] {
+				if _, ok := later[name]; ok {
+					shadowed = true
+					break
+				}
+			}
+			list = append(list, Secret{Name: name, Scope: l.scope, Timestamps: lists[i][name], Shadowed: shadowed})
+		}
+	}
+	return list
+}
+
+// Lookup returns the metadata of the secret Get would return
+func (s *Secrets) Lookup(name string) (Secret, bool) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		if ts, ok := s.layers[i].store.List()[name]; ok {
+			return Secret{Name: name, Scope: s.layers[i].scope, Timestamps: ts}, true
+		}
+	}
+	return Secret{}, false
+}
+
+// Get returns the value of a secret from the most specific scope that has
+// it. It returns ErrNotFound if no scope has it and ErrUnreadable if the
+// store that has it cannot return its value.
+func (s *Secrets) Get(name string) (string, error) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		store := s.layers[i].store
+		if value, ok := store.Get(name); ok {
+			return value, nil
+		}
+		if _, listed := store.List()[name]; listed {
+			return "", fmt.Errorf("%w: %s", ErrUnreadable, name)
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Environ returns every secret value as it would be injected by alex run,
+// with more specific scopes overriding less specific ones. It returns
+// ErrUnreadable if the scope a secret is injected from cannot return its
+// value, rather than a value from a less specific scope.
+func (s *Secrets) Environ() (map[string]string, error) {
+	env := make(map[string]string)
+	for _, l := range s.layers {
+		for name := range l.store.List() {
+			value, ok := l.store.Get(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s (%s)", ErrUnreadable, name, l.scope)
+			}
+			env[name] = value
+		}
+	}
+	return env, nil
+}
+
+func sortedNames(m map[string]Timestamps) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diskStore adapts an encrypted store on disk to Store
+type diskStore struct {
+	store *secrets.Store
+}
+
+func (d diskStore) Get(name string) (string, bool) {
+	// Open verified the store; a value that still cannot be read is
+	// reported by Secrets.Get as ErrUnreadable
+	value, ok, err := d.store.Get(name)
+	return value, ok && err == nil
+}
+
+func (d diskStore) List() map[string]Timestamps {
+	list := make(map[string]Timestamps)
+	for name, secret := range d.store.List() {
+		list[name] = Timestamps{CreatedAt: secret.CreatedAt, UpdatedAt: secret.UpdatedAt}
+	}
+	return list
+}
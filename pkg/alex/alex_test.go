@@ -0,0 +1,228 @@
+package alex_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/portdeveloper/alex/internal/manifest"
+	"github.com/portdeveloper/alex/internal/secrets"
+	"github.com/portdeveloper/alex/pkg/alex"
+	"github.com/portdeveloper/alex/pkg/alex/alextest"
+)
+
+func newTestSecrets() *alex.Secrets {
+	global := alextest.NewStore(map[string]string{
+		"OPENAI_KEY":   "sk-global",
+		"DATABASE_URL": "postgres://global",
+	})
+	project := alextest.NewStore(map[string]string{
+		"DATABASE_URL": "postgres://project",
+		"STRIPE_KEY":   "sk_test_project",
+	})
+	return alex.New(global, project)
+}
+
+func TestScopeRules(t *testing.T) {
+	s := newTestSecrets()
+
+	if got, err := s.Get("DATABASE_URL"); err != nil || got != "postgres://project" {
+		t.Errorf("Get(DATABASE_URL) = %q, %v; want project value", got, err)
+	}
+	if got, err := s.Get("OPENAI_KEY"); err != nil || got != "sk-global" {
+		t.Errorf("Get(OPENAI_KEY) = %q, %v; want global value", got, err)
+	}
+	if _, err := s.Get("MISSING"); !errors.Is(err, alex.ErrNotFound) {
+		t.Errorf("Get(MISSING) error = %v, want ErrNotFound", err)
+	}
+
+	if secret, ok := s.Lookup("DATABASE_URL"); !ok || secret.Scope != alex.ScopeProject {
+		t.Errorf("Lookup(DATABASE_URL) = %+v, %v; want project scope", secret, ok)
+	}
+
+	want := map[string]string{
+		"OPENAI_KEY":   "sk-global",
+		"DATABASE_URL": "postgres://project",
+		"STRIPE_KEY":   "sk_test_project",
+	}
+	if got, err := s.Environ(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestNamesAndList(t *testing.T) {
+	s := newTestSecrets()
+
+	wantNames := []string{"DATABASE_URL", "OPENAI_KEY", "STRIPE_KEY"}
+	if got := s.Names(); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("Names() = %v, want %v", got, wantNames)
+	}
+
+	var got []string
+	for _, secret := range s.List() {
+		entry := string(secret.Scope) + ":" + secret.Name
+		if secret.Shadowed {
+			entry += " (shadowed)"
+		}
+		got = append(got, entry)
+	}
+	want := []string{
+		"global:DATABASE_URL (shadowed)",
+		"global:OPENAI_KEY",
+		"project:DATABASE_URL",
+		"project:STRIPE_KEY",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestOpenLayersLikeRun(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ALEX_INSTALL_SECRET_FILE", filepath.Join(home, "install-secret"))
+	checkout := t.TempDir()
+	if err := os.WriteFile(filepath.Join(checkout, ".alexroot"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&manifest.Manifest{Groups: []string{"staging"}}).Save(checkout); err != nil {
+		t.Fatal(err)
+	}
+	workdir := filepath.Join(checkout, "web")
+	if err := os.Mkdir(workdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(workdir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	u := &secrets.Unlocker{}
+	set := func(store *secrets.Store, err error, name, value string) {
+		t.Helper()
+		if err == nil {
+			err = store.Set(name, value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := secrets.CreateGroup("staging"); err != nil {
+		t.Fatal(err)
+	}
+	store, err := u.OpenGroup("staging")
+	set(store, err, "REGISTRY_TOKEN", "group")
+	store, err = u.OpenGlobal()
+	set(store, err, "API_URL", "global")
+	project := secrets.ResolveProject()
+	store, err = u.OpenProjectScope(project.Info())
+	set(store, err, "API_URL", "project")
+	store, err = u.OpenProjectScope(project.Scope("web"))
+	set(store, err, "PORT", "3000")
+
+	s, err := alex.Open(alex.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	want := map[string]string{"API_URL": "project", "REGISTRY_TOKEN": "group", "PORT": "3000"}
+	if got, err := s.Environ(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %v, %v; want %v", got, err, want)
+	}
+	if secret, ok := s.Lookup("PORT"); !ok || secret.Scope != "project:web" {
+		t.Errorf("Lookup(PORT) = %+v, %v; want scope project:web", secret, ok)
+	}
+	if secret, ok := s.Lookup("REGISTRY_TOKEN"); !ok || secret.Scope != "group:staging" {
+		t.Errorf("Lookup(REGISTRY_TOKEN) = %+v, %v; want scope group:staging", secret, ok)
+	}
+}
+
+func TestNilStores(t *testing.T) {
+	s := alex.New(nil, alextest.NewStore(map[string]string{"KEY": "value"}))
+	if got, err := s.Get("KEY"); err != nil || got != "value" {
+		t.Errorf("Get(KEY) = %q, %v", got, err)
+	}
+	if len(alex.New(nil, nil).Names()) != 0 {
+		t.Error("empty view has names")
+	}
+}
+
+// unreadableStore lists its secrets but cannot return their values, like
+// a store whose values section is damaged
+type unreadableStore struct{}
+
+func (unreadableStore) Get(name string) (string, bool) { return "", false }
+
+func (unreadableStore) List() map[string]alex.Timestamps {
+	return map[string]alex.Timestamps{"KEY": {}}
+}
+
+func TestUnreadableSecret(t *testing.T) {
+	s := alex.New(alextest.NewStore(map[string]string{"KEY": "global"}), unreadableStore{})
+	if _, err := s.Get("KEY"); !errors.Is(err, alex.ErrUnreadable) {
+		t.Errorf("Get(KEY) error = %v, want ErrUnreadable rather than the global value", err)
+	}
+	if _, err := s.Get("MISSING"); !errors.Is(err, alex.ErrNotFound) {
+		t.Errorf("Get(MISSING) error = %v, want ErrNotFound", err)
+	}
+	if env, err := s.Environ(); !errors.Is(err, alex.ErrUnreadable) {
+		t.Errorf("Environ() = %v, %v; want ErrUnreadable rather than the global value", env, err)
+	}
+	if _, err := s.Command(context.Background(), "go", "version"); !errors.Is(err, alex.ErrUnreadable) {
+		t.Errorf("Command() error = %v, want ErrUnreadable", err)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	var policyErr *alex.PolicyError
+	if err := alex.CheckCommand([]string{"env"}); !errors.As(err, &policyErr) || !errors.Is(err, alex.ErrSuspiciousCommand) {
+		t.Errorf("CheckCommand(env) = %v, want PolicyError", err)
+	}
+	if err := alex.CheckCommand([]string{"go", "build", "./..."}); err != nil {
+		t.Errorf("CheckCommand(go build) = %v, want nil", err)
+	}
+}
+
+func TestCommandInjectsSecrets(t *testing.T) {
+	s := newTestSecrets()
+
+	cmd, err := s.Command(context.Background(), "git", "status")
+	if err != nil {
+		t.Fatalf("Command() error = %v", err)
+	}
+	var found []string
+	for _, entry := range cmd.Env {
+		if strings.HasPrefix(entry, "DATABASE_URL=") || strings.HasPrefix(entry, "STRIPE_KEY=") {
+			found = append(found, entry)
+		}
+	}
+	want := []string{"DATABASE_URL=postgres://project", "STRIPE_KEY=sk_test_project"}
+	sort.Strings(found)
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("injected %v, want %v", found, want)
+	}
+
+	if _, err := s.Command(context.Background(), "printenv"); !errors.Is(err, alex.ErrSuspiciousCommand) {
+		t.Errorf("Command(printenv) error = %v, want ErrSuspiciousCommand", err)
+	}
+}
+
+func TestFakeStoreKeepsCreatedAt(t *testing.T) {
+	store := alextest.NewStore(map[string]string{"KEY": "one"})
+	before := store.List()["KEY"]
+	store.Set("KEY", "two")
+	after := store.List()["KEY"]
+
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Error("Set changed CreatedAt of an existing secret")
+	}
+	store.Delete("KEY")
+	if _, ok := store.Get("KEY"); ok {
+		t.Error("Delete left the secret in place")
+	}
+}
@@ -0,0 +1,76 @@
+// Package alextest provides an in-memory alex.Store for tests
+package alextest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/portdeveloper/alex/pkg/alex"
+)
+
+// Store is an in-memory alex.Store. It is safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex
+	values  map[string]string
+	times   map[string]alex.Timestamps
+	nowFunc func() time.Time
+}
+
+// NewStore returns a store holding values
+func NewStore(values map[string]string) *Store {
+	s := &Store{
+		values:  make(map[string]string),
+		times:   make(map[string]alex.Timestamps),
+		nowFunc: time.Now,
+	}
+	for name, value := range values {
+		s.Set(name, value)
+	}
+	return s
+}
+
+// Set stores a secret, keeping its creation time if it already exists
+func (s *Store) Set(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.nowFunc()
+	ts, exists := s.times[name]
+	if !exists {
+		ts.CreatedAt = now
+	}
+	ts.UpdatedAt = now
+
+	s.values[name] = value
+	s.times[name] = ts
+}
+
+// Delete removes a secret
+func (s *Store) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, name)
+	delete(s.times, name)
+}
+
+// Get implements alex.Store
+func (s *Store) Get(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// List implements alex.Store
+func (s *Store) List() map[string]alex.Timestamps {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make(map[string]alex.Timestamps, len(s.times))
+	for name, ts := range s.times {
+		list[name] = ts
+	}
+	return list
+}
@@ -0,0 +1,83 @@
+package alex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/portdeveloper/alex/internal/runner"
+)
+
+// ErrSuspiciousCommand is wrapped by PolicyError
+var ErrSuspiciousCommand = errors.New("suspicious command")
+
+// PolicyError reports a command the policy refuses to inject secrets into,
+// such as one that would print the environment
+type PolicyError struct {
+	Args   []string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", ErrSuspiciousCommand, strings.Join(e.Args, " "), e.Reason)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrSuspiciousCommand
+}
+
+// CheckCommand evaluates args against the policy alex run uses. It returns
+// nil or a *PolicyError.
+func CheckCommand(args []string) error {
+	if suspicious, reason := runner.IsSuspicious(args); suspicious {
+		return &PolicyError{Args: args, Reason: reason}
+	}
+	return nil
+}
+
+// Command returns a command with every secret added to the current
+// environment. Commands rejected by CheckCommand return its error; callers
+// that want to run them anyway (like alex run --force) can build their own
+// exec.Cmd from Environ.
+func (s *Secrets) Command(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	if err := CheckCommand(args); err != nil {
+		return nil, err
+	}
+
+	env, err := s.Environ()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	return cmd, nil
+}
+
+// Run runs a command with secrets injected, connected to the standard
+// streams of the current process, and waits for it to finish
+func (s *Secrets) Run(ctx context.Context, args ...string) error {
+	cmd, err := s.Command(ctx, args...)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("%w: %s", runner.ErrCommandNotFound, args[0])
+		}
+		return err
+	}
+	return nil
+}